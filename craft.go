@@ -5,15 +5,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"craft-cli/internal/audit"
+	"craft-cli/internal/config"
+	ctxmgr "craft-cli/internal/context"
+	"craft-cli/internal/logger"
+	"craft-cli/internal/prompt"
+	"craft-cli/internal/replay"
+	"craft-cli/internal/telemetry"
+	"craft-cli/internal/trace"
+
 	"github.com/joho/godotenv"
 )
 
@@ -29,15 +40,29 @@ type Tool struct {
 type GroqClient struct {
 	apiKey  string
 	baseURL string
+	models  []string
 	client  *http.Client
 	tools   []Tool
+	repoMap func() string // outline of the indexed project for the system prompt
+
+	// onDelta, when set, makes replies stream: it receives each piece of
+	// assistant text as it arrives
+	onDelta func(turn int, text string)
 }
 
 func NewGroqClient() *GroqClient {
+	// provider.base_url (or GROQ_BASE_URL) can point at any OpenAI-compatible server, e.g. internal/mockllm
+	cfg := config.Get()
 	return &GroqClient{
-		apiKey:  os.Getenv("GROQ_API_KEY"),
-		baseURL: "https://api.groq.com/openai/v1",
-		client:  &http.Client{Timeout: 120 * time.Second},
+		apiKey:  cfg.APIKey(),
+		baseURL: strings.TrimSuffix(cfg.Provider.BaseURL, "/"),
+		models:  append([]string{cfg.Models.Default}, cfg.Models.Fallback...),
+		client: &http.Client{
+			Timeout: cfg.Provider.RequestTimeout,
+			// CRAFT_RECORD/CRAFT_REPLAY capture or serve fixtures; the system
+			// prompt embeds the cwd so it is left out of the request hash.
+			Transport: replay.FromEnv(http.DefaultTransport, "messages.0.content"),
+		},
 	}
 }
 
@@ -61,9 +86,15 @@ type Function struct {
 }
 
 type ChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Tools    []ToolDef `json:"tools,omitempty"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Tools         []ToolDef      `json:"tools,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type ToolDef struct {
@@ -78,13 +109,59 @@ type FunctionDef struct {
 }
 
 type ChatResponse struct {
+	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type Choice struct {
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// ChatChunk is one server-sent event of a streamed completion
+type ChatChunk struct {
 	Choices []struct {
-		Message      Message `json:"message"`
-		FinishReason string  `json:"finish_reason"`
+		Index int `json:"index"`
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+	XGroq *struct {
+		Usage *Usage `json:"usage"`
+	} `json:"x_groq"` // Groq reports usage here in the last chunk
 	Error *struct {
 		Message string `json:"message"`
-	} `json:"error,omitempty"`
+	} `json:"error"`
+}
+
+// Usage reports token counts for a single completion
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *Usage) add(o *Usage) {
+	if o == nil {
+		return
+	}
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.TotalTokens
 }
 
 // Initialize tools
@@ -127,7 +204,9 @@ func (g *GroqClient) initTools() {
 				if dir != "." && dir != "/" {
 					os.MkdirAll(dir, 0755)
 				}
+				change := audit.FileChange("write_file", path)
 				err := os.WriteFile(path, []byte(content), 0644)
+				change.Done(err)
 				if err != nil {
 					return fmt.Sprintf("Error writing file: %v", err)
 				}
@@ -174,14 +253,24 @@ func (g *GroqClient) initTools() {
 			Execute: func(args map[string]interface{}) string {
 				command := args["command"].(string)
 				// Safety: block dangerous commands
-				dangerous := []string{"rm -rf /", "mkfs", ":(){ :|:& };:", "dd if=/dev/zero"}
-				for _, d := range dangerous {
+				policy := config.Get()
+				for _, d := range policy.Tools.BlockedCommands {
 					if strings.Contains(command, d) {
+						audit.Decision("bash", command, audit.Denied, "policy", fmt.Sprintf("matches tools.blocked_commands entry %q", d))
 						return "Error: Dangerous command blocked for safety"
 					}
 				}
-				cmd := exec.Command("bash", "-c", command)
+				if policy.SudoRefused(command) {
+					audit.Decision("bash", command, audit.Denied, "policy", "sudo is not allowed (tools.allow_sudo)")
+					return "Error: sudo commands are restricted"
+				}
+				audit.Decision("bash", command, audit.Approved, "policy", "not matched by tools.blocked_commands or tools.allow_sudo")
+				ctx, cancel := context.WithTimeout(context.Background(), policy.Limits.BashTimeout)
+				defer cancel()
+				cmd := exec.CommandContext(ctx, "bash", "-c", command)
+				start := time.Now()
 				output, err := cmd.CombinedOutput()
+				audit.Command("bash", command, time.Since(start), err)
 				if err != nil {
 					return fmt.Sprintf("Error: %v\nOutput: %s", err, string(output))
 				}
@@ -225,9 +314,156 @@ func (g *GroqClient) initTools() {
 	}
 }
 
+// addSearchTool registers search_codebase over the loaded context index
+func (g *GroqClient) addSearchTool(graph *ctxmgr.Graph) {
+	g.tools = append(g.tools, Tool{
+		Name:        "search_codebase",
+		Description: "Search the indexed codebase by meaning and keywords. Returns ranked snippets with file, line range and score. Prefer this over grep to find where something is implemented.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query":    map[string]string{"type": "string", "description": "What to look for, in words or identifiers"},
+				"k":        map[string]string{"type": "integer", "description": "Number of results (default 5, at most 20)"},
+				"path":     map[string]string{"type": "string", "description": "Only search paths matching this glob or directory, e.g. internal/** or *.go"},
+				"language": map[string]string{"type": "string", "description": "Only search files in this language, e.g. go, python, typescript"},
+				"package":  map[string]string{"type": "string", "description": "Only search this monorepo package, by directory or name from go.mod, package.json, Cargo.toml or pyproject.toml"},
+			},
+			"required": []string{"query"},
+		},
+		Execute: func(args map[string]interface{}) string {
+			query, _ := args["query"].(string)
+			if strings.TrimSpace(query) == "" {
+				return "Error: query must be a non-empty string"
+			}
+			k := 5
+			if v, ok := args["k"].(float64); ok && v >= 1 {
+				k = min(int(v), 20)
+			}
+			var filter ctxmgr.SearchFilter
+			path, _ := args["path"].(string)
+			filter.Path = ctxmgr.ProjectPattern(path)
+			filter.Language, _ = args["language"].(string)
+			filter.Package, _ = args["package"].(string)
+			results, err := graph.SearchFiltered(query, k, filter)
+			if err != nil {
+				// Embedding the query failed; keyword results are still useful
+				results = graph.SearchLexicalFiltered(query, k, filter)
+			}
+			if len(results) == 0 {
+				return "No matches found"
+			}
+			return ctxmgr.FormatResults(results, 40)
+		},
+	})
+}
+
+// addRememberTool registers remember, which saves a memory and makes it
+// retrievable from graph right away
+func (g *GroqClient) addRememberTool(graph *ctxmgr.Graph) {
+	g.tools = append(g.tools, Tool{
+		Name:        "remember",
+		Description: "Save a fact to remember in future sessions, such as a user preference or a project convention. Relevant memories are added to later prompts automatically.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"fact":  map[string]string{"type": "string", "description": "The fact, as one self-contained sentence"},
+				"scope": map[string]interface{}{"type": "string", "enum": []string{ctxmgr.MemoryProject, ctxmgr.MemoryUser}, "description": "project (default) for this repository, user for every project"},
+			},
+			"required": []string{"fact"},
+		},
+		Execute: func(args map[string]interface{}) string {
+			fact, _ := args["fact"].(string)
+			scope, _ := args["scope"].(string)
+			if scope == "" {
+				scope = ctxmgr.MemoryProject
+			}
+			m, err := ctxmgr.Remember(scope, fact)
+			if err != nil {
+				return fmt.Sprintf("Error: %v", err)
+			}
+			reloadMemories(graph)
+			return fmt.Sprintf("Remembered as %s (%s)", m.ID, m.Scope)
+		},
+	})
+}
+
+// addSymbolTools registers find_symbol and find_references over the symbol
+// table of the loaded context index
+func (g *GroqClient) addSymbolTools(graph *ctxmgr.Graph) {
+	nameParam := map[string]string{"type": "string", "description": "Symbol name, e.g. Search or Graph.Search"}
+	g.tools = append(g.tools, Tool{
+		Name:        "find_symbol",
+		Description: "Find where a function, method, type or variable is declared. Returns its kind, file, line range, signature and doc comment. Use Type.Method for methods.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": nameParam,
+				"kind": map[string]interface{}{"type": "string", "enum": []string{ctxmgr.KindFunc, ctxmgr.KindMethod, ctxmgr.KindType, ctxmgr.KindValue}, "description": "Only return declarations of this kind"},
+				"path": map[string]string{"type": "string", "description": "Only search paths matching this glob or directory"},
+			},
+			"required": []string{"name"},
+		},
+		Execute: func(args map[string]interface{}) string {
+			name, _ := args["name"].(string)
+			if strings.TrimSpace(name) == "" {
+				return "Error: name must be a non-empty string"
+			}
+			var filter ctxmgr.SearchFilter
+			path, _ := args["path"].(string)
+			filter.Path = ctxmgr.ProjectPattern(path)
+			kind, _ := args["kind"].(string)
+			symbols, exact := graph.FindSymbol(name, filter)
+			kept := symbols[:0]
+			for _, s := range symbols {
+				if kind == "" || s.Kind == kind {
+					kept = append(kept, s)
+				}
+			}
+			if len(kept) == 0 {
+				return fmt.Sprintf("No symbol named %s found", name)
+			}
+			if !exact {
+				return fmt.Sprintf("No symbol named %s; similar names:\n%s", name, ctxmgr.FormatSymbols(kept))
+			}
+			return ctxmgr.FormatSymbols(kept)
+		},
+	}, Tool{
+		Name:        "find_references",
+		Description: "Find the lines that use a function, method, type or variable. Go uses are resolved with type information; for other languages, imports are resolved and other mentions of the name are marked [name match].",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": nameParam,
+				"path": map[string]string{"type": "string", "description": "Only report references in paths matching this glob or directory"},
+			},
+			"required": []string{"name"},
+		},
+		Execute: func(args map[string]interface{}) string {
+			name, _ := args["name"].(string)
+			if strings.TrimSpace(name) == "" {
+				return "Error: name must be a non-empty string"
+			}
+			var filter ctxmgr.SearchFilter
+			path, _ := args["path"].(string)
+			filter.Path = ctxmgr.ProjectPattern(path)
+			defs, refs := graph.FindReferences(name, filter)
+			if len(defs) == 0 {
+				if similar, _ := graph.FindSymbol(name, ctxmgr.SearchFilter{}); len(similar) > 0 {
+					return fmt.Sprintf("No symbol named %s; similar names:\n%s", name, ctxmgr.FormatSymbols(similar))
+				}
+				return fmt.Sprintf("No symbol named %s found", name)
+			}
+			return ctxmgr.FormatReferences(defs, refs)
+		},
+	})
+}
+
 func (g *GroqClient) toToolDefs() []ToolDef {
 	var defs []ToolDef
 	for _, t := range g.tools {
+		if config.Get().ToolDisabled(t.Name) {
+			continue
+		}
 		defs = append(defs, ToolDef{
 			Type: "function",
 			Function: FunctionDef{
@@ -240,34 +476,133 @@ func (g *GroqClient) toToolDefs() []ToolDef {
 	return defs
 }
 
-func (g *GroqClient) executeTool(name string, args string) string {
+func (g *GroqClient) executeTool(ctx context.Context, name string, args string) string {
+	_, span := telemetry.Start(ctx, "execute_tool "+name, telemetry.KindInternal,
+		telemetry.String(telemetry.KeyOperation, "execute_tool"),
+		telemetry.String(telemetry.KeyTool, name))
+	result := g.runTool(name, args)
+	span.SetAttributes(telemetry.Int(telemetry.KeyBytes, len(result)))
+	if name == "bash" {
+		span.SetAttributes(telemetry.Int(telemetry.KeyExitCode, bashExitCode(result)))
+	}
+	if strings.HasPrefix(result, "Error") || strings.HasPrefix(result, "Unknown tool") {
+		span.SetStatus(telemetry.StatusError, firstLine(result))
+	}
+	span.End()
+	return result
+}
+
+func (g *GroqClient) runTool(name string, args string) string {
 	var parsed map[string]interface{}
 	if err := json.Unmarshal([]byte(args), &parsed); err != nil {
 		return fmt.Sprintf("Error parsing arguments: %v", err)
 	}
 	for _, t := range g.tools {
-		if t.Name == name {
+		if t.Name == name && !config.Get().ToolDisabled(name) {
 			return t.Execute(parsed)
 		}
 	}
 	return fmt.Sprintf("Unknown tool: %s", name)
 }
 
+// bashExitCode reads the exit status from a bash tool result, which reports
+// failures as "Error: exit status N"; -1 means the command did not run to
+// completion (blocked, timed out or killed)
+func bashExitCode(result string) int {
+	if !strings.HasPrefix(result, "Error") {
+		return 0
+	}
+	var code int
+	if _, err := fmt.Sscanf(result, "Error: exit status %d", &code); err == nil {
+		return code
+	}
+	return -1
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// Chat sends the conversation to the default model, falling back to the
+// next configured model when one is rate limited.
 func (g *GroqClient) Chat(ctx context.Context, messages []Message) (*ChatResponse, error) {
+	var lastErr error
+	for _, model := range g.models {
+		result, status, err := g.chatModel(ctx, model, messages)
+		if status == http.StatusTooManyRequests {
+			lastErr = err
+			continue
+		}
+		return result, err
+	}
+	return nil, lastErr
+}
+
+func (g *GroqClient) chatModel(ctx context.Context, model string, messages []Message) (*ChatResponse, int, error) {
 	reqBody := ChatRequest{
-		Model:    "llama-3.1-8b-instant",
+		Model:    model,
 		Messages: messages,
 		Tools:    g.toToolDefs(),
 	}
+	if g.onDelta != nil {
+		reqBody.Stream = true
+		reqBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	turn := trace.TurnFrom(ctx)
+	ctx, span := telemetry.Start(ctx, "chat "+model, telemetry.KindClient,
+		telemetry.String(telemetry.KeyOperation, "chat"),
+		telemetry.String(telemetry.KeySystem, config.Get().Provider.Name),
+		telemetry.String(telemetry.KeyModel, model),
+		telemetry.Int(telemetry.KeyTurn, turn))
+	defer span.End()
+	trace.Record(trace.Event{Type: trace.TypeRequest, Turn: turn, Model: model, Body: jsonBody})
+	start := time.Now()
+	result, body, status, err := g.post(ctx, model, jsonBody)
+	e := trace.Event{Turn: turn, Model: model, Status: status, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		e.Type, e.Error = trace.TypeError, err.Error()
+	} else {
+		e.Type, e.Body = trace.TypeResponse, body
+	}
+	trace.Record(e)
+
+	if status != 0 {
+		span.SetAttributes(telemetry.Int(telemetry.KeyStatusCode, status))
+	}
+	if err != nil {
+		span.SetError(err)
+		if status != 0 {
+			span.SetAttributes(telemetry.String(telemetry.KeyErrorType, strconv.Itoa(status)))
+		}
+	} else {
+		if u := result.Usage; u != nil {
+			span.SetAttributes(telemetry.Int(telemetry.KeyInputTokens, u.PromptTokens), telemetry.Int(telemetry.KeyOutputTokens, u.CompletionTokens))
+		}
+		if len(result.Choices) > 0 {
+			reasons := make([]string, len(result.Choices))
+			for i, c := range result.Choices {
+				reasons[i] = c.FinishReason
+			}
+			span.SetAttributes(telemetry.Strings(telemetry.KeyFinishReason, reasons...))
+		}
 	}
+	return result, status, err
+}
 
+// post sends a chat request and decodes the reply, returning the raw body
+// as well for the trace. A streamed reply is put back together into one
+// response, whose JSON stands in for the body.
+func (g *GroqClient) post(ctx context.Context, model string, jsonBody []byte) (*ChatResponse, []byte, int, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+g.apiKey)
@@ -275,70 +610,582 @@ func (g *GroqClient) Chat(ctx context.Context, messages []Message) (*ChatRespons
 
 	resp, err := g.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == 200 && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		result, err := g.readStream(trace.TurnFrom(ctx), resp.Body)
+		if err != nil {
+			return nil, nil, resp.StatusCode, err
+		}
+		body, err := json.Marshal(result)
+		return result, body, resp.StatusCode, err
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, resp.StatusCode, err
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, nil, resp.StatusCode, fmt.Errorf("API error %d (%s): %s", resp.StatusCode, model, string(body))
 	}
 
 	var result ChatResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
+		return nil, nil, resp.StatusCode, err
 	}
 
 	if result.Error != nil {
-		return nil, fmt.Errorf("API error: %s", result.Error.Message)
+		return nil, nil, resp.StatusCode, fmt.Errorf("API error: %s", result.Error.Message)
 	}
 
-	return &result, nil
+	return &result, body, resp.StatusCode, nil
 }
 
-func getSystemPrompt() string {
-	cwd, _ := os.Getwd()
-	return fmt.Sprintf(`You are CRAFT CLI, an AI coding assistant with direct access to the local filesystem.
-You have access to these tools:
-- read_file: Read file contents
-- write_file: Write/create files  
-- list_dir: List directory contents
-- bash: Execute shell commands
-- grep: Search file contents
+// readStream reads the server-sent events of a streamed completion, passing
+// text to onDelta as it arrives, and returns the whole response
+func (g *GroqClient) readStream(turn int, r io.Reader) (*ChatResponse, error) {
+	var result ChatResponse
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 8<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue // blank separators, comments and other fields
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return &result, nil
+		}
+		var chunk ChatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("API error: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			result.Usage = chunk.Usage
+		} else if chunk.XGroq != nil && chunk.XGroq.Usage != nil {
+			result.Usage = chunk.XGroq.Usage
+		}
+		for _, c := range chunk.Choices {
+			for len(result.Choices) <= c.Index {
+				result.Choices = append(result.Choices, Choice{Message: Message{Role: "assistant"}})
+			}
+			choice := &result.Choices[c.Index]
+			if c.FinishReason != nil {
+				choice.FinishReason = *c.FinishReason
+			}
+			if c.Delta.Content != "" {
+				choice.Message.Content += c.Delta.Content
+				if c.Index == 0 {
+					g.onDelta(turn, c.Delta.Content)
+				}
+			}
+			// A tool call arrives as its id and name, then pieces of the arguments
+			for _, d := range c.Delta.ToolCalls {
+				calls := &choice.Message.ToolCalls
+				for len(*calls) <= d.Index {
+					*calls = append(*calls, ToolCall{Type: "function"})
+				}
+				tc := &(*calls)[d.Index]
+				if d.ID != "" {
+					tc.ID = d.ID
+				}
+				if d.Type != "" {
+					tc.Type = d.Type
+				}
+				tc.Function.Name += d.Function.Name
+				tc.Function.Arguments += d.Function.Arguments
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("stream ended before [DONE]")
+}
 
-Current working directory: %s
-When you need to explore or modify files, use the tools directly. Always confirm successful file operations.`, cwd)
+// toolInfo lists the enabled tools for the system prompt and banner
+func (g *GroqClient) toolInfo() []prompt.Tool {
+	var tools []prompt.Tool
+	for _, t := range g.tools {
+		if !config.Get().ToolDisabled(t.Name) {
+			tools = append(tools, prompt.Tool{Name: t.Name, Description: t.Description})
+		}
+	}
+	return tools
+}
+
+func getSystemPrompt(client *GroqClient) string {
+	var repoMap string
+	if client.repoMap != nil {
+		repoMap = client.repoMap()
+	}
+	text, err := prompt.Build(client.toolInfo(), repoMap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v (using built-in prompt template)\n", err)
+	}
+	return text
+}
+
+// Event is emitted by the agent loop for every step of a turn
+type Event struct {
+	Type       string `json:"type"`
+	Turn       int    `json:"turn,omitempty"`
+	Content    string `json:"content,omitempty"`
+	Tool       string `json:"tool,omitempty"`
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	Arguments  string `json:"arguments,omitempty"`
+	Usage      *Usage `json:"usage,omitempty"`
+}
+
+// Result is the final object written in json and stream-json output modes
+type Result struct {
+	Type       string `json:"type"`
+	Result     string `json:"result"`
+	IsError    bool   `json:"is_error"`
+	Error      string `json:"error,omitempty"`
+	NumTurns   int    `json:"num_turns"`
+	DurationMS int64  `json:"duration_ms"`
+	Usage      Usage  `json:"usage"`
+}
+
+// runAgent keeps calling the model until it stops requesting tools.
+// It returns the updated history, the final assistant text and the number of model calls made.
+// The whole turn is one telemetry span, parent of the model call and tool spans.
+func runAgent(ctx context.Context, client *GroqClient, history []Message, maxTurns int, emit func(Event)) ([]Message, string, int, error) {
+	if len(history) > 0 {
+		trace.Prompt(history[len(history)-1].Content)
+	}
+	ctx, span := telemetry.Start(ctx, "turn", telemetry.KindInternal)
+	var usage Usage
+	toolCalls := 0
+	history, answer, turns, err := agentLoop(ctx, client, history, maxTurns, func(e Event) {
+		switch e.Type {
+		case "usage":
+			usage.add(e.Usage)
+		case "tool_call":
+			toolCalls++
+		}
+		emit(e)
+	})
+	span.SetAttributes(
+		telemetry.Int(telemetry.KeyModelCalls, turns),
+		telemetry.Int(telemetry.KeyToolCalls, toolCalls),
+		telemetry.Int(telemetry.KeyInputTokens, usage.PromptTokens),
+		telemetry.Int(telemetry.KeyOutputTokens, usage.CompletionTokens))
+	span.SetError(err)
+	span.End()
+	return history, answer, turns, err
+}
+
+// agentLoop is the body of runAgent
+func agentLoop(ctx context.Context, client *GroqClient, history []Message, maxTurns int, emit func(Event)) ([]Message, string, int, error) {
+	for turn := 1; ; turn++ {
+		if maxTurns > 0 && turn > maxTurns {
+			err := fmt.Errorf("stopped after %d turns without a final answer", maxTurns)
+			trace.Record(trace.Event{Type: trace.TypeError, Turn: turn - 1, Error: err.Error()})
+			return history, "", turn - 1, err
+		}
+		emit(Event{Type: "turn_start", Turn: turn})
+		start := time.Now()
+		resp, err := client.Chat(trace.WithTurn(ctx, turn), history)
+		if err != nil {
+			logger.Error("model call failed", logger.Turn(turn), logger.Duration(time.Since(start)), logger.Err(err))
+			return history, "", turn, err
+		}
+		if len(resp.Choices) == 0 {
+			err := fmt.Errorf("API returned no choices")
+			trace.Record(trace.Event{Type: trace.TypeError, Turn: turn, Error: err.Error()})
+			return history, "", turn, err
+		}
+		if resp.Usage != nil {
+			emit(Event{Type: "usage", Turn: turn, Usage: resp.Usage})
+			logger.Info("model call", logger.Turn(turn), logger.Duration(time.Since(start)), logger.Tokens(resp.Usage.TotalTokens))
+		} else {
+			logger.Info("model call", logger.Turn(turn), logger.Duration(time.Since(start)))
+		}
+
+		assistantMsg := resp.Choices[0].Message
+		history = append(history, assistantMsg)
+		if assistantMsg.Content != "" {
+			emit(Event{Type: "assistant", Turn: turn, Content: assistantMsg.Content})
+		}
+
+		// Final response
+		if len(assistantMsg.ToolCalls) == 0 {
+			return history, assistantMsg.Content, turn, nil
+		}
+
+		// Execute tools and add results to history
+		for _, tc := range assistantMsg.ToolCalls {
+			emit(Event{Type: "tool_call", Turn: turn, Tool: tc.Function.Name, ToolCallID: tc.ID, Arguments: tc.Function.Arguments})
+			logger.Debug("tool call", logger.Turn(turn), logger.Tool(tc.Function.Name), "arguments", tc.Function.Arguments)
+			trace.Record(trace.Event{Type: trace.TypeToolCall, Turn: turn, Tool: tc.Function.Name, CallID: tc.ID, Arguments: tc.Function.Arguments})
+			toolStart := time.Now()
+			result := client.executeTool(ctx, tc.Function.Name, tc.Function.Arguments)
+			elapsed, failed := time.Since(toolStart), strings.HasPrefix(result, "Error")
+			logger.Info("tool finished", logger.Turn(turn), logger.Tool(tc.Function.Name), logger.Duration(elapsed), "failed", failed)
+			trace.Record(trace.Event{Type: trace.TypeToolResult, Turn: turn, Tool: tc.Function.Name, CallID: tc.ID, Text: result, Failed: failed, DurationMS: elapsed.Milliseconds()})
+			emit(Event{Type: "tool_result", Turn: turn, Tool: tc.Function.Name, ToolCallID: tc.ID, Content: result})
+
+			history = append(history, Message{
+				Role:       "tool",
+				Content:    result,
+				ToolCallID: tc.ID,
+			})
+		}
+	}
+}
+
+// loadContextIndex opens the project's context index and adds the saved
+// memories to it; indexed reports whether there was an index
+func loadContextIndex() (ws *ctxmgr.Workspace, indexed bool) {
+	ws = ctxmgr.NewWorkspace()
+	ws.Load()
+	indexed = ws.Graph.Len() > 0
+	reloadMemories(ws.Graph)
+	return ws, indexed
+}
+
+// reloadMemories replaces the memory nodes of graph with the saved
+// memories and returns them
+func reloadMemories(graph *ctxmgr.Graph) []ctxmgr.Memory {
+	memories, err := ctxmgr.LoadMemories()
+	if err == nil {
+		err = graph.SetMemories(memories)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return memories
+}
+
+// memoryCommand runs /remember and /memory, reloading graph after a change
+func memoryCommand(graph *ctxmgr.Graph, input string) {
+	if text, ok := strings.CutPrefix(input, "/remember"); ok {
+		scope := ctxmgr.MemoryProject
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(text), "--user"); ok {
+			scope, text = ctxmgr.MemoryUser, rest
+		}
+		if strings.TrimSpace(text) == "" {
+			fmt.Println("Usage: /remember [--user] <fact>")
+			return
+		}
+		m, err := ctxmgr.Remember(scope, text)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+		reloadMemories(graph)
+		fmt.Printf("🧠 Remembered (%s): %s\n", m.Scope, m.ID)
+		return
+	}
+
+	memories, err := ctxmgr.LoadMemories()
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	fields := strings.Fields(strings.TrimPrefix(input, "/memory"))
+	if len(fields) == 0 || fields[0] == "list" {
+		if len(memories) == 0 {
+			fmt.Println("No memories yet; save one with /remember <fact>")
+			return
+		}
+		fmt.Println(ctxmgr.FormatMemories(memories))
+		return
+	}
+	if len(fields) < 2 || (fields[0] != "forget" && fields[0] != "edit") || (fields[0] == "edit" && len(fields) < 3) {
+		fmt.Println("Usage: /memory [list] | /memory edit <n|id> <text> | /memory forget <n|id>")
+		return
+	}
+	m, err := ctxmgr.FindMemory(memories, fields[1])
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	if fields[0] == "forget" {
+		err = m.Forget()
+	} else {
+		err = m.Edit(strings.Join(fields[2:], " "))
+	}
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	reloadMemories(graph)
+	fmt.Printf("🧠 %s: %s\n", map[string]string{"forget": "Forgot", "edit": "Updated"}[fields[0]], m.ID)
+}
+
+// retrieve packs the indexed snippets most relevant to input into a context
+// block; it returns nil when nothing relevant was found
+func retrieve(graph *ctxmgr.Graph, input string) *ctxmgr.Injection {
+	if graph == nil {
+		return nil
+	}
+	r := config.Get().Retrieval
+	inj := graph.Inject(input, r.Results, r.Memories, r.BudgetTokens)
+	if inj.Block == "" {
+		return nil
+	}
+	return inj
+}
+
+// withContext prepends an injected context block to a user message
+func withContext(input string, inj *ctxmgr.Injection) string {
+	if inj == nil {
+		return input
+	}
+	return inj.Block + "\n\n" + input
+}
+
+// runHeadless answers a single prompt without a TTY and exits.
+// Output formats: text (final answer only), json (final Result object) and
+// stream-json (one Event per line followed by the Result). stream-json
+// streams the model's replies: assistant_delta events carry the text as it
+// arrives, before the assistant event with the whole message. With a
+// context graph, retrieved snippets are prepended to the prompt.
+func runHeadless(client *GroqClient, graph *ctxmgr.Graph, prompt, format string, maxTurns int) int {
+	// Piped input is appended to the prompt so `cat file | craft -p "review"` works
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+			return 1
+		}
+		if stdin := strings.TrimSpace(string(data)); stdin != "" {
+			if prompt == "" {
+				prompt = stdin
+			} else {
+				prompt += "\n\n" + stdin
+			}
+		}
+	}
+	if strings.TrimSpace(prompt) == "" {
+		fmt.Fprintln(os.Stderr, "Error: empty prompt (pass -p or pipe input on stdin)")
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	var usage Usage
+	emit := func(e Event) {
+		if e.Type == "usage" {
+			usage.add(e.Usage)
+		}
+		if format == "stream-json" {
+			enc.Encode(e)
+		}
+	}
+	if format == "stream-json" {
+		client.onDelta = func(turn int, text string) {
+			emit(Event{Type: "assistant_delta", Turn: turn, Content: text})
+		}
+	}
+
+	inj := retrieve(graph, prompt)
+	if inj != nil {
+		emit(Event{Type: "context", Content: strings.Join(inj.Locations(), "\n")})
+	}
+	history := []Message{
+		{Role: "system", Content: getSystemPrompt(client)},
+		{Role: "user", Content: withContext(prompt, inj)},
+	}
+
+	start := time.Now()
+	_, answer, turns, err := runAgent(context.Background(), client, history, maxTurns, emit)
+	res := Result{
+		Type:       "result",
+		Result:     answer,
+		NumTurns:   turns,
+		DurationMS: time.Since(start).Milliseconds(),
+		Usage:      usage,
+	}
+	if err != nil {
+		res.IsError = true
+		res.Error = err.Error()
+	}
+
+	switch format {
+	case "json", "stream-json":
+		enc.Encode(res)
+	default:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		} else {
+			fmt.Println(answer)
+		}
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
 }
 
 func main() {
-	godotenv.Load()
+	// The config, .env, index and memories are the project's, wherever in
+	// it craft is started; tools work in the current directory
+	godotenv.Load(filepath.Join(config.ProjectRoot(), ".env"))
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	if err := logger.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not initialize logger: %v\n", err)
+	}
+	defer logger.Close()
+	if err := telemetry.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not initialize telemetry: %v\n", err)
+	}
+	defer telemetry.Shutdown()
+
+	prompt := flag.String("p", "", "Run a single prompt non-interactively and exit")
+	outputFormat := flag.String("output-format", "text", "Headless output format: text, json or stream-json")
+	maxTurns := flag.Int("max-turns", cfg.Limits.MaxTurns, "Maximum model calls per headless prompt (0 for no limit)")
+	model := flag.String("model", cfg.Models.Default, "Model to use")
+	noContext := flag.Bool("no-context", !cfg.Retrieval.Auto, "Do not inject snippets from the context index")
+	traceFlag := flag.Bool("trace", cfg.Trace.Enabled, "Record model requests, responses and tool calls (see 'craft trace')")
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		var err error
+		switch f.Name {
+		case "model":
+			err = cfg.Set("models.default", *model, config.SourceFlag)
+		case "trace":
+			err = cfg.Set("trace.enabled", fmt.Sprint(*traceFlag), config.SourceFlag)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(2)
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(2)
+	}
+	if err := trace.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not start tracing: %v\n", err)
+	}
+	defer trace.Close()
 
 	client := NewGroqClient()
 	client.initTools()
 
-	if client.apiKey == "" {
-		fmt.Println("❌ Set GROQ_API_KEY environment variable")
+	ws, indexed := loadContextIndex()
+	graph := ws.Graph
+	if indexed {
+		client.addSearchTool(graph)
+		client.addSymbolTools(graph)
+		if cfg.Retrieval.RepoMap {
+			client.repoMap = func() string { return ws.RepoMap(cfg.Retrieval.RepoMapTokens) }
+		}
+	}
+	client.addRememberTool(graph)
+	// -no-context only turns off injection; the tools stay available
+	injectGraph := graph
+	if *noContext {
+		injectGraph = nil
+	}
+
+	headless := *prompt != "" || flag.NArg() > 0
+	if *prompt == "" && flag.NArg() > 0 {
+		*prompt = strings.Join(flag.Args(), " ")
+	}
+
+	if cfg.MissingAPIKey() && !replay.Enabled() {
+		if headless {
+			fmt.Fprintf(os.Stderr, "Error: set %s environment variable\n", cfg.Provider.APIKeyEnv)
+		} else {
+			fmt.Printf("❌ Set %s environment variable\n", cfg.Provider.APIKeyEnv)
+		}
 		os.Exit(1)
 	}
 
+	if headless {
+		switch *outputFormat {
+		case "text", "json", "stream-json":
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unknown output format %q (want text, json or stream-json)\n", *outputFormat)
+			os.Exit(2)
+		}
+		code := runHeadless(client, injectGraph, *prompt, *outputFormat, *maxTurns)
+		trace.Close()
+		telemetry.Shutdown()
+		logger.Close()
+		os.Exit(code)
+	}
+
 	fmt.Println("🛠️  CRAFT CLI")
-	fmt.Println("Model: Model K")
-	fmt.Println("Tools: read_file, write_file, list_dir, bash, grep")
-	fmt.Println("Type 'exit' to quit")
+	fmt.Printf("Model: %s\n", cfg.Models.Default)
+	var toolNames []string
+	for _, t := range client.toolInfo() {
+		toolNames = append(toolNames, t.Name)
+	}
+	fmt.Printf("Tools: %s\n", strings.Join(toolNames, ", "))
+	switch {
+	case !indexed:
+		fmt.Printf("Context: no index at %s, prompts are sent without retrieved snippets\n", cfg.Index.Path)
+	case injectGraph != nil:
+		fmt.Printf("Context: %d indexed chunks, injected into each prompt ('/raw <msg>' skips once, '/context off' disables)\n", graph.Len())
+	default:
+		fmt.Printf("Context: %d indexed chunks, available through search_codebase\n", graph.Len())
+	}
+	if trace.Enabled() {
+		fmt.Printf("Trace: recording to %s ('/trace off' stops)\n", trace.Path())
+	}
+	if memories, _ := ctxmgr.LoadMemories(); len(memories) > 0 {
+		fmt.Printf("Memory: %d saved fact(s), '/memory' to list, '/remember <fact>' to add\n", len(memories))
+	}
+	fmt.Println("Type 'exit' to quit, '/prompt' to show the system prompt")
 	fmt.Println()
 
 	scanner := bufio.NewScanner(os.Stdin)
 	var history []Message
+	autoContext := injectGraph != nil
 
-	// Add system prompt
+	// Add system prompt; it is rebuilt before the next message once the
+	// graph changes, so its repo map follows the index
 	history = append(history, Message{
 		Role:    "system",
-		Content: getSystemPrompt(),
+		Content: getSystemPrompt(client),
 	})
+	promptGen := graph.Generation()
+	refreshPrompt := func() {
+		if gen := graph.Generation(); gen != promptGen {
+			history[0].Content, promptGen = getSystemPrompt(client), gen
+		}
+	}
+
+	// Print progress the same way for every step of the agent loop
+	toolTurn := 0
+	emit := func(e Event) {
+		switch e.Type {
+		case "turn_start":
+			fmt.Print("Thinking... ")
+		case "usage", "assistant":
+			fmt.Print("\r") // Clear "Thinking..."
+		case "tool_call":
+			fmt.Print("\r")
+			if toolTurn != e.Turn {
+				toolTurn = e.Turn
+				fmt.Println("🔧 Using tool(s)...")
+			}
+			fmt.Printf("  → %s(%s)\n", e.Tool, e.Arguments)
+		case "tool_result":
+			// Truncate long results for display
+			display := e.Content
+			if len(display) > 200 {
+				display = display[:200] + "... (truncated)"
+			}
+			fmt.Printf("  ← %s\n", display)
+		}
+	}
 
 	for {
 		fmt.Print("> ")
@@ -353,49 +1200,85 @@ func main() {
 		if input == "exit" {
 			break
 		}
-
-		history = append(history, Message{Role: "user", Content: input})
-
-		// Agent loop: keep calling until no more tool calls
-		for {
-			fmt.Print("Thinking... ")
-			resp, err := client.Chat(context.Background(), history)
-			fmt.Print("\r") // Clear "Thinking..."
-
-			if err != nil {
-				fmt.Printf("❌ Error: %v\n", err)
-				break
+		if input == "/prompt" {
+			refreshPrompt()
+			fmt.Println(history[0].Content)
+			continue
+		}
+		if input == "/context" || strings.HasPrefix(input, "/context ") {
+			switch strings.TrimSpace(strings.TrimPrefix(input, "/context")) {
+			case "on":
+				autoContext = true
+			case "off":
+				autoContext = false
 			}
-
-			assistantMsg := resp.Choices[0].Message
-			history = append(history, assistantMsg)
-
-			// Check if there are tool calls
-			if len(assistantMsg.ToolCalls) == 0 {
-				// Final response
-				fmt.Println(assistantMsg.Content)
-				break
+			if autoContext {
+				fmt.Println("Context injection is on")
+			} else {
+				fmt.Println("Context injection is off")
 			}
-
-			// Execute tools and add results to history
-			fmt.Printf("🔧 Using %d tool(s)...\n", len(assistantMsg.ToolCalls))
-			for _, tc := range assistantMsg.ToolCalls {
-				fmt.Printf("  → %s(%s)\n", tc.Function.Name, tc.Function.Arguments)
-				result := client.executeTool(tc.Function.Name, tc.Function.Arguments)
-				
-				// Truncate long results for display
-				display := result
-				if len(display) > 200 {
-					display = display[:200] + "... (truncated)"
+			if !indexed {
+				fmt.Printf("No context index at %s, only memories are injected\n", cfg.Index.Path)
+			}
+			continue
+		}
+		if input == "/log" || strings.HasPrefix(input, "/log ") {
+			if name := strings.TrimSpace(strings.TrimPrefix(input, "/log")); name != "" {
+				if err := logger.SetLevel(name); err != nil {
+					fmt.Printf("❌ %v\n", err)
+					continue
 				}
-				fmt.Printf("  ← %s\n", display)
+			}
+			fmt.Printf("Log level %s, session %s (%s)\n", logger.Level(), logger.SessionID(), logger.Path())
+			continue
+		}
+		if input == "/trace" || strings.HasPrefix(input, "/trace ") {
+			switch strings.TrimSpace(strings.TrimPrefix(input, "/trace")) {
+			case "on":
+				if err := trace.Start(); err != nil {
+					fmt.Printf("❌ %v\n", err)
+					continue
+				}
+			case "off":
+				trace.Close()
+			}
+			if trace.Enabled() {
+				fmt.Printf("Tracing to %s ('craft trace show %s' to read it)\n", trace.Path(), strings.TrimSuffix(filepath.Base(trace.Path()), ".jsonl"))
+			} else {
+				fmt.Println("Tracing is off ('/trace on' to start)")
+			}
+			continue
+		}
+		if input == "/memory" || strings.HasPrefix(input, "/memory ") || strings.HasPrefix(input, "/remember") {
+			memoryCommand(graph, input)
+			continue
+		}
 
-				history = append(history, Message{
-					Role:       "tool",
-					Content:    result,
-					ToolCallID: tc.ID,
-				})
+		// "/raw <msg>" sends one message without retrieved context
+		useContext := autoContext
+		if raw, ok := strings.CutPrefix(input, "/raw "); ok {
+			input, useContext = strings.TrimSpace(raw), false
+		}
+		var inj *ctxmgr.Injection
+		if useContext {
+			if inj = retrieve(graph, input); inj != nil {
+				fmt.Printf("📎 Context (~%d tokens): %s\n", inj.Tokens, strings.Join(inj.Locations(), ", "))
 			}
 		}
+
+		refreshPrompt()
+		history = append(history, Message{Role: "user", Content: withContext(input, inj)})
+
+		// Agent loop: keep calling until no more tool calls
+		var answer string
+		toolTurn = 0
+		// The turn limit is for unattended runs; interactively the user can stop the agent
+		history, answer, _, err = runAgent(context.Background(), client, history, 0, emit)
+		fmt.Print("\r")
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			continue
+		}
+		fmt.Println(answer)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,322 @@
+// Package audit keeps a tamper-evident, append-only record of what the
+// agent did to the machine: every file it wrote (with the content hashes
+// before and after, and a diff), every shell command (with its working
+// directory, exit code and duration) and every approval decision.
+//
+// The log is a JSON-lines file separate from craft.log. Each entry holds the
+// hash of the entry before it and ends with its own hash, computed over the
+// previous hash and the entry, so changing, removing or reordering entries
+// breaks the chain at that point; Verify finds it. Appends from concurrent
+// craft processes are serialized with a file lock.
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"craft-cli/internal/config"
+	"craft-cli/internal/logger"
+)
+
+// Entry types
+const (
+	TypeFileWrite = "file_write"
+	TypeCommand   = "command"
+	TypeApproval  = "approval"
+	TypeRecovered = "recovered" // a torn last entry was cut off; Reason says how much
+)
+
+// Approval decisions
+const (
+	Approved = "approved"
+	Denied   = "denied"
+)
+
+// Entry is one line of the audit log
+type Entry struct {
+	Seq     int       `json:"seq"`
+	Time    time.Time `json:"time"`
+	Session string    `json:"session,omitempty"`
+	Type    string    `json:"type"`
+	Tool    string    `json:"tool,omitempty"`
+	Cwd     string    `json:"cwd,omitempty"`
+
+	// File writes
+	Path   string `json:"path,omitempty"`
+	Before string `json:"before,omitempty"` // sha256 of the old content; empty for a new file
+	After  string `json:"after,omitempty"`  // sha256 of the new content; empty when the file is gone
+	Diff   string `json:"diff,omitempty"`
+
+	// Commands
+	Command    string `json:"command,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"` // -1 when the command did not run to completion
+	DurationMS int64  `json:"duration_ms,omitempty"`
+
+	// Approvals
+	Decision string `json:"decision,omitempty"`
+	By       string `json:"by,omitempty"` // user, or policy for a config rule
+	Reason   string `json:"reason,omitempty"`
+
+	Error string `json:"error,omitempty"`
+	Prev  string `json:"prev"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// hashSuffixLen is the length of the tail of every line: `,"hash":"<64 hex digits>"}`
+const hashSuffixLen = len(`,"hash":""}`) + sha256.Size*2
+
+// ErrDamaged reports an audit log whose last complete entry cannot be
+// read, so new entries cannot be chained to it
+var ErrDamaged = errors.New("audit log is damaged")
+
+// DefaultPath is craft/audit.jsonl next to the default log file
+func DefaultPath() string {
+	return filepath.Join(filepath.Dir(logger.DefaultPath()), "audit.jsonl")
+}
+
+// Path returns the configured audit log
+func Path() string {
+	if p := config.Get().Audit.Path; p != "" {
+		return p
+	}
+	return DefaultPath()
+}
+
+// Append chains e to the audit log when auditing is enabled, filling in its
+// sequence number, time, session, working directory and hashes
+func Append(e Entry) error {
+	if !config.Get().Audit.Enabled {
+		return nil
+	}
+	return AppendTo(Path(), e)
+}
+
+// AppendTo chains e to the audit log at path
+func AppendTo(path string, e Entry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	if err := lock(f); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	defer unlock(f)
+
+	prev, err := lastEntry(f)
+	if err != nil {
+		return err
+	}
+	_, err = writeEntry(f, prev, e)
+	return err
+}
+
+// lastEntry reads the entry new ones chain to, or nil for an empty log. A
+// last line without its newline was left by a write that did not finish:
+// it is cut off and a recovered entry takes its place. f must be locked.
+func lastEntry(f *os.File) (*Entry, error) {
+	last, start, complete, err := lastLine(f)
+	if err != nil || len(last) == 0 {
+		return nil, err
+	}
+	var prev Entry
+	if _, err := splitHash(last); err == nil && json.Unmarshal(last, &prev) == nil {
+		if !complete {
+			if _, err := f.Write([]byte("\n")); err != nil {
+				return nil, fmt.Errorf("failed to write audit log: %w", err)
+			}
+		}
+		return &prev, nil
+	}
+	if complete {
+		return nil, fmt.Errorf("%w: its last entry is unreadable (run craft audit verify)", ErrDamaged)
+	}
+
+	if err := f.Truncate(start); err != nil {
+		return nil, fmt.Errorf("failed to truncate audit log: %w", err)
+	}
+	before, err := lastEntry(f)
+	if err != nil {
+		return nil, err
+	}
+	reason := fmt.Sprintf("dropped %d bytes of an entry left incomplete by an interrupted write", len(last))
+	logger.Warn("audit log recovered", "path", f.Name(), "bytes", len(last))
+	rec, err := writeEntry(f, before, Entry{Type: TypeRecovered, Reason: reason})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// writeEntry fills in e, chains it to prev and appends it to f
+func writeEntry(f *os.File, prev *Entry, e Entry) (Entry, error) {
+	e.Seq, e.Prev, e.Hash = 1, "", ""
+	if prev != nil {
+		e.Seq, e.Prev = prev.Seq+1, prev.Hash
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Session == "" {
+		e.Session = logger.SessionID()
+	}
+	if e.Cwd == "" {
+		e.Cwd, _ = os.Getwd()
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	e.Hash = chainHash(e.Prev, body)
+	line := append(body[:len(body)-1], `,"hash":"`+e.Hash+`"}`+"\n"...)
+	if _, err := f.Write(line); err != nil {
+		return e, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return e, f.Sync()
+}
+
+// chainHash is the hash of an entry: sha256 over the previous hash and the
+// entry's JSON without its own hash
+func chainHash(prev string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prev + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// splitHash returns the entry JSON of a line without its hash
+func splitHash(line []byte) ([]byte, error) {
+	n := len(line)
+	if n <= hashSuffixLen || !bytes.HasPrefix(line[n-hashSuffixLen:], []byte(`,"hash":"`)) || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, fmt.Errorf("entry does not end with its hash")
+	}
+	return append(line[:n-hashSuffixLen:n-hashSuffixLen], '}'), nil
+}
+
+// lastLine reads the last non-empty line of f backwards, so appending does
+// not read the whole log. It returns the offset of the line and whether
+// the file ends with a newline.
+func lastLine(f *os.File) (line []byte, start int64, complete bool, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	const chunk = 64 << 10
+	var buf []byte
+	for end := info.Size(); end > 0; {
+		n := min(chunk, end)
+		b := make([]byte, n)
+		if _, err := f.ReadAt(b, end-n); err != nil && err != io.EOF {
+			return nil, 0, false, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		buf = append(b, buf...)
+		end -= n
+		complete = buf[len(buf)-1] == '\n'
+		trimmed := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], end + int64(i) + 1, complete, nil
+		}
+	}
+	return bytes.TrimRight(buf, "\n"), 0, complete, nil
+}
+
+// record appends e, logging rather than failing the tool when the audit
+// log cannot be written
+func record(e Entry) {
+	if err := Append(e); err != nil {
+		logger.Error("audit entry not written", "type", e.Type, logger.Err(err))
+		fmt.Fprintf(os.Stderr, "Warning: audit entry not written: %v\n", err)
+	}
+}
+
+// Change is a file write in progress, from FileChange to Done
+type Change struct {
+	tool    string
+	path    string
+	before  []byte
+	existed bool
+}
+
+// FileChange snapshots path before tool writes it; call Done once the
+// write is over
+func FileChange(tool, path string) *Change {
+	c := &Change{tool: tool, path: path}
+	if !config.Get().Audit.Enabled {
+		return c
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		c.before, c.existed = data, true
+	}
+	return c
+}
+
+// Done records the change with the hashes and diff of the file before and
+// after, and err when the write failed
+func (c *Change) Done(err error) {
+	if !config.Get().Audit.Enabled {
+		return
+	}
+	e := Entry{Type: TypeFileWrite, Tool: c.tool, Path: c.path}
+	if c.existed {
+		e.Before = contentHash(c.before)
+	}
+	after, readErr := os.ReadFile(c.path)
+	if readErr == nil {
+		e.After = contentHash(after)
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if e.Before != e.After {
+		e.Diff = truncateDiff(unifiedDiff(c.path, c.before, after), config.Get().Audit.MaxDiff)
+	}
+	record(e)
+}
+
+// Command records a shell command run by tool; err is what running it
+// returned, from which the exit code is taken
+func Command(tool, command string, duration time.Duration, err error) {
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		code = -1
+	}
+	e := Entry{Type: TypeCommand, Tool: tool, Command: command, ExitCode: &code, DurationMS: duration.Milliseconds()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	record(e)
+}
+
+// Decision records an approval decision about what tool was going to do:
+// subject is the command or path, by is "user" or "policy"
+func Decision(tool, subject, decision, by, reason string) {
+	e := Entry{Type: TypeApproval, Tool: tool, Decision: decision, By: by, Reason: reason}
+	if tool == "bash" {
+		e.Command = subject
+	} else {
+		e.Path = subject
+	}
+	record(e)
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog appends n commands to a new log and returns its path and lines
+func writeLog(t *testing.T, n int) (string, [][]byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < n; i++ {
+		if err := AppendTo(path, Entry{Type: TypeCommand, Tool: "bash", Command: "echo " + strings.Repeat("x", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, bytes.SplitAfter(bytes.TrimRight(data, "\n"), []byte("\n"))
+}
+
+func rewrite(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	if err := os.WriteFile(path, bytes.Join(lines, nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyIntact(t *testing.T) {
+	path, _ := writeLog(t, 4)
+	r, err := Verify(path)
+	if err != nil || !r.OK() || r.Entries != 4 || r.Head == "" {
+		t.Fatalf("Verify = %+v, %v, want 4 intact entries", r, err)
+	}
+	entries, err := Load(path)
+	if err != nil || len(entries) != 4 || entries[3].Hash != r.Head {
+		t.Errorf("Load = %d entries, %v, want 4 ending with the head", len(entries), err)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		line   int
+		want   string
+	}{
+		{"modified", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"echo x"`), []byte(`"echo y"`), 1)
+			return lines
+		}, 2, "modified"},
+		{"removed", func(lines [][]byte) [][]byte {
+			return append(lines[:1:1], lines[2:]...)
+		}, 2, "removed"},
+		{"reordered", func(lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, 2, "reordered"},
+		{"first removed", func(lines [][]byte) [][]byte {
+			return lines[1:]
+		}, 1, "removed"},
+		{"hash cut", func(lines [][]byte) [][]byte {
+			lines[2] = append(bytes.TrimSuffix(lines[2], []byte("\"}\n")), []byte("}\n")...)
+			return lines
+		}, 3, "hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, lines := writeLog(t, 4)
+			rewrite(t, path, tt.tamper(lines))
+			r, err := Verify(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.OK() || r.Line != tt.line || !strings.Contains(r.Problem, tt.want) {
+				t.Errorf("Verify = %+v, want a problem mentioning %q at line %d", r, tt.want, tt.line)
+			}
+		})
+	}
+}
+
+func TestAppendRecoversTornEntry(t *testing.T) {
+	path, lines := writeLog(t, 3)
+	// The last write stopped halfway through its line
+	torn := lines[2][:len(lines[2])/2]
+	rewrite(t, path, append(lines[:2], torn))
+
+	if err := AppendTo(path, Entry{Type: TypeCommand, Tool: "bash", Command: "true"}); err != nil {
+		t.Fatalf("append after a torn entry: %v", err)
+	}
+	r, err := Verify(path)
+	if err != nil || !r.OK() || r.Entries != 4 {
+		t.Fatalf("Verify = %+v, %v, want 4 intact entries", r, err)
+	}
+	entries, _ := Load(path)
+	rec := entries[2]
+	if rec.Type != TypeRecovered || rec.Seq != 3 || !strings.Contains(rec.Reason, "dropped") {
+		t.Errorf("entry 3 = %+v, want a recovered entry", rec)
+	}
+	if entries[3].Command != "true" || entries[3].Prev != rec.Hash {
+		t.Errorf("entry 4 = %+v, want the new command chained to the recovered entry", entries[3])
+	}
+}
+
+func TestAppendTornOnlyEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	rewrite(t, path, [][]byte{[]byte(`{"seq":1,"time":"2026`)})
+	if err := AppendTo(path, Entry{Type: TypeCommand, Command: "true"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := Load(path)
+	if r, _ := Verify(path); !r.OK() || len(entries) != 2 || entries[0].Type != TypeRecovered || entries[0].Seq != 1 {
+		t.Errorf("Verify = %+v with entries %+v, want a recovered entry starting the chain", r, entries)
+	}
+}
+
+func TestAppendUnterminatedEntry(t *testing.T) {
+	path, lines := writeLog(t, 2)
+	// A complete entry that only lacks its newline is kept
+	lines[1] = bytes.TrimSuffix(lines[1], []byte("\n"))
+	rewrite(t, path, lines)
+	if err := AppendTo(path, Entry{Type: TypeCommand, Command: "true"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := Load(path)
+	if r, _ := Verify(path); !r.OK() || r.Entries != 3 || entries[2].Type != TypeCommand {
+		t.Errorf("Verify = %+v with entries %+v, want 3 intact entries", r, entries)
+	}
+}
+
+func TestAppendDamaged(t *testing.T) {
+	path, lines := writeLog(t, 2)
+	// A complete last line that does not parse was not torn by a crash
+	lines[1] = []byte("not an entry\n")
+	rewrite(t, path, lines)
+	if err := AppendTo(path, Entry{Type: TypeCommand, Command: "true"}); !errors.Is(err, ErrDamaged) {
+		t.Fatalf("append = %v, want ErrDamaged", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.HasSuffix(data, []byte("not an entry\n")) {
+		t.Error("damaged log was changed")
+	}
+}
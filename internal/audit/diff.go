@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is the number of unchanged lines around each hunk
+	diffContext = 3
+	// maxDiffCells bounds the line comparison table; larger changes are
+	// recorded as a whole replacement
+	maxDiffCells = 4 << 20
+)
+
+// unifiedDiff returns the changes from a to b in unified diff format
+func unifiedDiff(path string, a, b []byte) string {
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		return fmt.Sprintf("Binary file %s changed (%d -> %d bytes)\n", path, len(a), len(b))
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", path, path)
+	for start := 0; start < len(ops); {
+		// Find the next change and the end of its hunk
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		from := max(first-diffContext, start)
+		end := first
+		for unchanged := 0; end < len(ops) && unchanged <= 2*diffContext; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		// Keep diffContext unchanged lines after the last change
+		last := end - 1
+		for last > first && ops[last].kind == ' ' {
+			last--
+		}
+		to := min(last+1+diffContext, len(ops))
+
+		hunk := ops[from:to]
+		aStart, bStart := ops[from].aLine, ops[from].bLine
+		aCount, bCount := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range hunk {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			if !strings.HasSuffix(op.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// truncateDiff cuts a diff to limit bytes, saying how much was left out
+func truncateDiff(diff string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	if len(diff) <= limit {
+		return diff
+	}
+	cut := strings.LastIndexByte(diff[:limit], '\n') + 1
+	return diff[:cut] + fmt.Sprintf("... (diff truncated, %d more bytes)\n", len(diff)-cut)
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty range names the line before it
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits text after each newline, keeping the newlines
+func splitLines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed, '+' added.
+// aLine and bLine are the 1-based positions in each file where the line
+// is, or would be.
+type diffOp struct {
+	kind         byte
+	text         string
+	aLine, bLine int
+}
+
+// diffLines computes an edit script from a to b: common lines at both ends
+// are kept, and the middle is compared by longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	var ops []diffOp
+	ai, bi := 1, 1
+	emit := func(kind byte, text string) {
+		ops = append(ops, diffOp{kind, text, ai, bi})
+		if kind != '+' {
+			ai++
+		}
+		if kind != '-' {
+			bi++
+		}
+	}
+	for _, line := range a[:prefix] {
+		emit(' ', line)
+	}
+
+	n, m := len(midA), len(midB)
+	if n*m > maxDiffCells {
+		for _, line := range midA {
+			emit('-', line)
+		}
+		for _, line := range midB {
+			emit('+', line)
+		}
+	} else {
+		// lcs[i][j] is the common subsequence length of midA[i:] and midB[j:]
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && midA[i] == midB[j]:
+				emit(' ', midA[i])
+				i, j = i+1, j+1
+			case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+				emit('-', midA[i])
+				i++
+			default:
+				emit('+', midB[j])
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		emit(' ', line)
+	}
+	return ops
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Report is the result of Verify
+type Report struct {
+	Entries int
+	Head    string // hash of the last entry, to keep elsewhere and compare later
+	// Problem describes the first break in the chain, or is empty when the
+	// log is intact; Line is its 1-based line
+	Problem string
+	Line    int
+}
+
+// OK reports whether the chain is intact
+func (r Report) OK() bool {
+	return r.Problem == ""
+}
+
+// Verify recomputes the hash chain of the log at path and reports the
+// first entry that was changed, removed, reordered or inserted. Removing
+// entries from the end leaves a valid chain; compare Head with one kept
+// outside the log to detect it.
+func Verify(path string) (Report, error) {
+	var r Report
+	prevHash, prevSeq := "", 0
+	err := scanLines(path, func(n int, line []byte) bool {
+		fail := func(format string, args ...any) bool {
+			r.Problem, r.Line = fmt.Sprintf(format, args...), n
+			return false
+		}
+		body, err := splitHash(line)
+		if err != nil {
+			return fail("%v", err)
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fail("entry is not valid JSON: %v", err)
+		}
+		switch {
+		case e.Seq != prevSeq+1:
+			return fail("entry %d follows entry %d: entries were removed or reordered", e.Seq, prevSeq)
+		case e.Prev != prevHash:
+			return fail("entry %d does not chain to entry %d: an entry was removed, inserted or rewritten", e.Seq, prevSeq)
+		case chainHash(e.Prev, body) != e.Hash:
+			return fail("entry %d does not match its hash: it was modified", e.Seq)
+		}
+		r.Entries, r.Head = r.Entries+1, e.Hash
+		prevHash, prevSeq = e.Hash, e.Seq
+		return true
+	})
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	return r, err
+}
+
+// Load reads the entries of the log at path; lines that cannot be parsed
+// are skipped, so run Verify to trust them. A missing log has no entries.
+func Load(path string) ([]Entry, error) {
+	var entries []Entry
+	err := scanLines(path, func(_ int, line []byte) bool {
+		var e Entry
+		if json.Unmarshal(line, &e) == nil {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// scanLines calls fn with each non-empty line of path and its number until
+// fn returns false
+func scanLines(path string, fn func(n int, line []byte) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 && !fn(n, line) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,736 @@
+// Package config loads craft settings from layered sources. Later layers win:
+//
+//  1. built-in defaults
+//  2. user file     ~/.config/craft/config.toml ($XDG_CONFIG_HOME respected)
+//  3. project file  ./.craft/config.toml
+//  4. environment   CRAFT_<SECTION>_<KEY>, e.g. CRAFT_LIMITS_MAX_TURNS=10
+//  5. command-line flags, applied by the caller with Set
+//
+// Every setting is a field below; its toml and doc tags define the schema
+// printed by `craft config`.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is the effective craft configuration
+type Config struct {
+	Provider  ProviderConfig  `toml:"provider" doc:"LLM provider connection"`
+	Models    ModelsConfig    `toml:"models" doc:"Model selection"`
+	Limits    LimitsConfig    `toml:"limits" doc:"Agent loop and tool limits"`
+	Tools     ToolsConfig     `toml:"tools" doc:"Tool safety policies"`
+	Index     IndexConfig     `toml:"index" doc:"Context graph index"`
+	Embedding EmbeddingConfig `toml:"embedding" doc:"Embedding provider for semantic search"`
+	Retrieval RetrievalConfig `toml:"retrieval" doc:"Context from the index given to the model"`
+	UI        UIConfig        `toml:"ui" doc:"Terminal UI"`
+	Log       LogConfig       `toml:"log" doc:"Diagnostic log"`
+	Trace     TraceConfig     `toml:"trace" doc:"Request and response traces of agent turns"`
+	Telemetry TelemetryConfig `toml:"telemetry" doc:"OpenTelemetry spans of turns, model calls and tool executions"`
+	Audit     AuditConfig     `toml:"audit" doc:"Tamper-evident log of file changes, shell commands and approvals"`
+
+	sources map[string]string
+}
+
+type ProviderConfig struct {
+	Name           string        `toml:"name" doc:"groq, openai or ollama; picks the default base_url and api_key_env"`
+	BaseURL        string        `toml:"base_url" doc:"OpenAI-compatible API root (default from provider.name)"`
+	APIKeyEnv      string        `toml:"api_key_env" doc:"environment variable holding the API key (default from provider.name, empty = no key)"`
+	RequestTimeout time.Duration `toml:"request_timeout" doc:"HTTP timeout per model call"`
+}
+
+type ModelsConfig struct {
+	Default  string   `toml:"default" doc:"model used for every request"`
+	Fallback []string `toml:"fallback" doc:"models tried in order when the default is rate limited"`
+}
+
+type LimitsConfig struct {
+	MaxTurns    int           `toml:"max_turns" doc:"model calls per headless prompt before giving up (0 = no limit)"`
+	ToolTimeout time.Duration `toml:"tool_timeout" doc:"default timeout for a tool call"`
+	BashTimeout time.Duration `toml:"bash_timeout" doc:"timeout for the bash tool"`
+}
+
+type ToolsConfig struct {
+	BlockedCommands []string `toml:"blocked_commands" doc:"bash commands containing any of these are refused"`
+	AllowSudo       bool     `toml:"allow_sudo" doc:"allow sudo in bash commands"`
+	Disabled        []string `toml:"disabled" doc:"tools that are not offered to the model"`
+}
+
+type IndexConfig struct {
+	Path        string   `toml:"path" doc:"context index file, relative to the project root"`
+	Ignore      []string `toml:"ignore" doc:"path fragments skipped while indexing"`
+	MaxFileSize int      `toml:"max_file_size" doc:"files larger than this many bytes are not indexed"`
+	Workers     int      `toml:"workers" doc:"files indexed concurrently"`
+	Roots       []string `toml:"roots" doc:"directories indexed separately, relative to the project root (empty = the whole project)"`
+
+	ANNMinNodes       int `toml:"ann_min_nodes" doc:"use the approximate nearest-neighbour index from this many nodes (0 = never)"`
+	ANNM              int `toml:"ann_m" doc:"ANN links per node; higher improves recall, costs memory"`
+	ANNEfConstruction int `toml:"ann_ef_construction" doc:"ANN build candidate list; higher improves recall, slows indexing"`
+	ANNEfSearch       int `toml:"ann_ef_search" doc:"ANN query candidate list; higher improves recall, slows search"`
+}
+
+type EmbeddingConfig struct {
+	Provider   string `toml:"provider" doc:"gemini, openai, ollama, hash or none"`
+	Model      string `toml:"model" doc:"embedding model (empty = provider default)"`
+	BaseURL    string `toml:"base_url" doc:"API root for openai and ollama (empty = provider default)"`
+	APIKeyEnv  string `toml:"api_key_env" doc:"environment variable holding the API key (empty = provider default)"`
+	Dimensions int    `toml:"dimensions" doc:"vector size of the hash embedder"`
+	Cache      bool   `toml:"cache" doc:"cache embeddings on disk by content hash and model"`
+	CacheDir   string `toml:"cache_dir" doc:"embedding cache location (empty = user cache dir)"`
+
+	RequestsPerMinute int `toml:"requests_per_minute" doc:"embedding API requests allowed per minute (0 = no limit)"`
+}
+
+type RetrievalConfig struct {
+	Auto         bool `toml:"auto" doc:"search the index for every prompt and prepend the best snippets"`
+	Results      int  `toml:"results" doc:"search hits to consider per prompt"`
+	BudgetTokens int  `toml:"budget_tokens" doc:"maximum size of the injected context block"`
+	Memories     int  `toml:"memories" doc:"saved memories to consider per prompt (0 = none)"`
+
+	RepoMap       bool `toml:"repo_map" doc:"outline the project's files and exported symbols in the system prompt"`
+	RepoMapTokens int  `toml:"repo_map_tokens" doc:"maximum size of the repo map"`
+}
+
+type UIConfig struct {
+	Theme string `toml:"theme" doc:"sunset or moonlit"`
+}
+
+type LogConfig struct {
+	Level    string `toml:"level" doc:"debug, info, warn or error"`
+	Format   string `toml:"format" doc:"text or json (one object per line)"`
+	Path     string `toml:"path" doc:"log file (empty = craft/craft.log under $XDG_STATE_HOME or ~/.local/state)"`
+	MaxSize  int    `toml:"max_size" doc:"rotate the log when it reaches this many bytes (0 = never)"`
+	MaxFiles int    `toml:"max_files" doc:"rotated logs kept besides the current one"`
+}
+
+type TraceConfig struct {
+	Enabled bool   `toml:"enabled" doc:"record every model request and response and every tool call of a session"`
+	Dir     string `toml:"dir" doc:"trace directory (empty = craft/traces under $XDG_STATE_HOME or ~/.local/state)"`
+	Keep    int    `toml:"keep" doc:"newest session traces kept (0 = keep all)"`
+}
+
+type TelemetryConfig struct {
+	Enabled     bool     `toml:"enabled" doc:"export spans of turns, model calls and tool executions"`
+	Exporter    string   `toml:"exporter" doc:"otlp (OTLP/HTTP JSON to a collector) or file (one OTLP JSON request per line)"`
+	Endpoint    string   `toml:"endpoint" doc:"OTLP/HTTP collector root; spans are posted to <endpoint>/v1/traces"`
+	Headers     []string `toml:"headers" doc:"extra OTLP request headers as key=value, e.g. for an API token"`
+	File        string   `toml:"file" doc:"file exporter output (empty = craft/spans.jsonl under $XDG_STATE_HOME or ~/.local/state)"`
+	ServiceName string   `toml:"service_name" doc:"service.name resource attribute"`
+}
+
+type AuditConfig struct {
+	Enabled bool   `toml:"enabled" doc:"record every file write, shell command and approval decision of the agent"`
+	Path    string `toml:"path" doc:"audit log (empty = craft/audit.jsonl under $XDG_STATE_HOME or ~/.local/state)"`
+	MaxDiff int    `toml:"max_diff" doc:"bytes of a file change diff kept in the log (0 = hashes only)"`
+}
+
+// Source names used when reporting where a value came from
+const (
+	SourceDefault = "default"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// providers holds the base_url and api_key_env implied by each provider.name
+var providers = map[string]ProviderConfig{
+	"groq":   {BaseURL: "https://api.groq.com/openai/v1", APIKeyEnv: "GROQ_API_KEY"},
+	"openai": {BaseURL: "https://api.openai.com/v1", APIKeyEnv: "OPENAI_API_KEY"},
+	"ollama": {BaseURL: "http://localhost:11434/v1"},
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	c := &Config{
+		Provider: ProviderConfig{
+			Name:           "groq",
+			BaseURL:        "https://api.groq.com/openai/v1",
+			APIKeyEnv:      "GROQ_API_KEY",
+			RequestTimeout: 120 * time.Second,
+		},
+		Models: ModelsConfig{
+			Default:  "llama-3.1-8b-instant",
+			Fallback: []string{"llama-3.3-70b-versatile", "mixtral-8x7b-32768"},
+		},
+		Limits: LimitsConfig{
+			MaxTurns:    25,
+			ToolTimeout: 30 * time.Second,
+			BashTimeout: 60 * time.Second,
+		},
+		Tools: ToolsConfig{
+			BlockedCommands: []string{"rm -rf /", "mkfs", ":(){ :|:& };:", "dd if=/dev/zero"},
+		},
+		Index: IndexConfig{
+			Path:        ".craft-index.bin",
+			Ignore:      []string{"node_modules", "dist", ".git"},
+			MaxFileSize: 1 << 20,
+			Workers:     4,
+
+			ANNMinNodes:       20000,
+			ANNM:              16,
+			ANNEfConstruction: 100,
+			ANNEfSearch:       96,
+		},
+		Embedding: EmbeddingConfig{
+			Provider:   "gemini",
+			Dimensions: 256,
+			Cache:      true,
+		},
+		Retrieval: RetrievalConfig{
+			Auto:         true,
+			Results:      5,
+			BudgetTokens: 2000,
+			Memories:     3,
+
+			RepoMap:       true,
+			RepoMapTokens: 1000,
+		},
+		UI: UIConfig{Theme: "sunset"},
+		Log: LogConfig{
+			Level:    "info",
+			Format:   "text",
+			MaxSize:  10 << 20,
+			MaxFiles: 5,
+		},
+		Trace: TraceConfig{Keep: 20},
+		Telemetry: TelemetryConfig{
+			Exporter:    "otlp",
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "craft",
+		},
+		Audit: AuditConfig{
+			Enabled: true,
+			MaxDiff: 64 << 10,
+		},
+	}
+	c.sources = make(map[string]string)
+	for _, f := range c.fields() {
+		c.sources[f.key] = SourceDefault
+	}
+	return c
+}
+
+var (
+	mu      sync.RWMutex
+	current *Config
+)
+
+// Get returns the configuration loaded by Load, or the defaults
+func Get() *Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return Default()
+	}
+	return current
+}
+
+// Load builds the layered configuration (without flags), validates it and
+// makes it the one returned by Get.
+func Load() (*Config, error) {
+	c := Default()
+	for _, path := range []string{UserPath(), ProjectPath()} {
+		if path == "" {
+			continue
+		}
+		if err := c.mergeFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.mergeEnv(); err != nil {
+		return nil, err
+	}
+	c.applyProvider()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	current = c
+	mu.Unlock()
+	return c, nil
+}
+
+// UserPath returns the user-level config file location
+func UserPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "craft", "config.toml")
+}
+
+// ProjectPath returns the project-level config file location
+func ProjectPath() string {
+	return filepath.Join(ProjectRoot(), ".craft", "config.toml")
+}
+
+// ProjectRoot returns the directory craft treats as the project: the
+// nearest directory at or above the working directory that holds a .craft
+// or .git entry, or the working directory itself when there is none
+func ProjectRoot() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		for _, marker := range []string{".craft", ".git"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return cwd
+		}
+	}
+}
+
+// Set overrides one setting by its dotted key, e.g. Set("ui.theme", "moonlit", SourceFlag)
+func (c *Config) Set(key, value, source string) error {
+	f, ok := c.field(key)
+	if !ok {
+		return c.unknownKey(key)
+	}
+	if err := setFromString(f.v, value); err != nil {
+		return fmt.Errorf("%s %s: %w", source, key, err)
+	}
+	c.sources[key] = source
+	return nil
+}
+
+// Source reports which layer the value of key came from
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Keys returns every setting key in schema order
+func (c *Config) Keys() []string {
+	var keys []string
+	for _, f := range c.fields() {
+		keys = append(keys, f.key)
+	}
+	return keys
+}
+
+// Validate checks values for consistency and returns all problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s (from %s): %s", key, c.sources[key], fmt.Sprintf(format, args...)))
+	}
+
+	if _, ok := providers[c.Provider.Name]; !ok {
+		bad("provider.name", "unknown provider %q (want groq, openai or ollama)", c.Provider.Name)
+	}
+	if !strings.HasPrefix(c.Provider.BaseURL, "http://") && !strings.HasPrefix(c.Provider.BaseURL, "https://") {
+		bad("provider.base_url", "%q must start with http:// or https://", c.Provider.BaseURL)
+	}
+	if c.Provider.RequestTimeout <= 0 {
+		bad("provider.request_timeout", "must be positive, e.g. \"120s\"")
+	}
+	if strings.TrimSpace(c.Models.Default) == "" {
+		bad("models.default", "must not be empty")
+	}
+	if c.Limits.MaxTurns < 0 {
+		bad("limits.max_turns", "must be 0 (no limit) or positive, got %d", c.Limits.MaxTurns)
+	}
+	if c.Limits.ToolTimeout <= 0 {
+		bad("limits.tool_timeout", "must be positive, e.g. \"30s\"")
+	}
+	if c.Limits.BashTimeout <= 0 {
+		bad("limits.bash_timeout", "must be positive, e.g. \"60s\"")
+	}
+	if strings.TrimSpace(c.Index.Path) == "" {
+		bad("index.path", "must not be empty")
+	}
+	if c.Index.MaxFileSize <= 0 {
+		bad("index.max_file_size", "must be positive, got %d", c.Index.MaxFileSize)
+	}
+	for _, root := range c.Index.Roots {
+		if clean := filepath.Clean(root); filepath.IsAbs(root) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			bad("index.roots", "%q must be a directory inside the project", root)
+		}
+	}
+	if c.Index.Workers <= 0 {
+		bad("index.workers", "must be positive, got %d", c.Index.Workers)
+	}
+	if c.Index.ANNMinNodes < 0 {
+		bad("index.ann_min_nodes", "must be 0 (never) or positive, got %d", c.Index.ANNMinNodes)
+	}
+	if c.Index.ANNM < 2 {
+		bad("index.ann_m", "must be at least 2, got %d", c.Index.ANNM)
+	}
+	if c.Index.ANNEfConstruction < c.Index.ANNM {
+		bad("index.ann_ef_construction", "must be at least index.ann_m (%d), got %d", c.Index.ANNM, c.Index.ANNEfConstruction)
+	}
+	if c.Index.ANNEfSearch <= 0 {
+		bad("index.ann_ef_search", "must be positive, got %d", c.Index.ANNEfSearch)
+	}
+	switch c.Embedding.Provider {
+	case "gemini", "openai", "ollama", "hash", "none":
+	default:
+		bad("embedding.provider", "unknown provider %q (want gemini, openai, ollama, hash or none)", c.Embedding.Provider)
+	}
+	if u := c.Embedding.BaseURL; u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		bad("embedding.base_url", "%q must start with http:// or https://", u)
+	}
+	if c.Embedding.Dimensions <= 0 {
+		bad("embedding.dimensions", "must be positive, got %d", c.Embedding.Dimensions)
+	}
+	if c.Embedding.RequestsPerMinute < 0 {
+		bad("embedding.requests_per_minute", "must be 0 (no limit) or positive, got %d", c.Embedding.RequestsPerMinute)
+	}
+	if c.Retrieval.Results <= 0 {
+		bad("retrieval.results", "must be positive, got %d", c.Retrieval.Results)
+	}
+	if c.Retrieval.BudgetTokens <= 0 {
+		bad("retrieval.budget_tokens", "must be positive, got %d", c.Retrieval.BudgetTokens)
+	}
+	if c.Retrieval.Memories < 0 {
+		bad("retrieval.memories", "must not be negative, got %d", c.Retrieval.Memories)
+	}
+	if c.Retrieval.RepoMap && c.Retrieval.RepoMapTokens <= 0 {
+		bad("retrieval.repo_map_tokens", "must be positive, got %d", c.Retrieval.RepoMapTokens)
+	}
+	switch c.UI.Theme {
+	case "sunset", "moonlit":
+	default:
+		bad("ui.theme", "unknown theme %q (want sunset or moonlit)", c.UI.Theme)
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		bad("log.level", "unknown level %q (want debug, info, warn or error)", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		bad("log.format", "unknown format %q (want text or json)", c.Log.Format)
+	}
+	if c.Log.MaxSize < 0 {
+		bad("log.max_size", "must be 0 (never rotate) or positive, got %d", c.Log.MaxSize)
+	}
+	if c.Log.MaxFiles < 0 {
+		bad("log.max_files", "must not be negative, got %d", c.Log.MaxFiles)
+	}
+	if c.Trace.Keep < 0 {
+		bad("trace.keep", "must not be negative, got %d", c.Trace.Keep)
+	}
+	switch c.Telemetry.Exporter {
+	case "otlp", "file":
+	default:
+		bad("telemetry.exporter", "unknown exporter %q (want otlp or file)", c.Telemetry.Exporter)
+	}
+	if c.Telemetry.Exporter == "otlp" && !strings.HasPrefix(c.Telemetry.Endpoint, "http://") && !strings.HasPrefix(c.Telemetry.Endpoint, "https://") {
+		bad("telemetry.endpoint", "%q must start with http:// or https://", c.Telemetry.Endpoint)
+	}
+	for _, h := range c.Telemetry.Headers {
+		if k, _, ok := strings.Cut(h, "="); !ok || strings.TrimSpace(k) == "" {
+			bad("telemetry.headers", "%q is not key=value", h)
+		}
+	}
+	if c.Audit.MaxDiff < 0 {
+		bad("audit.max_diff", "must not be negative, got %d", c.Audit.MaxDiff)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %w", errors.Join(errs...))
+}
+
+// ToolDisabled reports whether a tool is turned off by tools.disabled
+func (c *Config) ToolDisabled(name string) bool {
+	for _, d := range c.Tools.Disabled {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyProvider fills in the base_url and api_key_env of provider.name
+// where no file or environment variable set them
+func (c *Config) applyProvider() {
+	p, ok := providers[c.Provider.Name]
+	if !ok {
+		return
+	}
+	if c.sources["provider.base_url"] == SourceDefault {
+		c.Provider.BaseURL = p.BaseURL
+	}
+	if c.sources["provider.api_key_env"] == SourceDefault {
+		c.Provider.APIKeyEnv = p.APIKeyEnv
+	}
+}
+
+// APIKey returns the provider API key from the configured environment variable
+func (c *Config) APIKey() string {
+	if c.Provider.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(c.Provider.APIKeyEnv)
+}
+
+// MissingAPIKey reports whether the provider needs an API key that is not set
+func (c *Config) MissingAPIKey() bool {
+	return c.Provider.APIKeyEnv != "" && c.APIKey() == ""
+}
+
+// sudoWord finds sudo used as a command word, not inside one like "pseudo"
+var sudoWord = regexp.MustCompile(`\bsudo\b`)
+
+// SudoRefused reports whether command runs sudo while tools.allow_sudo is
+// off. "sudo -u" to act as another user is allowed.
+func (c *Config) SudoRefused(command string) bool {
+	if c.Tools.AllowSudo {
+		return false
+	}
+	for _, m := range sudoWord.FindAllStringIndex(command, -1) {
+		if !strings.HasPrefix(command[m[1]:], " -u") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) mergeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	values, err := parseTOML(string(data))
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	var errs []error
+	for _, key := range sortedKeys(values) {
+		val := values[key]
+		f, ok := c.field(key)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, val.Line, c.unknownKey(key)))
+			continue
+		}
+		if err := setFromTOML(f.v, val.Value); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s: %w", path, val.Line, key, err))
+			continue
+		}
+		c.sources[key] = path
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// envAliases keeps older environment variables working and honors the
+// standard OpenTelemetry exporter variables
+var envAliases = map[string]string{
+	"GROQ_BASE_URL": "provider.base_url",
+	"CRAFT_MODEL":   "models.default",
+	"CRAFT_THEME":   "ui.theme",
+
+	"OTEL_EXPORTER_OTLP_ENDPOINT": "telemetry.endpoint",
+	"OTEL_EXPORTER_OTLP_HEADERS":  "telemetry.headers",
+	"OTEL_SERVICE_NAME":           "telemetry.service_name",
+}
+
+// EnvName returns the environment variable that overrides key
+func EnvName(key string) string {
+	return "CRAFT_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+func (c *Config) mergeEnv() error {
+	var errs []error
+	apply := func(env, key string) {
+		val, ok := os.LookupEnv(env)
+		if !ok || val == "" {
+			return
+		}
+		if err := c.Set(key, val, SourceEnv+" "+env); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, env := range sortedKeys(envAliases) {
+		apply(env, envAliases[env])
+	}
+	for _, key := range c.Keys() {
+		apply(EnvName(key), key)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+type field struct {
+	key string
+	doc string
+	v   reflect.Value
+}
+
+func (c *Config) fields() []field {
+	var out []field
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		sf := root.Type().Field(i)
+		section := sf.Tag.Get("toml")
+		if section == "" {
+			continue
+		}
+		sv := root.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			ff := sv.Type().Field(j)
+			out = append(out, field{
+				key: section + "." + ff.Tag.Get("toml"),
+				doc: ff.Tag.Get("doc"),
+				v:   sv.Field(j),
+			})
+		}
+	}
+	return out
+}
+
+func (c *Config) field(key string) (field, bool) {
+	for _, f := range c.fields() {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func (c *Config) unknownKey(key string) error {
+	best, bestDist := "", 4
+	for _, k := range c.Keys() {
+		if d := editDistance(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("unknown key %q (did you mean %s?)", key, best)
+	}
+	return fmt.Errorf("unknown key %q (run `craft config` to list valid keys)", key)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setFromTOML(v reflect.Value, val interface{}) error {
+	switch {
+	case v.Type() == durationType:
+		switch x := val.(type) {
+		case string:
+			d, err := time.ParseDuration(x)
+			if err != nil {
+				return fmt.Errorf("expected a duration like \"30s\", got %q", x)
+			}
+			v.SetInt(int64(d))
+		case int64:
+			v.SetInt(x * int64(time.Second))
+		default:
+			return fmt.Errorf("expected a duration like \"30s\", got %s", typeName(val))
+		}
+	case v.Kind() == reflect.String:
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %s", typeName(val))
+		}
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, ok := val.(int64)
+		if !ok {
+			return fmt.Errorf("expected an integer, got %s", typeName(val))
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Bool:
+		b, ok := val.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false, got %s", typeName(val))
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice:
+		items, ok := val.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array of strings, got %s", typeName(val))
+		}
+		list := make([]string, 0, len(items))
+		for _, it := range items {
+			s, ok := it.(string)
+			if !ok {
+				return fmt.Errorf("expected an array of strings, found %s element", typeName(it))
+			}
+			list = append(list, s)
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func setFromString(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("expected a duration like \"30s\", got %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", s)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice:
+		var list []string
+		for _, part := range strings.Split(s, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case int64:
+		return "an integer"
+	case float64:
+		return "a float"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	}
+	return fmt.Sprintf("%T", v)
+}
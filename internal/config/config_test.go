@@ -0,0 +1,281 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(`
+# a comment line
+top = "value" # trailing comment
+
+[limits]
+max_turns = 1_000
+ratio = 0.5
+enabled = true
+tag = "issue #42"         # the # in the string is kept
+path = 'C:\dir # raw'
+
+[tools]
+blocked_commands = [
+  "rm -rf /",  # dangerous
+  "a, b",
+  'single # quoted'
+]
+empty = []
+"quoted key".sub = "x"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]tomlValue{
+		"top":                    {"value", 3},
+		"limits.max_turns":       {int64(1000), 6},
+		"limits.ratio":           {0.5, 7},
+		"limits.enabled":         {true, 8},
+		"limits.tag":             {"issue #42", 9},
+		"limits.path":            {`C:\dir # raw`, 10},
+		"tools.blocked_commands": {[]interface{}{"rm -rf /", "a, b", "single # quoted"}, 13},
+		"tools.empty":            {[]interface{}(nil), 18},
+		"tools.quoted key.sub":   {"x", 19},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("parseTOML:\n got %#v\nwant %#v", values, want)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"[[servers]]", "line 1: arrays of tables are not supported"},
+		{"[limits", `line 1: unterminated table header "[limits"`},
+		{"\njust words", `line 2: expected key = value, got "just words"`},
+		{"a = 1\na = 2", "line 2: duplicate key a"},
+		{"a = bare", "line 1: a: cannot parse value bare (strings must be quoted)"},
+		{`a = "open`, `line 1: a: unterminated string "open`},
+		{"a = [\n  1,\n", "line 1: unterminated array for a"},
+		{"a =", "line 1: a: missing value"},
+		{". = 1", `line 1: invalid key "."`},
+	}
+	for _, tt := range tests {
+		_, err := parseTOML(tt.src)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("parseTOML(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+// inProject runs the test in a new project whose user and project config
+// files hold the given contents, with no craft variables set
+func inProject(t *testing.T, user, project string) (userPath, projectPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if _, alias := envAliases[name]; alias || strings.HasPrefix(name, "CRAFT_") {
+			t.Setenv(name, "")
+		}
+	}
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Chdir(filepath.Join(mkdir(t, dir, "project", ".craft"), ".."))
+	t.Cleanup(func() {
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+	})
+
+	userPath = filepath.Join(mkdir(t, dir, "config", "craft"), "config.toml")
+	projectPath = filepath.Join(dir, "project", ".craft", "config.toml")
+	for path, content := range map[string]string{userPath: user, projectPath: project} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return userPath, projectPath
+}
+
+func mkdir(t *testing.T, elem ...string) string {
+	t.Helper()
+	dir := filepath.Join(elem...)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	userPath, projectPath := inProject(t, `
+[limits]
+max_turns = 10
+tool_timeout = "10s"
+bash_timeout = 10
+[ui]
+theme = "moonlit"
+`, `
+[limits]
+max_turns = 20
+tool_timeout = "20s"
+`)
+	t.Setenv("CRAFT_LIMITS_MAX_TURNS", "30")
+	t.Setenv("CRAFT_MODEL", "env-model")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("limits.max_turns", "40", SourceFlag); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key    string
+		got    interface{}
+		want   interface{}
+		source string
+	}{
+		{"limits.max_turns", c.Limits.MaxTurns, 40, SourceFlag},
+		{"limits.tool_timeout", c.Limits.ToolTimeout, 20 * time.Second, projectPath},
+		{"limits.bash_timeout", c.Limits.BashTimeout, 10 * time.Second, userPath},
+		{"ui.theme", c.UI.Theme, "moonlit", userPath},
+		{"models.default", c.Models.Default, "env-model", "env CRAFT_MODEL"},
+		{"log.level", c.Log.Level, "info", SourceDefault},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want || c.Source(tt.key) != tt.source {
+			t.Errorf("%s = %v from %s, want %v from %s", tt.key, tt.got, c.Source(tt.key), tt.want, tt.source)
+		}
+	}
+	if Get() != c {
+		t.Error("Get does not return the loaded configuration")
+	}
+}
+
+func TestLoadProvider(t *testing.T) {
+	inProject(t, `
+[provider]
+name = "ollama"
+`, "")
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Provider.BaseURL != "http://localhost:11434/v1" || c.Provider.APIKeyEnv != "" || c.MissingAPIKey() {
+		t.Errorf("ollama provider = %+v, want its URL and no API key", c.Provider)
+	}
+
+	t.Setenv("CRAFT_PROVIDER_BASE_URL", "http://gpu-box:11434/v1")
+	if c, err = Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Provider.BaseURL != "http://gpu-box:11434/v1" {
+		t.Errorf("base_url = %q, want the environment to win over the provider default", c.Provider.BaseURL)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	_, projectPath := inProject(t, "", `
+[limits]
+max_turn = 5
+tool_timeout = "soon"
+[tools]
+disabled = ["bash", 3]
+`)
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Load succeeded with a broken project file")
+	}
+	for _, want := range []string{
+		projectPath + `:3: unknown key "limits.max_turn" (did you mean limits.max_turns?)`,
+		projectPath + `:4: limits.tool_timeout: expected a duration like "30s", got "soon"`,
+		projectPath + `:6: tools.disabled: expected an array of strings, found an integer element`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q\ndoes not contain %q", err, want)
+		}
+	}
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	inProject(t, "", "")
+	t.Setenv("CRAFT_INDEX_WORKERS", "many")
+	_, err := Load()
+	if err == nil || !strings.Contains(err.Error(), `env CRAFT_INDEX_WORKERS index.workers: expected an integer, got "many"`) {
+		t.Errorf("Load error = %v, want the bad variable named", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	c := Default()
+	if err := c.Set("tools.disabled", "bash, write_file,", SourceFlag); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bash", "write_file"}; !reflect.DeepEqual(c.Tools.Disabled, want) || !c.ToolDisabled("bash") {
+		t.Errorf("tools.disabled = %q, want %q", c.Tools.Disabled, want)
+	}
+	if err := c.Set("ui.them", "moonlit", SourceFlag); err == nil || err.Error() != `unknown key "ui.them" (did you mean ui.theme?)` {
+		t.Errorf("Set of a misspelled key = %v", err)
+	}
+	if err := c.Set("nothing.like.it", "1", SourceFlag); err == nil || !strings.Contains(err.Error(), "run `craft config`") {
+		t.Errorf("Set of an unknown key = %v", err)
+	}
+	if err := c.Set("retrieval.auto", "maybe", SourceFlag); err == nil || err.Error() != `flag retrieval.auto: expected true or false, got "maybe"` {
+		t.Errorf("Set of a bad boolean = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults do not validate: %v", err)
+	}
+
+	c := Default()
+	c.Set("provider.name", "acme", "test.toml")
+	c.Set("limits.max_turns", "-1", SourceFlag)
+	c.Set("index.roots", "api, ../elsewhere", SourceFlag)
+	c.Set("index.ann_ef_construction", "8", SourceFlag)
+	c.Set("telemetry.headers", "token", SourceFlag)
+	c.Log.Format = "xml"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted a broken configuration")
+	}
+	want := `invalid configuration:
+  provider.name (from test.toml): unknown provider "acme" (want groq, openai or ollama)
+limits.max_turns (from flag): must be 0 (no limit) or positive, got -1
+index.roots (from flag): "../elsewhere" must be a directory inside the project
+index.ann_ef_construction (from flag): must be at least index.ann_m (16), got 8
+log.format (from default): unknown format "xml" (want text or json)
+telemetry.headers (from flag): "token" is not key=value`
+	if err.Error() != want {
+		t.Errorf("Validate error:\n%s\nwant:\n%s", err, want)
+	}
+}
+
+func TestSudoRefused(t *testing.T) {
+	c := Default()
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"sudo rm -rf build", true},
+		{"make && sudo make install", true},
+		{"sudo -u postgres psql", false},
+		{"echo pseudo", false},
+	}
+	for _, tt := range tests {
+		if got := c.SudoRefused(tt.command); got != tt.want {
+			t.Errorf("SudoRefused(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+	c.Tools.AllowSudo = true
+	if c.SudoRefused("sudo rm -rf build") {
+		t.Error("sudo refused with tools.allow_sudo on")
+	}
+}
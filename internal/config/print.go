@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Write prints the effective configuration as TOML, annotating each value
+// with its description and the layer it came from.
+func (c *Config) Write(w io.Writer) error {
+	sectionDocs := make(map[string]string)
+	t := reflect.TypeOf(c).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		sectionDocs[t.Field(i).Tag.Get("toml")] = t.Field(i).Tag.Get("doc")
+	}
+
+	section := ""
+	for _, f := range c.fields() {
+		name, _, _ := strings.Cut(f.key, ".")
+		if name != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			section = name
+			fmt.Fprintf(w, "# %s\n[%s]\n", sectionDocs[name], name)
+		}
+		_, key, _ := strings.Cut(f.key, ".")
+		fmt.Fprintf(w, "# %s\n", f.doc)
+		if _, err := fmt.Fprintf(w, "%s = %s  # %s\n", key, formatValue(f.v), c.sources[f.key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return strconv.Quote(time.Duration(v.Int()).String())
+	case v.Kind() == reflect.String:
+		return strconv.Quote(v.String())
+	case v.Kind() == reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = strconv.Quote(v.Index(i).String())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
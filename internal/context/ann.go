@@ -0,0 +1,128 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// annPath is the ANN sidecar stored next to the index file
+func annPath(indexPath string) string {
+	return indexPath + ".ann"
+}
+
+// SetANN tunes the approximate nearest-neighbour index: it is used once at
+// least minNodes nodes are embedded (0 disables it). The index is rebuilt
+// with the new parameters on the next search.
+func (g *Graph) SetANN(params ANNParams, minNodes int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.annParams = params
+	g.annMinNodes = minNodes
+	g.ann = nil
+}
+
+// searchANN answers a vector query from the ANN index, building it first if
+// the graph has grown past the threshold. ok is false when the graph is too
+// small (or the index is disabled) and an exact scan should be used.
+func (g *Graph) searchANN(model string, query []float64, k int) ([]SearchResult, bool) {
+	g.mu.RLock()
+	ann := g.ann
+	g.mu.RUnlock()
+
+	if ann == nil || ann.model != model || ann.dims != len(query) {
+		if ann = g.buildANN(model, len(query)); ann == nil {
+			return nil, false
+		}
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var results []SearchResult
+	for _, r := range ann.search(query, k, g.annParams.EfSearch) {
+		if n, ok := g.Nodes[r.id]; ok {
+			results = append(results, SearchResult{Node: n, Similarity: r.score, Score: r.score})
+		}
+	}
+	return results, true
+}
+
+// buildANN indexes every node embedded by model, or returns nil when there
+// are fewer than annMinNodes of them
+func (g *Graph) buildANN(model string, dims int) *hnsw {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ann != nil && g.ann.model == model && g.ann.dims == dims {
+		return g.ann
+	}
+	if g.annMinNodes <= 0 || len(g.Nodes) < g.annMinNodes {
+		return nil
+	}
+	var count int
+	for _, n := range g.Nodes {
+		if n.Model == model && len(n.Embedding) == dims && n.Kind != KindMemory {
+			count++
+		}
+	}
+	if count < g.annMinNodes {
+		return nil
+	}
+
+	ann := newHNSW(g.annParams, model, dims)
+	for _, id := range sortedNodeIDs(g.Nodes) {
+		n := g.Nodes[id]
+		if n.Model == model && len(n.Embedding) == dims && n.Kind != KindMemory {
+			ann.insert(id, n.Embedding)
+		}
+	}
+	g.ann = ann
+	return ann
+}
+
+// saveANN writes the ANN sidecar for the index at indexPath, or removes a
+// leftover one when the graph has no ANN index
+func (g *Graph) saveANN(indexPath string) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.ann == nil {
+		os.Remove(annPath(indexPath))
+		return nil
+	}
+	if err := g.ann.save(annPath(indexPath)); err != nil {
+		return fmt.Errorf("failed to write ANN index: %w", err)
+	}
+	return nil
+}
+
+// loadANN reads the sidecar for the index at indexPath. A missing, damaged
+// or outdated sidecar is not an error: the index is rebuilt on demand.
+func (g *Graph) loadANN(indexPath string, nodes map[string]*Node) *hnsw {
+	g.mu.RLock()
+	params := g.annParams
+	g.mu.RUnlock()
+
+	ann, err := loadHNSW(annPath(indexPath), params, nodes)
+	if err != nil {
+		return nil
+	}
+	// Every embedded node of the model must be in the index
+	var count int
+	for _, n := range nodes {
+		if n.Model == ann.model && len(n.Embedding) == ann.dims {
+			count++
+		}
+	}
+	if count != ann.Len() {
+		return nil
+	}
+	return ann
+}
+
+func sortedNodeIDs(nodes map[string]*Node) []string {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
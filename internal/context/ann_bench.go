@@ -0,0 +1,132 @@
+package context
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ANNBenchmark compares the ANN index with exact search on a synthetic corpus
+type ANNBenchmark struct {
+	Points, Dims, Queries, K int
+	Params                   ANNParams
+
+	Build  time.Duration // time to insert every point
+	Exact  time.Duration // total time for all queries, exact scan
+	Approx time.Duration // total time for all queries, ANN index
+	Recall float64       // mean fraction of the exact top K found by the ANN index
+}
+
+func (b ANNBenchmark) String() string {
+	perQuery := func(d time.Duration) time.Duration {
+		return d / time.Duration(max(b.Queries, 1))
+	}
+	speedup := 0.0
+	if b.Approx > 0 {
+		speedup = float64(b.Exact) / float64(b.Approx)
+	}
+	return fmt.Sprintf(
+		"%d points x %d dims, %d queries, k=%d (M=%d efConstruction=%d efSearch=%d)\n"+
+			"  build:  %v\n"+
+			"  exact:  %v/query\n"+
+			"  ann:    %v/query (%.1fx faster)\n"+
+			"  recall: %.3f",
+		b.Points, b.Dims, b.Queries, b.K, b.Params.M, b.Params.EfConstruction, b.Params.EfSearch,
+		b.Build.Round(time.Millisecond), perQuery(b.Exact), perQuery(b.Approx), speedup, b.Recall)
+}
+
+// BenchmarkANN builds an ANN index over points random vectors grouped in
+// clusters (as chunk embeddings are), then measures query latency and
+// recall@k against an exact scan. The corpus is seeded, so runs with the
+// same arguments are comparable.
+func BenchmarkANN(points, dims, queries, k int, params ANNParams) ANNBenchmark {
+	corpus, queryVecs := annCorpus(points, dims, queries)
+	b := ANNBenchmark{Points: points, Dims: dims, Queries: queries, K: k, Params: params}
+
+	start := time.Now()
+	ann := newHNSW(params, "bench", dims)
+	for i, v := range corpus {
+		ann.insert(fmt.Sprint(i), v)
+	}
+	b.Build = time.Since(start)
+
+	exact := make([]map[string]bool, queries)
+	start = time.Now()
+	for qi, q := range queryVecs {
+		exact[qi] = exactTopK(corpus, q, k)
+	}
+	b.Exact = time.Since(start)
+
+	var found int
+	start = time.Now()
+	results := make([][]scoredID, queries)
+	for qi, q := range queryVecs {
+		results[qi] = ann.search(q, k, params.EfSearch)
+	}
+	b.Approx = time.Since(start)
+	for qi, res := range results {
+		for _, r := range res {
+			if exact[qi][r.id] {
+				found++
+			}
+		}
+	}
+	if queries > 0 && k > 0 {
+		b.Recall = float64(found) / float64(queries*min(k, points))
+	}
+	return b
+}
+
+// annCorpus returns points vectors grouped in clusters, and queries drawn
+// from the same clusters. The generator is seeded, so the corpus depends
+// only on the arguments.
+func annCorpus(points, dims, queries int) (corpus, queryVecs [][]float64) {
+	rng := rand.New(rand.NewSource(42))
+	clusters := max(points/200, 1)
+	centers := make([][]float64, clusters)
+	for i := range centers {
+		centers[i] = randomVector(rng, dims, nil, 1)
+	}
+	sample := func() []float64 {
+		return randomVector(rng, dims, centers[rng.Intn(clusters)], 0.5)
+	}
+
+	corpus = make([][]float64, points)
+	for i := range corpus {
+		corpus[i] = sample()
+	}
+	queryVecs = make([][]float64, queries)
+	for i := range queryVecs {
+		queryVecs[i] = sample()
+	}
+	return corpus, queryVecs
+}
+
+func randomVector(rng *rand.Rand, dims int, center []float64, spread float64) []float64 {
+	v := make([]float64, dims)
+	for i := range v {
+		v[i] = rng.NormFloat64() * spread
+		if center != nil {
+			v[i] += center[i]
+		}
+	}
+	return v
+}
+
+func exactTopK(corpus [][]float64, q []float64, k int) map[string]bool {
+	type hit struct {
+		i   int
+		sim float64
+	}
+	hits := make([]hit, len(corpus))
+	for i, v := range corpus {
+		hits[i] = hit{i, cosineSimilarity(q, v)}
+	}
+	sort.Slice(hits, func(a, b int) bool { return hits[a].sim > hits[b].sim })
+	top := make(map[string]bool, k)
+	for _, h := range hits[:min(k, len(hits))] {
+		top[fmt.Sprint(h.i)] = true
+	}
+	return top
+}
@@ -0,0 +1,350 @@
+package context
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Files shorter than this stay a single chunk
+	minChunkLines = 40
+	// Chunks longer than this are split into windows
+	maxChunkLines = 150
+)
+
+// Chunk kinds
+const (
+	KindFile    = "file"
+	KindFunc    = "func"
+	KindMethod  = "method"
+	KindType    = "type"
+	KindValue   = "value"
+	KindHeader  = "header"
+	KindSection = "section"
+	KindBlock   = "block"
+	// KindMemory nodes hold a remembered fact instead of file content
+	KindMemory = "memory"
+)
+
+// Chunk is a contiguous, citable piece of a file
+type Chunk struct {
+	StartLine int // 1-based, inclusive
+	EndLine   int
+	Symbol    string
+	Kind      string
+	Content   string
+}
+
+// ChunkFile splits a file into declaration-, section- or paragraph-level chunks
+func ChunkFile(path, content string) []Chunk {
+	lines := strings.Split(content, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if len(lines) < minChunkLines {
+		return []Chunk{{StartLine: 1, EndLine: len(lines), Kind: KindFile, Content: content}}
+	}
+
+	var spans []span
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".go":
+		spans = goSpans(path, content, len(lines))
+	case ".md", ".markdown", ".mdx", ".rst":
+		spans = headingSpans(lines)
+	case ".txt", "":
+		spans = paragraphSpans(lines)
+	default:
+		if patterns, ok := declPatterns[ext]; ok {
+			spans = declSpans(lines, patterns)
+		}
+	}
+	if len(spans) == 0 {
+		spans = paragraphSpans(lines)
+	}
+	return buildChunks(lines, spans)
+}
+
+// span is a chunk before its content is cut out
+type span struct {
+	start, end int // 1-based, inclusive
+	symbol     string
+	kind       string
+}
+
+func buildChunks(lines []string, spans []span) []Chunk {
+	var out []Chunk
+	for _, sp := range spans {
+		if sp.start < 1 {
+			sp.start = 1
+		}
+		if sp.end > len(lines) {
+			sp.end = len(lines)
+		}
+		if sp.end < sp.start {
+			continue
+		}
+		// Split oversized spans into windows that keep the symbol name
+		part := 0
+		for start := sp.start; start <= sp.end; start += maxChunkLines {
+			end := min(start+maxChunkLines-1, sp.end)
+			symbol := sp.symbol
+			if sp.end-sp.start+1 > maxChunkLines {
+				part++
+				if symbol != "" {
+					symbol = fmt.Sprintf("%s (part %d)", symbol, part)
+				}
+			}
+			text := strings.Join(lines[start-1:end], "\n")
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			out = append(out, Chunk{StartLine: start, EndLine: end, Symbol: symbol, Kind: sp.kind, Content: text})
+		}
+	}
+	return out
+}
+
+// goSpans splits Go source by top-level declaration using go/ast. Doc
+// comments belong to their declaration, and the package clause and imports
+// form a header chunk.
+func goSpans(path, content string, total int) []span {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+
+	var spans []span
+	headerEnd := fset.Position(file.Name.End()).Line
+	for _, decl := range file.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			headerEnd = fset.Position(gd.End()).Line
+		}
+	}
+	spans = append(spans, span{start: 1, end: headerEnd, symbol: file.Name.Name, kind: KindHeader})
+
+	prevEnd := headerEnd
+	for _, decl := range file.Decls {
+		var sp span
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			sp.symbol, sp.kind = d.Name.Name, KindFunc
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				sp.symbol, sp.kind = receiverName(d.Recv.List[0].Type)+"."+d.Name.Name, KindMethod
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			sp.symbol, sp.kind = genDeclName(d)
+		default:
+			continue
+		}
+		sp.start = fset.Position(decl.Pos()).Line
+		if doc := declDoc(decl); doc != nil {
+			sp.start = fset.Position(doc.Pos()).Line
+		}
+		// Loose comments between declarations stay with the next one
+		if sp.start > prevEnd+1 {
+			sp.start = prevEnd + 1
+		}
+		sp.end = fset.Position(decl.End()).Line
+		prevEnd = sp.end
+		spans = append(spans, sp)
+	}
+	if prevEnd < total {
+		spans = append(spans, span{start: prevEnd + 1, end: total, kind: KindBlock})
+	}
+	return spans
+}
+
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return d.Doc
+	case *ast.GenDecl:
+		return d.Doc
+	}
+	return nil
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return "?"
+}
+
+func genDeclName(d *ast.GenDecl) (string, string) {
+	var names []string
+	kind := KindValue
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			kind = KindType
+			names = append(names, s.Name.Name)
+		case *ast.ValueSpec:
+			for _, n := range s.Names {
+				names = append(names, n.Name)
+			}
+		}
+	}
+	if len(names) > 3 {
+		names = append(names[:3], "...")
+	}
+	return strings.Join(names, ", "), kind
+}
+
+// declPattern marks the first line of a top-level declaration; the first
+// capture group is the symbol name
+type declPattern struct {
+	re   *regexp.Regexp
+	kind string
+}
+
+var (
+	jsPatterns = []declPattern{
+		{regexp.MustCompile(`^(?:export\s+)?(?:default\s+)?(?:async\s+)?function\*?\s+([A-Za-z_$][\w$]*)`), KindFunc},
+		{regexp.MustCompile(`^(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+([A-Za-z_$][\w$]*)`), KindType},
+		{regexp.MustCompile(`^(?:export\s+)?(?:interface|type|enum)\s+([A-Za-z_$][\w$]*)`), KindType},
+		{regexp.MustCompile(`^(?:export\s+)?(?:const|let|var)\s+([A-Za-z_$][\w$]*)`), KindValue},
+	}
+	pyPatterns = []declPattern{
+		{regexp.MustCompile(`^(?:async\s+)?def\s+([A-Za-z_]\w*)`), KindFunc},
+		{regexp.MustCompile(`^class\s+([A-Za-z_]\w*)`), KindType},
+	}
+	rustPatterns = []declPattern{
+		{regexp.MustCompile(`^(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?fn\s+([A-Za-z_]\w*)`), KindFunc},
+		{regexp.MustCompile(`^(?:pub(?:\([^)]*\))?\s+)?(?:struct|enum|trait|type|mod)\s+([A-Za-z_]\w*)`), KindType},
+		{regexp.MustCompile(`^impl(?:<[^>]*>)?\s+(?:[\w:<>]+\s+for\s+)?([A-Za-z_]\w*)`), KindType},
+	}
+	cLikePatterns = []declPattern{
+		{regexp.MustCompile(`^(?:public\s+|private\s+|protected\s+|static\s+|final\s+|abstract\s+)*(?:class|interface|enum|struct|record)\s+([A-Za-z_]\w*)`), KindType},
+		{regexp.MustCompile(`^[A-Za-z_][\w<>\[\]*&:, ]*\s+\**([A-Za-z_]\w*)\s*\([^;]*$`), KindFunc},
+	}
+	rubyPatterns = []declPattern{
+		{regexp.MustCompile(`^def\s+([A-Za-z_][\w.?!]*)`), KindFunc},
+		{regexp.MustCompile(`^(?:class|module)\s+([A-Z]\w*)`), KindType},
+	}
+
+	declPatterns = map[string][]declPattern{
+		".js": jsPatterns, ".jsx": jsPatterns, ".mjs": jsPatterns, ".cjs": jsPatterns,
+		".ts": jsPatterns, ".tsx": jsPatterns,
+		".py":   pyPatterns,
+		".rs":   rustPatterns,
+		".java": cLikePatterns, ".c": cLikePatterns, ".h": cLikePatterns, ".cc": cLikePatterns,
+		".cpp": cLikePatterns, ".hpp": cLikePatterns, ".cs": cLikePatterns, ".kt": cLikePatterns,
+		".rb": rubyPatterns,
+	}
+)
+
+// declSpans starts a new chunk at every unindented line matching a
+// declaration pattern, pulling preceding comment lines along with it.
+func declSpans(lines []string, patterns []declPattern) []span {
+	var spans []span
+	cur := span{start: 1, kind: KindHeader}
+	for i, line := range lines {
+		lineNo := i + 1
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		for _, p := range patterns {
+			m := p.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			start := lineNo
+			for start > cur.start+1 && isCommentLine(lines[start-2]) {
+				start--
+			}
+			if start > cur.start {
+				cur.end = start - 1
+				spans = append(spans, cur)
+				cur = span{start: start}
+			}
+			cur.symbol, cur.kind = m[1], p.kind
+			break
+		}
+	}
+	cur.end = len(lines)
+	spans = append(spans, cur)
+	if len(spans) == 1 {
+		return nil
+	}
+	return spans
+}
+
+func isCommentLine(line string) bool {
+	t := strings.TrimSpace(line)
+	for _, prefix := range []string{"//", "#", "/*", "*", "--", "@"} {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// headingSpans splits markdown at headings, naming each chunk after its heading
+func headingSpans(lines []string) []span {
+	var spans []span
+	cur := span{start: 1, kind: KindSection}
+	inFence := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if inFence || !strings.HasPrefix(line, "#") {
+			continue
+		}
+		title := strings.TrimSpace(strings.TrimLeft(line, "#"))
+		if title == "" {
+			continue
+		}
+		if i+1 > cur.start {
+			cur.end = i
+			spans = append(spans, cur)
+		}
+		cur = span{start: i + 1, symbol: title, kind: KindSection}
+	}
+	cur.end = len(lines)
+	spans = append(spans, cur)
+	if len(spans) == 1 {
+		return nil
+	}
+	return spans
+}
+
+// paragraphSpans groups blank-line separated paragraphs into chunks of
+// roughly minChunkLines lines
+func paragraphSpans(lines []string) []span {
+	var spans []span
+	cur := span{start: 1, kind: KindBlock}
+	for i, line := range lines {
+		lineNo := i + 1
+		if strings.TrimSpace(line) == "" && lineNo-cur.start+1 >= minChunkLines {
+			cur.end = lineNo
+			spans = append(spans, cur)
+			cur = span{start: lineNo + 1, kind: KindBlock}
+		}
+	}
+	if cur.start <= len(lines) {
+		cur.end = len(lines)
+		spans = append(spans, cur)
+	}
+	return spans
+}
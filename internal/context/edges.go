@@ -0,0 +1,207 @@
+package context
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Edge kinds
+const (
+	EdgeImports    = "imports"
+	EdgeCalls      = "calls"
+	EdgeReferences = "references"
+)
+
+// Ref is an outgoing dependency of a node, recorded when the file is
+// indexed. Targets are symbolic so they survive edits elsewhere:
+//
+//	go:<dir>:<symbol>    a Go declaration in the package in dir
+//	pkg:<dir>            a Go package (its files' header chunks)
+//	file:<path>          a file (its first chunk)
+//	def:<path>:<symbol>  a declaration in a file
+type Ref struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+}
+
+// Edge is a resolved dependency between two nodes
+type Edge struct {
+	From string
+	To   string
+	Kind string
+}
+
+// maxExpansionPerHit caps the neighbours Search adds after each hit
+const maxExpansionPerHit = 2
+
+func appendRef(refs []Ref, r Ref) []Ref {
+	for _, existing := range refs {
+		if existing == r {
+			return refs
+		}
+	}
+	return append(refs, r)
+}
+
+// chunkRefs extracts the dependencies of each chunk of a file
+func (g *Graph) chunkRefs(path, content string, chunks []Chunk) [][]Ref {
+	if strings.EqualFold(filepath.Ext(path), ".go") {
+		return g.goRefs(path, content, chunks)
+	}
+	return scriptRefs(path, content, chunks)
+}
+
+// linkIndex resolves every node's Refs into edges. It is rebuilt lazily
+// after the graph changes.
+type linkIndex struct {
+	out     map[string][]Edge
+	in      map[string][]Edge
+	symbols symbolTable
+}
+
+// linksLocked returns the current link index; g.mu must be held for writing
+func (g *Graph) linksLocked() *linkIndex {
+	if g.links != nil {
+		return g.links
+	}
+
+	// Symbolic keys to the nodes they name, first chunk first
+	defs := make(map[string][]*Node)
+	for _, n := range g.Nodes {
+		dir := filepath.Dir(n.Path)
+		defs["file:"+n.Path] = append(defs["file:"+n.Path], n)
+		if n.Kind == KindHeader && strings.EqualFold(filepath.Ext(n.Path), ".go") {
+			defs["pkg:"+dir] = append(defs["pkg:"+dir], n)
+		}
+		symbol, _, _ := strings.Cut(n.Symbol, " (part ")
+		if symbol == "" || n.Kind == KindHeader || n.Kind == KindSection {
+			continue
+		}
+		for _, name := range strings.Split(symbol, ", ") {
+			defs["def:"+n.Path+":"+name] = append(defs["def:"+n.Path+":"+name], n)
+			if strings.EqualFold(filepath.Ext(n.Path), ".go") {
+				defs["go:"+dir+":"+name] = append(defs["go:"+dir+":"+name], n)
+			}
+		}
+	}
+	for key, nodes := range defs {
+		sort.Slice(nodes, func(i, j int) bool {
+			if nodes[i].Path != nodes[j].Path {
+				return nodes[i].Path < nodes[j].Path
+			}
+			return nodes[i].StartLine < nodes[j].StartLine
+		})
+		// A file or split declaration is reached through its first chunk;
+		// a package through the header of each of its files
+		if !strings.HasPrefix(key, "pkg:") {
+			defs[key] = nodes[:1]
+		}
+	}
+
+	links := &linkIndex{out: make(map[string][]Edge), in: make(map[string][]Edge), symbols: buildSymbolTable(g.Nodes)}
+	for _, id := range sortedNodeIDs(g.Nodes) {
+		n := g.Nodes[id]
+		seen := make(map[Edge]bool)
+		for _, ref := range n.Refs {
+			for _, target := range defs[ref.Target] {
+				e := Edge{From: n.ID, To: target.ID, Kind: ref.Kind}
+				if target.ID == n.ID || seen[e] {
+					continue
+				}
+				seen[e] = true
+				links.out[e.From] = append(links.out[e.From], e)
+				links.in[e.To] = append(links.in[e.To], e)
+			}
+		}
+	}
+	g.links = links
+	return links
+}
+
+// Edges returns the dependencies of a node (out) and the nodes that depend
+// on it (in)
+func (g *Graph) Edges(id string) (out, in []Edge) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	links := g.linksLocked()
+	return append([]Edge(nil), links.out[id]...), append([]Edge(nil), links.in[id]...)
+}
+
+// Neighbors returns the nodes one edge away from id in either direction,
+// optionally limited to some edge kinds. Dependencies come before
+// dependents.
+func (g *Graph) Neighbors(id string, kinds ...string) []*Node {
+	out, in := g.Edges(id)
+	wanted := func(kind string) bool {
+		if len(kinds) == 0 {
+			return true
+		}
+		for _, k := range kinds {
+			if k == kind {
+				return true
+			}
+		}
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	seen := map[string]bool{id: true}
+	var nodes []*Node
+	add := func(otherID, kind string) {
+		if seen[otherID] || !wanted(kind) {
+			return
+		}
+		if n, ok := g.Nodes[otherID]; ok {
+			seen[otherID] = true
+			nodes = append(nodes, n)
+		}
+	}
+	for _, e := range out {
+		add(e.To, e.Kind)
+	}
+	for _, e := range in {
+		add(e.From, e.Kind)
+	}
+	return nodes
+}
+
+// EdgeCount returns the number of resolved edges in the graph
+func (g *Graph) EdgeCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var count int
+	for _, edges := range g.linksLocked().out {
+		count += len(edges)
+	}
+	return count
+}
+
+// expand follows each hit by up to maxExpansionPerHit of its neighbours
+// that are not results already, so a function comes back with the
+// definitions it calls or the code that calls it. Expanded results carry
+// the ID of the hit in Via and half its score.
+func (g *Graph) expand(results []SearchResult) []SearchResult {
+	seen := make(map[string]bool, len(results))
+	for _, r := range results {
+		seen[r.Node.ID] = true
+	}
+	expanded := make([]SearchResult, 0, len(results)*(1+maxExpansionPerHit))
+	for _, r := range results {
+		expanded = append(expanded, r)
+		added := 0
+		for _, n := range g.Neighbors(r.Node.ID, EdgeCalls, EdgeReferences, EdgeImports) {
+			if added == maxExpansionPerHit {
+				break
+			}
+			if seen[n.ID] {
+				continue
+			}
+			seen[n.ID] = true
+			expanded = append(expanded, SearchResult{Node: n, Score: r.Score / 2, Via: r.Node.ID})
+			added++
+		}
+	}
+	return expanded
+}
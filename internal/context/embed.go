@@ -0,0 +1,341 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"craft-cli/internal/config"
+)
+
+const (
+	// maxEmbedChars keeps requests under the embedding models' input limits
+	maxEmbedChars = 8000
+	// maxEmbedBatch is the most texts sent in one embedding request
+	maxEmbedBatch = 64
+)
+
+// Embedder turns text into vectors. Vectors are only comparable when they
+// come from the same Model, so the name is stored with every node and used
+// as part of the cache key.
+type Embedder interface {
+	Model() string
+	Embed(texts []string) ([][]float64, error)
+}
+
+var embedClient = &http.Client{Timeout: 60 * time.Second}
+
+// NewEmbedder builds the embedder described by the [embedding] config
+// section, wrapped in the on-disk cache when enabled. It returns nil when
+// embeddings are turned off or the provider's API key is missing; the graph
+// then relies on lexical search alone.
+func NewEmbedder(cfg config.EmbeddingConfig) (Embedder, error) {
+	apiKey := func(defaultEnv string) string {
+		if cfg.APIKeyEnv != "" {
+			return os.Getenv(cfg.APIKeyEnv)
+		}
+		return os.Getenv(defaultEnv)
+	}
+	model := func(defaultModel string) string {
+		if cfg.Model != "" {
+			return cfg.Model
+		}
+		return defaultModel
+	}
+	baseURL := func(defaultURL string) string {
+		if cfg.BaseURL != "" {
+			return strings.TrimRight(cfg.BaseURL, "/")
+		}
+		return defaultURL
+	}
+
+	var e Embedder
+	switch cfg.Provider {
+	case "none":
+		return nil, nil
+	case "gemini":
+		key := apiKey("GEMINI_API_KEY")
+		if key == "" {
+			return nil, nil
+		}
+		e = &GeminiEmbedder{APIKey: key, EmbedModel: model("text-embedding-004")}
+	case "openai":
+		// Local OpenAI-compatible servers often need no key, so it is optional
+		e = &OpenAIEmbedder{
+			BaseURL:    baseURL("https://api.openai.com/v1"),
+			APIKey:     apiKey("OPENAI_API_KEY"),
+			EmbedModel: model("text-embedding-3-small"),
+		}
+	case "ollama":
+		e = &OllamaEmbedder{BaseURL: baseURL("http://localhost:11434"), EmbedModel: model("nomic-embed-text")}
+	case "hash":
+		e = &HashEmbedder{Dimensions: cfg.Dimensions}
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", cfg.Provider)
+	}
+
+	if cfg.RequestsPerMinute > 0 {
+		e = &RateLimitedEmbedder{Embedder: e, Interval: time.Minute / time.Duration(cfg.RequestsPerMinute)}
+	}
+	if !cfg.Cache {
+		return e, nil
+	}
+	dir := cfg.CacheDir
+	if dir == "" {
+		cacheRoot, err := os.UserCacheDir()
+		if err != nil {
+			// No cache location; embed without one
+			return e, nil
+		}
+		dir = filepath.Join(cacheRoot, "craft", "embeddings")
+	}
+	return &CachedEmbedder{Embedder: e, Dir: dir}, nil
+}
+
+// RateLimitedEmbedder spaces out requests to an embedding API so that
+// concurrent indexing stays within the provider's quota. Every batch of up
+// to maxEmbedBatch texts counts as one request.
+type RateLimitedEmbedder struct {
+	Embedder
+	Interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (e *RateLimitedEmbedder) Embed(texts []string) ([][]float64, error) {
+	var out [][]float64
+	for start := 0; start < len(texts); start += maxEmbedBatch {
+		e.wait()
+		vectors, err := e.Embedder.Embed(texts[start:min(start+maxEmbedBatch, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vectors...)
+	}
+	return out, nil
+}
+
+// wait blocks until the next request slot
+func (e *RateLimitedEmbedder) wait() {
+	e.mu.Lock()
+	now := time.Now()
+	at := e.next
+	if at.Before(now) {
+		at = now
+	}
+	e.next = at.Add(e.Interval)
+	e.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// GeminiEmbedder uses the Gemini batchEmbedContents API
+type GeminiEmbedder struct {
+	APIKey     string
+	EmbedModel string
+}
+
+func (e *GeminiEmbedder) Model() string { return "gemini/" + e.EmbedModel }
+
+func (e *GeminiEmbedder) Embed(texts []string) ([][]float64, error) {
+	return embedBatches(texts, func(batch []string) ([][]float64, error) {
+		type content struct {
+			Parts []map[string]string `json:"parts"`
+		}
+		type request struct {
+			Model   string  `json:"model"`
+			Content content `json:"content"`
+		}
+		var reqs []request
+		for _, text := range batch {
+			reqs = append(reqs, request{
+				Model:   "models/" + e.EmbedModel,
+				Content: content{Parts: []map[string]string{{"text": text}}},
+			})
+		}
+
+		url := "https://generativelanguage.googleapis.com/v1beta/models/" + e.EmbedModel + ":batchEmbedContents"
+		var result struct {
+			Embeddings []struct {
+				Values []float64 `json:"values"`
+			} `json:"embeddings"`
+		}
+		headers := map[string]string{"x-goog-api-key": e.APIKey}
+		if err := postJSON(url, headers, map[string]interface{}{"requests": reqs}, &result); err != nil {
+			return nil, err
+		}
+		var out [][]float64
+		for _, emb := range result.Embeddings {
+			out = append(out, emb.Values)
+		}
+		return out, nil
+	})
+}
+
+// OpenAIEmbedder uses an OpenAI-compatible /embeddings endpoint (OpenAI,
+// Groq-style gateways, LM Studio, vLLM, llama.cpp server, ...)
+type OpenAIEmbedder struct {
+	BaseURL    string
+	APIKey     string
+	EmbedModel string
+}
+
+func (e *OpenAIEmbedder) Model() string { return "openai/" + e.EmbedModel }
+
+func (e *OpenAIEmbedder) Embed(texts []string) ([][]float64, error) {
+	return embedBatches(texts, func(batch []string) ([][]float64, error) {
+		var result struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+		}
+		headers := map[string]string{}
+		if e.APIKey != "" {
+			headers["Authorization"] = "Bearer " + e.APIKey
+		}
+		body := map[string]interface{}{"model": e.EmbedModel, "input": batch}
+		if err := postJSON(e.BaseURL+"/embeddings", headers, body, &result); err != nil {
+			return nil, err
+		}
+		out := make([][]float64, len(batch))
+		for _, d := range result.Data {
+			if d.Index < 0 || d.Index >= len(out) {
+				return nil, fmt.Errorf("embedding API returned index %d for %d inputs", d.Index, len(batch))
+			}
+			out[d.Index] = d.Embedding
+		}
+		return out, nil
+	})
+}
+
+// OllamaEmbedder uses a local Ollama server's /api/embed endpoint
+type OllamaEmbedder struct {
+	BaseURL    string
+	EmbedModel string
+}
+
+func (e *OllamaEmbedder) Model() string { return "ollama/" + e.EmbedModel }
+
+func (e *OllamaEmbedder) Embed(texts []string) ([][]float64, error) {
+	return embedBatches(texts, func(batch []string) ([][]float64, error) {
+		var result struct {
+			Embeddings [][]float64 `json:"embeddings"`
+		}
+		body := map[string]interface{}{"model": e.EmbedModel, "input": batch}
+		if err := postJSON(e.BaseURL+"/api/embed", nil, body, &result); err != nil {
+			return nil, err
+		}
+		return result.Embeddings, nil
+	})
+}
+
+// HashEmbedder is a deterministic, offline embedder based on feature hashing
+// of the lexical tokens. It has no semantic understanding but is stable
+// across runs, which makes it useful for tests and air-gapped machines.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+func (e *HashEmbedder) Model() string { return fmt.Sprintf("hash-%d", e.Dimensions) }
+
+func (e *HashEmbedder) Embed(texts []string) ([][]float64, error) {
+	if e.Dimensions <= 0 {
+		return nil, fmt.Errorf("hash embedder needs positive dimensions, got %d", e.Dimensions)
+	}
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		vec := make([]float64, e.Dimensions)
+		for _, term := range tokenize(text) {
+			h := fnv.New64a()
+			h.Write([]byte(term))
+			sum := h.Sum64()
+			// The top bit picks the sign so collisions tend to cancel out
+			if sum>>63 == 1 {
+				vec[sum%uint64(e.Dimensions)]--
+			} else {
+				vec[sum%uint64(e.Dimensions)]++
+			}
+		}
+		var norm float64
+		for _, v := range vec {
+			norm += v * v
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for j := range vec {
+				vec[j] /= norm
+			}
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+
+// embedBatches truncates texts to maxEmbedChars and embeds them in batches
+// of maxEmbedBatch, checking that every text got a vector
+func embedBatches(texts []string, embed func(batch []string) ([][]float64, error)) ([][]float64, error) {
+	var out [][]float64
+	for start := 0; start < len(texts); start += maxEmbedBatch {
+		batch := make([]string, 0, maxEmbedBatch)
+		for _, text := range texts[start:min(start+maxEmbedBatch, len(texts))] {
+			if len(text) > maxEmbedChars {
+				text = text[:maxEmbedChars]
+			}
+			batch = append(batch, text)
+		}
+		vectors, err := embed(batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(batch) {
+			return nil, fmt.Errorf("embedding API returned %d vectors for %d inputs", len(vectors), len(batch))
+		}
+		for _, v := range vectors {
+			if len(v) == 0 {
+				return nil, fmt.Errorf("embedding API returned an empty vector")
+			}
+		}
+		out = append(out, vectors...)
+	}
+	return out, nil
+}
+
+func postJSON(url string, headers map[string]string, body, result interface{}) error {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := embedClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("embedding API error %d: %s", resp.StatusCode, string(data))
+	}
+	return json.Unmarshal(data, result)
+}
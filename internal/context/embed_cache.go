@@ -0,0 +1,97 @@
+package context
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CachedEmbedder stores vectors on disk keyed by model name and a hash of
+// the text, so re-indexing, switching branches or rebuilding an index only
+// pays for chunks that were never embedded before. Cache failures are not
+// errors; the text is simply embedded again.
+//
+// Layout: <Dir>/<model>/<hh>/<sha256>, each file a little-endian float32 vector.
+type CachedEmbedder struct {
+	Embedder
+	Dir string
+}
+
+func (c *CachedEmbedder) Embed(texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	var missing []string
+	var missingAt []int
+	for i, text := range texts {
+		if vec, ok := c.get(text); ok {
+			out[i] = vec
+			continue
+		}
+		missing = append(missing, text)
+		missingAt = append(missingAt, i)
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	vectors, err := c.Embedder.Embed(missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, vec := range vectors {
+		out[missingAt[j]] = vec
+		c.put(missing[j], vec)
+	}
+	return out, nil
+}
+
+func (c *CachedEmbedder) path(text string) string {
+	if len(text) > maxEmbedChars {
+		// Providers only see the truncated text, so cache it under that
+		text = text[:maxEmbedChars]
+	}
+	sum := sha256.Sum256([]byte(text))
+	key := hex.EncodeToString(sum[:])
+	model := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(c.Model())
+	return filepath.Join(c.Dir, model, key[:2], key)
+}
+
+func (c *CachedEmbedder) get(text string) ([]float64, bool) {
+	data, err := os.ReadFile(c.path(text))
+	if err != nil || len(data) == 0 || len(data)%4 != 0 {
+		return nil, false
+	}
+	vec := make([]float64, len(data)/4)
+	for i := range vec {
+		vec[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+	}
+	return vec, true
+}
+
+func (c *CachedEmbedder) put(text string, vec []float64) {
+	path := c.path(text)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	data := make([]byte, len(vec)*4)
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(float32(v)))
+	}
+	// Write then rename so concurrent readers never see a partial vector
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,483 @@
+// Package context maintains the project context graph: embedded snippets of
+// the codebase that are searched to ground agent responses. The graph is
+// persisted to .craft-index.bin (index.path in the config).
+package context
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"craft-cli/internal/config"
+)
+
+// Node is one indexed piece of the project: a declaration, section or
+// paragraph of a file (see ChunkFile)
+type Node struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Content   string    `json:"content"`
+	StartLine int       `json:"start_line,omitempty"`
+	EndLine   int       `json:"end_line,omitempty"`
+	Symbol    string    `json:"symbol,omitempty"`
+	Kind      string    `json:"kind,omitempty"`
+	Embedding []float64 `json:"embedding,omitempty"`
+	Model     string    `json:"model,omitempty"` // embedder that produced Embedding
+	Hash      string    `json:"hash,omitempty"`  // hash of the whole file
+	ModTime   time.Time `json:"mod_time,omitempty"`
+	Refs      []Ref     `json:"refs,omitempty"` // dependencies, see Edges
+	Defs      []Symbol  `json:"defs,omitempty"` // declarations, see FindSymbol
+}
+
+// Location formats the node as path:start-end for citations, with the path
+// relative to the working directory
+func (n *Node) Location() string {
+	if n.StartLine == 0 {
+		return DisplayPath(n.Path)
+	}
+	return fmt.Sprintf("%s:%d-%d", DisplayPath(n.Path), n.StartLine, n.EndLine)
+}
+
+// SearchResult is a node ranked against a query. Similarity is the cosine
+// similarity of the embeddings (0 when the match is lexical only); Score is
+// the value results are ordered by. Via is set on results that were not hits
+// themselves but neighbours of the hit with that node ID.
+type SearchResult struct {
+	Node       *Node
+	Similarity float64
+	Score      float64
+	Via        string
+}
+
+// Graph holds every indexed node keyed by ID. It is safe for concurrent
+// use through its methods; code reading Nodes directly while a Watcher is
+// running should use Len or Snapshot instead.
+type Graph struct {
+	Nodes map[string]*Node `json:"nodes"`
+
+	mu       sync.RWMutex
+	gen      uint64 // bumped on every change to Nodes
+	lexical  *lexicalIndex
+	embedder Embedder
+
+	// Approximate nearest-neighbour index, built once annMinNodes nodes
+	// are embedded (see ann.go)
+	ann         *hnsw
+	annParams   ANNParams
+	annMinNodes int
+
+	// Dependency edges, resolved from node Refs on demand (see edges.go)
+	links      *linkIndex
+	goPackages goPackageCache
+}
+
+// NewGraph creates an empty graph using the embedder from the config
+func NewGraph() *Graph {
+	// Validate has already rejected unknown providers, the only error case
+	embedder, _ := NewEmbedder(config.Get().Embedding)
+	idx := config.Get().Index
+	return &Graph{
+		Nodes:    make(map[string]*Node),
+		embedder: embedder,
+		annParams: ANNParams{
+			M:              idx.ANNM,
+			EfConstruction: idx.ANNEfConstruction,
+			EfSearch:       idx.ANNEfSearch,
+		},
+		annMinNodes: idx.ANNMinNodes,
+	}
+}
+
+// SetEmbedder replaces the embedder; nil disables vector search. Nodes
+// embedded by a different model are re-embedded by the next Update.
+func (g *Graph) SetEmbedder(e Embedder) {
+	g.mu.Lock()
+	g.embedder = e
+	g.mu.Unlock()
+}
+
+// Embedder returns the embedder in use, or nil when search is lexical only
+func (g *Graph) Embedder() Embedder {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.embedder
+}
+
+// AddFile splits a file into chunks, embeds each one and stores them as
+// nodes, replacing any previous nodes for the same path
+func (g *Graph) AddFile(path, content string) error {
+	hash := hashContent(content)
+	var modTime time.Time
+	if info, err := os.Stat(projectAbs(path)); err == nil {
+		modTime = info.ModTime()
+	}
+
+	chunks := ChunkFile(path, content)
+	// Without an embedder nodes are still searchable lexically
+	var embeddings [][]float64
+	var model string
+	if embedder := g.Embedder(); embedder != nil && len(chunks) > 0 {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			// The path and symbol give the embedding context the chunk lacks
+			texts[i] = chunkHeader(path, chunk) + chunk.Content
+		}
+		var err error
+		if embeddings, err = embedder.Embed(texts); err != nil {
+			return fmt.Errorf("failed to embed %s: %w", path, err)
+		}
+		model = embedder.Model()
+	}
+
+	refs := g.chunkRefs(path, content, chunks)
+	defs := chunkDefs(path, content, chunks)
+
+	var nodes []*Node
+	for i, chunk := range chunks {
+		var embedding []float64
+		if embeddings != nil {
+			embedding = embeddings[i]
+		}
+		var chunkRefs []Ref
+		if refs != nil {
+			chunkRefs = refs[i]
+		}
+		nodes = append(nodes, &Node{
+			ID:        fmt.Sprintf("%s#L%d-%d", path, chunk.StartLine, chunk.EndLine),
+			Path:      path,
+			Content:   chunk.Content,
+			StartLine: chunk.StartLine,
+			EndLine:   chunk.EndLine,
+			Symbol:    chunk.Symbol,
+			Kind:      chunk.Kind,
+			Embedding: embedding,
+			Model:     model,
+			Hash:      hash,
+			ModTime:   modTime,
+			Refs:      chunkRefs,
+			Defs:      defs[i],
+		})
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removePathLocked(path)
+	g.links = nil
+	for _, n := range nodes {
+		g.Nodes[n.ID] = n
+		if g.lexical != nil {
+			g.lexical.add(n)
+		}
+		if g.ann != nil && n.Model == g.ann.model {
+			g.ann.insert(n.ID, n.Embedding)
+		}
+	}
+	g.changedLocked()
+	return nil
+}
+
+// changedLocked records a change to Nodes that the lexical index, if any,
+// has already been updated with; g.mu must be held for writing
+func (g *Graph) changedLocked() {
+	g.gen++
+	if g.lexical != nil {
+		g.lexical.gen = g.gen
+	}
+}
+
+// removePathLocked drops the nodes of path; g.mu must be held for writing
+func (g *Graph) removePathLocked(path string) {
+	for id, n := range g.Nodes {
+		if n.Path == path {
+			delete(g.Nodes, id)
+			if g.lexical != nil {
+				g.lexical.remove(id)
+			}
+			if g.ann != nil {
+				g.ann.remove(id)
+			}
+			g.links = nil
+			g.changedLocked()
+		}
+	}
+	if g.ann != nil && g.ann.stale() {
+		g.ann = nil
+	}
+}
+
+func chunkHeader(path string, chunk Chunk) string {
+	if chunk.Symbol == "" {
+		return path + "\n"
+	}
+	return fmt.Sprintf("%s: %s %s\n", path, chunk.Kind, chunk.Symbol)
+}
+
+// Len returns the number of nodes
+func (g *Graph) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.Nodes)
+}
+
+// Snapshot returns the current nodes in a new slice
+func (g *Graph) Snapshot() []*Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	nodes := make([]*Node, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// Search returns the k nodes most relevant to the query, each followed by
+// up to two of its graph neighbours (see expand). Lexical (BM25) results are
+// always used; when an embedding provider is configured they are combined
+// with vector similarity using reciprocal rank fusion.
+func (g *Graph) Search(query string, k int) ([]SearchResult, error) {
+	return g.SearchFiltered(query, k, SearchFilter{})
+}
+
+// SearchFiltered is Search restricted to the nodes that match filter,
+// neighbours included
+func (g *Graph) SearchFiltered(query string, k int, filter SearchFilter) ([]SearchResult, error) {
+	lexical := filter.apply(g.SearchLexical(query, 0))
+	embedder := g.Embedder()
+	if embedder == nil {
+		return filter.apply(g.expand(truncate(lexical, k))), nil
+	}
+
+	// Only the head of each ranking matters for fusion
+	depth := max(k*5, 50)
+	vectorDepth := depth
+	if !filter.empty() {
+		// Look further down so enough matching nodes survive the filter
+		vectorDepth *= 10
+	}
+	vector, err := g.searchVector(embedder, query, vectorDepth)
+	if err != nil {
+		return nil, err
+	}
+	vector = filter.apply(vector)
+
+	byID := make(map[string]SearchResult)
+	var vectorIDs, lexicalIDs []string
+	for _, r := range truncate(vector, depth) {
+		byID[r.Node.ID] = r
+		vectorIDs = append(vectorIDs, r.Node.ID)
+	}
+	for _, r := range truncate(lexical, depth) {
+		if _, ok := byID[r.Node.ID]; !ok {
+			byID[r.Node.ID] = SearchResult{Node: r.Node}
+		}
+		lexicalIDs = append(lexicalIDs, r.Node.ID)
+	}
+
+	var results []SearchResult
+	for _, f := range fuseRRF(vectorIDs, lexicalIDs) {
+		r := byID[f.id]
+		r.Score = f.score
+		results = append(results, r)
+	}
+	return filter.apply(g.expand(truncate(results, k))), nil
+}
+
+// SearchLexical ranks nodes by BM25 only; it needs no network access
+func (g *Graph) SearchLexical(query string, k int) []SearchResult {
+	return truncate(g.searchLexical(query, false), k)
+}
+
+// searchLexical ranks either the file nodes or the memory nodes by BM25.
+// Searches share the read lock; the write lock is taken only to rebuild a
+// stale index.
+func (g *Graph) searchLexical(query string, memories bool) []SearchResult {
+	g.mu.RLock()
+	for g.lexicalStaleLocked() {
+		g.mu.RUnlock()
+		g.mu.Lock()
+		g.ensureLexicalLocked()
+		g.mu.Unlock()
+		g.mu.RLock()
+	}
+	defer g.mu.RUnlock()
+	ranked := g.lexical.search(query)
+	results := make([]SearchResult, 0, len(ranked))
+	for _, r := range ranked {
+		if n, ok := g.Nodes[r.id]; ok && (n.Kind == KindMemory) == memories {
+			results = append(results, SearchResult{Node: n, Score: r.score})
+		}
+	}
+	return results
+}
+
+// SearchLexicalFiltered is SearchLexical restricted to the nodes that match
+// filter, for when the query cannot be embedded
+func (g *Graph) SearchLexicalFiltered(query string, k int, filter SearchFilter) []SearchResult {
+	return truncate(filter.apply(g.SearchLexical(query, 0)), k)
+}
+
+// searchVector returns the k nodes embedded by the same model that are most
+// similar to the query, using the ANN index when the graph is large enough
+// and an exact scan otherwise
+func (g *Graph) searchVector(embedder Embedder, query string, k int) ([]SearchResult, error) {
+	if g.Len() == 0 {
+		return nil, nil
+	}
+	vectors, err := embedder.Embed([]string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	queryEmbedding := vectors[0]
+
+	model := embedder.Model()
+	if results, ok := g.searchANN(model, queryEmbedding, k); ok {
+		return results, nil
+	}
+
+	var results []SearchResult
+	for _, node := range g.Snapshot() {
+		if len(node.Embedding) == 0 || node.Model != model || node.Kind == KindMemory {
+			continue
+		}
+		sim := cosineSimilarity(queryEmbedding, node.Embedding)
+		results = append(results, SearchResult{Node: node, Similarity: sim, Score: sim})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+	return truncate(results, k), nil
+}
+
+// Generation changes whenever Nodes do, so callers can tell when something
+// derived from the graph is out of date
+func (g *Graph) Generation() uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.gen
+}
+
+// lexicalStaleLocked reports whether the lexical index is missing or older
+// than Nodes; g.mu must be held
+func (g *Graph) lexicalStaleLocked() bool {
+	return g.lexical == nil || g.lexical.gen != g.gen
+}
+
+// ensureLexicalLocked (re)builds the lexical index when it is missing or out
+// of step with Nodes; g.mu must be held for writing
+func (g *Graph) ensureLexicalLocked() {
+	if !g.lexicalStaleLocked() {
+		return
+	}
+	g.lexical = newLexicalIndex()
+	for _, n := range g.Nodes {
+		g.lexical.add(n)
+	}
+	g.lexical.gen = g.gen
+}
+
+func truncate(results []SearchResult, k int) []SearchResult {
+	if k > 0 && len(results) > k {
+		return results[:k]
+	}
+	return results
+}
+
+// Save writes the graph to disk in the binary index format. The file is
+// replaced atomically so a crash mid-write never leaves a truncated index.
+func (g *Graph) Save(path string) error {
+	var nodes []*Node
+	for _, n := range g.Snapshot() {
+		// Memories are stored in their own files
+		if n.Kind != KindMemory {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	if err := writeFileAtomic(path, encodeIndex(nodes)); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return g.saveANN(path)
+}
+
+// writeFileAtomic replaces path with data through a uniquely named
+// temporary file, so concurrent writers never share a half-written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// CreateTemp makes the file private; the index is not
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Load replaces the graph with the index stored at path, which may be in
+// the binary format or the legacy JSON one
+func (g *Graph) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var nodes map[string]*Node
+	if bytes.HasPrefix(data, []byte(indexMagic)) {
+		nodes, err = decodeIndex(data)
+	} else {
+		nodes, err = decodeLegacyIndex(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load index %s: %w", path, err)
+	}
+	ann := g.loadANN(path, nodes)
+	g.mu.Lock()
+	g.Nodes = nodes
+	g.gen++
+	g.lexical = nil
+	g.links = nil
+	g.ann = ann
+	g.mu.Unlock()
+	return nil
+}
+
+func hashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
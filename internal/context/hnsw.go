@@ -0,0 +1,483 @@
+package context
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// ANNParams tune the approximate nearest-neighbour index. Larger values give
+// better recall at the cost of memory, build time (M, EfConstruction) and
+// query time (EfSearch).
+type ANNParams struct {
+	M              int // links per node and layer (layer 0 keeps 2*M)
+	EfConstruction int // candidate list size while inserting
+	EfSearch       int // candidate list size while searching
+}
+
+// hnsw is a Hierarchical Navigable Small World graph over normalized
+// vectors, using cosine distance. Removed points are tombstoned and skipped
+// in results; the owner rebuilds the index once too many accumulate.
+type hnsw struct {
+	params    ANNParams
+	model     string
+	dims      int
+	levelMult float64
+	rng       *rand.Rand
+
+	ids       []string
+	vectors   [][]float32
+	links     [][][]int32 // point -> layer -> neighbours
+	deleted   []bool
+	byID      map[string]int32
+	entry     int32
+	maxLayer  int
+	tombstone int
+}
+
+func newHNSW(params ANNParams, model string, dims int) *hnsw {
+	if params.M < 2 {
+		params.M = 2
+	}
+	if params.EfConstruction < params.M {
+		params.EfConstruction = params.M
+	}
+	return &hnsw{
+		params:    params,
+		model:     model,
+		dims:      dims,
+		levelMult: 1 / math.Log(float64(params.M)),
+		rng:       rand.New(rand.NewSource(1)),
+		byID:      make(map[string]int32),
+		entry:     -1,
+	}
+}
+
+// Len returns the number of live points
+func (h *hnsw) Len() int {
+	return len(h.byID)
+}
+
+// insert adds or replaces the vector for id
+func (h *hnsw) insert(id string, embedding []float64) {
+	if len(embedding) != h.dims {
+		return
+	}
+	h.remove(id)
+
+	q := normalize32(embedding)
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMult)
+	p := int32(len(h.ids))
+	h.ids = append(h.ids, id)
+	h.vectors = append(h.vectors, q)
+	h.links = append(h.links, make([][]int32, level+1))
+	h.deleted = append(h.deleted, false)
+	h.byID[id] = p
+
+	if h.entry < 0 {
+		h.entry, h.maxLayer = p, level
+		return
+	}
+
+	ep := h.entry
+	for layer := h.maxLayer; layer > level; layer-- {
+		ep = h.searchLayer(q, ep, 1, layer)[0].id
+	}
+	for layer := min(level, h.maxLayer); layer >= 0; layer-- {
+		candidates := h.searchLayer(q, ep, h.params.EfConstruction, layer)
+		neighbours := h.selectNeighbours(candidates, h.params.M)
+		h.links[p][layer] = neighbours
+		for _, n := range neighbours {
+			h.links[n][layer] = append(h.links[n][layer], p)
+			if len(h.links[n][layer]) > h.maxLinks(layer) {
+				h.prune(n, layer)
+			}
+		}
+		ep = candidates[0].id
+	}
+	if level > h.maxLayer {
+		h.entry, h.maxLayer = p, level
+	}
+}
+
+// remove tombstones id; its links stay so the graph remains navigable
+func (h *hnsw) remove(id string) {
+	p, ok := h.byID[id]
+	if !ok {
+		return
+	}
+	delete(h.byID, id)
+	h.deleted[p] = true
+	h.tombstone++
+}
+
+// stale reports whether tombstones outnumber half the live points, at which
+// point recall and memory suffer and the index should be rebuilt
+func (h *hnsw) stale() bool {
+	return h.tombstone > 64 && h.tombstone > len(h.byID)/2
+}
+
+// search returns up to k live points closest to the query
+func (h *hnsw) search(query []float64, k, ef int) []scoredID {
+	if h.entry < 0 || len(query) != h.dims || k <= 0 {
+		return nil
+	}
+	q := normalize32(query)
+	ep := h.entry
+	for layer := h.maxLayer; layer > 0; layer-- {
+		ep = h.searchLayer(q, ep, 1, layer)[0].id
+	}
+	// Tombstones occupy candidate slots, so widen the search to compensate
+	ef = max(ef, k) + min(h.tombstone, max(ef, k))
+	var out []scoredID
+	for _, c := range h.searchLayer(q, ep, ef, 0) {
+		if h.deleted[c.id] {
+			continue
+		}
+		out = append(out, scoredID{id: h.ids[c.id], score: 1 - float64(c.dist)})
+		if len(out) == k {
+			break
+		}
+	}
+	return out
+}
+
+func (h *hnsw) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * h.params.M
+	}
+	return h.params.M
+}
+
+type candidate struct {
+	id   int32
+	dist float32
+}
+
+// searchLayer is the greedy best-first search of the HNSW paper: it returns
+// up to ef points closest to q on one layer, nearest first
+func (h *hnsw) searchLayer(q []float32, ep int32, ef, layer int) []candidate {
+	visited := make(map[int32]struct{}, ef*4)
+	visited[ep] = struct{}{}
+	start := candidate{ep, h.distance(q, ep)}
+	frontier := &minHeap{start}
+	best := &maxHeap{start}
+
+	for frontier.Len() > 0 {
+		c := heap.Pop(frontier).(candidate)
+		if c.dist > (*best)[0].dist && best.Len() >= ef {
+			break
+		}
+		if layer >= len(h.links[c.id]) {
+			continue
+		}
+		for _, n := range h.links[c.id][layer] {
+			if _, seen := visited[n]; seen {
+				continue
+			}
+			visited[n] = struct{}{}
+			d := h.distance(q, n)
+			if best.Len() < ef || d < (*best)[0].dist {
+				heap.Push(frontier, candidate{n, d})
+				heap.Push(best, candidate{n, d})
+				if best.Len() > ef {
+					heap.Pop(best)
+				}
+			}
+		}
+	}
+
+	out := make([]candidate, best.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(best).(candidate)
+	}
+	return out
+}
+
+// selectNeighbours applies the paper's heuristic: a candidate is kept only
+// if it is closer to the new point than to any neighbour already kept, which
+// spreads links across clusters instead of bunching them in one
+func (h *hnsw) selectNeighbours(candidates []candidate, m int) []int32 {
+	var out []int32
+	for _, c := range candidates {
+		if len(out) == m {
+			break
+		}
+		keep := true
+		for _, s := range out {
+			if h.pairDistance(c.id, s) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			out = append(out, c.id)
+		}
+	}
+	// Top up with the nearest rejected candidates so sparse regions stay linked
+	for _, c := range candidates {
+		if len(out) == m {
+			break
+		}
+		if !containsID(out, c.id) {
+			out = append(out, c.id)
+		}
+	}
+	return out
+}
+
+// prune trims a neighbour list that grew past its limit
+func (h *hnsw) prune(p int32, layer int) {
+	links := h.links[p][layer]
+	candidates := make([]candidate, len(links))
+	for i, n := range links {
+		candidates[i] = candidate{n, h.pairDistance(p, n)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	h.links[p][layer] = h.selectNeighbours(candidates, h.maxLinks(layer))
+}
+
+func (h *hnsw) distance(q []float32, p int32) float32 {
+	return 1 - dot32(q, h.vectors[p])
+}
+
+func (h *hnsw) pairDistance(a, b int32) float32 {
+	return 1 - dot32(h.vectors[a], h.vectors[b])
+}
+
+// dot32 is the hot loop of every ANN operation; four accumulators let the
+// CPU overlap the multiplies
+func dot32(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+func normalize32(v []float64) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	out := make([]float32, len(v))
+	if norm == 0 {
+		return out
+	}
+	norm = math.Sqrt(norm)
+	for i, x := range v {
+		out[i] = float32(x / norm)
+	}
+	return out
+}
+
+func containsID(ids []int32, id int32) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+type minHeap []candidate
+
+func (h minHeap) Len() int            { return len(h) }
+func (h minHeap) Less(i, j int) bool  { return h[i].dist < h[j].dist }
+func (h minHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type maxHeap []candidate
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// ANN sidecar layout (little-endian): magic "CRAFTANN", version u32, then
+// M, efConstruction, dims, point count, entry point and top layer (u32
+// each, entry as i32), the model name, and per point its node ID, a
+// tombstone flag and its links per layer; a SHA-256 of everything before it
+// ends the file. Vectors are not stored: they come from the index nodes.
+const (
+	annMagic   = "CRAFTANN"
+	annVersion = 1
+)
+
+func (h *hnsw) save(path string) error {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	u32 := func(v uint32) { binary.Write(w, binary.LittleEndian, v) }
+	str := func(s string) {
+		u32(uint32(len(s)))
+		w.WriteString(s)
+	}
+
+	w.WriteString(annMagic)
+	u32(annVersion)
+	u32(uint32(h.params.M))
+	u32(uint32(h.params.EfConstruction))
+	u32(uint32(h.dims))
+	u32(uint32(len(h.ids)))
+	u32(uint32(h.entry))
+	u32(uint32(h.maxLayer))
+	str(h.model)
+	for p, id := range h.ids {
+		str(id)
+		// Tombstoned points still route searches but their nodes are gone,
+		// so their vectors are kept here
+		if h.deleted[p] {
+			w.WriteByte(1)
+			for _, v := range h.vectors[p] {
+				u32(math.Float32bits(v))
+			}
+		} else {
+			w.WriteByte(0)
+		}
+		w.WriteByte(byte(len(h.links[p])))
+		for _, links := range h.links[p] {
+			u32(uint32(len(links)))
+			for _, n := range links {
+				u32(uint32(n))
+			}
+		}
+	}
+	w.Flush()
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// loadHNSW reads a sidecar written by save, taking vectors from nodes. It
+// fails if the file is damaged or no longer matches the nodes, in which
+// case the caller rebuilds the index.
+func loadHNSW(path string, params ANNParams, nodes map[string]*Node) (*hnsw, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(annMagic)+sha256.Size || string(data[:len(annMagic)]) != annMagic {
+		return nil, fmt.Errorf("%w: bad ANN header", ErrIndexCorrupt)
+	}
+	body := data[:len(data)-sha256.Size]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, fmt.Errorf("%w: ANN checksum mismatch", ErrIndexCorrupt)
+	}
+
+	r := bytes.NewReader(body[len(annMagic):])
+	var readErr error
+	u32 := func() uint32 {
+		var v uint32
+		if readErr == nil {
+			readErr = binary.Read(r, binary.LittleEndian, &v)
+		}
+		return v
+	}
+	str := func() string {
+		n := u32()
+		// Lengths are checked so a damaged file cannot force a huge allocation
+		if readErr != nil || int64(n) > int64(r.Len()) {
+			readErr = io.ErrUnexpectedEOF
+			return ""
+		}
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return string(b)
+	}
+
+	if v := u32(); v != annVersion {
+		return nil, fmt.Errorf("%w: ANN version %d", ErrIndexVersion, v)
+	}
+	// Built with other link parameters: rebuild so the new ones apply
+	if m, efc := int(u32()), int(u32()); m != params.M || efc != params.EfConstruction {
+		return nil, fmt.Errorf("ANN parameters changed")
+	}
+	h := newHNSW(params, "", int(u32()))
+	count := int(u32())
+	h.entry = int32(u32())
+	h.maxLayer = int(u32())
+	h.model = str()
+	if readErr != nil || count > len(body) || h.dims > len(body) {
+		return nil, fmt.Errorf("%w: truncated ANN header", ErrIndexCorrupt)
+	}
+
+	for p := 0; p < count; p++ {
+		id := str()
+		deleted, _ := r.ReadByte()
+		var vector []float32
+		if deleted == 1 {
+			if int64(h.dims)*4 > int64(r.Len()) {
+				return nil, fmt.Errorf("%w: truncated ANN point %d", ErrIndexCorrupt, p)
+			}
+			vector = make([]float32, h.dims)
+			for i := range vector {
+				vector[i] = math.Float32frombits(u32())
+			}
+			h.tombstone++
+		}
+		layers, err := r.ReadByte()
+		if readErr != nil || err != nil {
+			return nil, fmt.Errorf("%w: truncated ANN point %d", ErrIndexCorrupt, p)
+		}
+		links := make([][]int32, layers)
+		for l := range links {
+			n := u32()
+			if int64(n)*4 > int64(r.Len()) {
+				return nil, fmt.Errorf("%w: truncated ANN links", ErrIndexCorrupt)
+			}
+			links[l] = make([]int32, n)
+			for i := range links[l] {
+				links[l][i] = int32(u32())
+				if links[l][i] < 0 || int(links[l][i]) >= count {
+					return nil, fmt.Errorf("%w: ANN link out of range", ErrIndexCorrupt)
+				}
+			}
+		}
+
+		if deleted != 1 {
+			node, ok := nodes[id]
+			if !ok || node.Model != h.model || len(node.Embedding) != h.dims {
+				return nil, fmt.Errorf("ANN index is out of date")
+			}
+			vector = normalize32(node.Embedding)
+			h.byID[id] = int32(p)
+		}
+		h.ids = append(h.ids, id)
+		h.vectors = append(h.vectors, vector)
+		h.links = append(h.links, links)
+		h.deleted = append(h.deleted, deleted == 1)
+	}
+	if readErr != nil || (count > 0 && (h.entry < 0 || int(h.entry) >= count)) {
+		return nil, fmt.Errorf("%w: bad ANN entry point", ErrIndexCorrupt)
+	}
+	return h, nil
+}
@@ -0,0 +1,138 @@
+package context
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// testANNParams are the configured defaults
+var testANNParams = ANNParams{M: 16, EfConstruction: 100, EfSearch: 96}
+
+func buildHNSW(corpus [][]float64, params ANNParams) *hnsw {
+	h := newHNSW(params, "test", len(corpus[0]))
+	for i, v := range corpus {
+		h.insert(fmt.Sprint(i), v)
+	}
+	return h
+}
+
+// recall returns the mean fraction of the exact top k found by the index
+func recall(h *hnsw, corpus, queries [][]float64, k, ef int) float64 {
+	found := 0
+	for _, q := range queries {
+		exact := exactTopK(corpus, q, k)
+		for _, r := range h.search(q, k, ef) {
+			if exact[r.id] {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(len(queries)*k)
+}
+
+func TestHNSWRecall(t *testing.T) {
+	corpus, queries := annCorpus(3000, 64, 100)
+	h := buildHNSW(corpus, testANNParams)
+	tests := []struct {
+		k, ef int
+		min   float64
+	}{
+		{10, testANNParams.EfSearch, 0.95},
+		{1, testANNParams.EfSearch, 0.95},
+		{50, testANNParams.EfSearch, 0.9},
+		// The smallest useful candidate list still finds most neighbours
+		{10, 10, 0.9},
+	}
+	for _, tt := range tests {
+		if r := recall(h, corpus, queries, tt.k, tt.ef); r < tt.min {
+			t.Errorf("recall@%d with ef=%d = %.3f, want at least %.2f", tt.k, tt.ef, r, tt.min)
+		}
+	}
+}
+
+func TestHNSWRemove(t *testing.T) {
+	corpus, queries := annCorpus(500, 16, 20)
+	h := buildHNSW(corpus, testANNParams)
+	for i := 0; i < len(corpus); i += 2 {
+		h.remove(fmt.Sprint(i))
+	}
+	if h.Len() != len(corpus)/2 {
+		t.Fatalf("Len = %d, want %d", h.Len(), len(corpus)/2)
+	}
+	if !h.stale() {
+		t.Error("index with as many tombstones as live points is not stale")
+	}
+	for _, q := range queries {
+		res := h.search(q, 10, testANNParams.EfSearch)
+		if len(res) != 10 {
+			t.Fatalf("search returned %d results, want 10", len(res))
+		}
+		for _, r := range res {
+			if _, ok := h.byID[r.id]; !ok {
+				t.Fatalf("search returned removed point %s", r.id)
+			}
+		}
+	}
+
+	// Re-inserting a removed point makes it findable again
+	h.insert("0", corpus[0])
+	if res := h.search(corpus[0], 1, testANNParams.EfSearch); len(res) != 1 || res[0].id != "0" {
+		t.Errorf("search for a re-inserted point = %v", res)
+	}
+}
+
+func TestHNSWSaveLoad(t *testing.T) {
+	corpus, queries := annCorpus(300, 8, 10)
+	h := buildHNSW(corpus, testANNParams)
+	h.remove("7")
+	nodes := make(map[string]*Node)
+	for i, v := range corpus {
+		if i != 7 {
+			id := fmt.Sprint(i)
+			nodes[id] = &Node{ID: id, Embedding: v, Model: "test"}
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "index.ann")
+	if err := h.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadHNSW(path, testANNParams, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range queries {
+		want := h.search(q, 5, testANNParams.EfSearch)
+		got := loaded.search(q, 5, testANNParams.EfSearch)
+		if fmt.Sprint(ids(got)) != fmt.Sprint(ids(want)) {
+			t.Fatalf("loaded index returns %v, want %v", ids(got), ids(want))
+		}
+	}
+
+	other := testANNParams
+	other.M++
+	if _, err := loadHNSW(path, other, nodes); err == nil {
+		t.Error("index built with other parameters was loaded")
+	}
+}
+
+// BenchmarkSearch measures query latency of the ANN index and of an exact
+// scan over the same corpus, and reports the recall@k of the index
+func BenchmarkSearch(b *testing.B) {
+	const k = 10
+	corpus, queries := annCorpus(20000, 256, 100)
+	h := buildHNSW(corpus, testANNParams)
+
+	b.Run("ann", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			h.search(queries[i%len(queries)], k, testANNParams.EfSearch)
+		}
+		b.ReportMetric(recall(h, corpus, queries, k, testANNParams.EfSearch), "recall@10")
+	})
+	b.Run("exact", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			exactTopK(corpus, queries[i%len(queries)], k)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package context
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IgnoreFiles are read from every directory of the project. They use the
+// .gitignore syntax; .craftignore excludes files from the index only.
+var IgnoreFiles = []string{".gitignore", ".craftignore"}
+
+// ignoreRecheck is how long parsed ignore files are trusted before their
+// mtime is checked again
+const ignoreRecheck = 2 * time.Second
+
+// ignoreRule is one pattern line of an ignore file
+type ignoreRule struct {
+	re      *regexp.Regexp // matches slash paths relative to the file's directory
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreDir holds the rules of the ignore files in one directory
+type ignoreDir struct {
+	rules   []ignoreRule
+	stamp   string
+	checked time.Time
+}
+
+// ignoreCache parses ignore files on demand and keeps them until they change
+type ignoreCache struct {
+	mu   sync.Mutex
+	dirs map[string]*ignoreDir
+}
+
+var ignoreFiles = &ignoreCache{dirs: make(map[string]*ignoreDir)}
+
+// ignoredByFiles reports whether path, or a directory above it, is excluded
+// by an ignore file. Relative paths are matched up to the project root,
+// absolute ones up to the enclosing git repository.
+func (c *ignoreCache) ignoredByFiles(path string, isDir bool) bool {
+	path = filepath.Clean(path)
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	// An excluded directory excludes everything below it
+	for i := 1; i <= len(parts); i++ {
+		sub := filepath.FromSlash(strings.Join(parts[:i], "/"))
+		if sub == "" {
+			continue
+		}
+		if c.match(sub, isDir || i < len(parts)) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the ignore files of every directory above path, outermost
+// first, so that later rules (and deeper files) override earlier ones
+func (c *ignoreCache) match(path string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == "." || dir == filepath.Dir(dir) || isRepoRoot(dir) {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules := c.rules(dirs[i])
+		if len(rules) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, r := range rules {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if r.re.MatchString(rel) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func isRepoRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(projectAbs(dir), ".git"))
+	return err == nil
+}
+
+// rules returns the parsed ignore files of dir. They are cached by absolute
+// directory, as a relative one means another directory once the project
+// root changes.
+func (c *ignoreCache) rules(dir string) []ignoreRule {
+	dir = projectAbs(dir)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.dirs[dir]
+	if ok && time.Since(cached.checked) < ignoreRecheck {
+		return cached.rules
+	}
+
+	var stamp strings.Builder
+	for _, name := range IgnoreFiles {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			stamp.WriteString(name + info.ModTime().String() + ";")
+		}
+	}
+	if ok && cached.stamp == stamp.String() {
+		cached.checked = time.Now()
+		return cached.rules
+	}
+
+	entry := &ignoreDir{stamp: stamp.String(), checked: time.Now()}
+	for _, name := range IgnoreFiles {
+		entry.rules = append(entry.rules, parseIgnoreFile(filepath.Join(dir, name))...)
+	}
+	c.dirs[dir] = entry
+	return entry.rules
+}
+
+// parseIgnoreFile reads the patterns of a .gitignore-style file
+func parseIgnoreFile(path string) []ignoreRule {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	var r ignoreRule
+	if strings.HasPrefix(line, "!") {
+		r.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	// A pattern with a slash is relative to the file's directory; one
+	// without matches a name at any depth
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	r.re = globRegexp(line)
+	return r, true
+}
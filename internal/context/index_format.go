@@ -0,0 +1,339 @@
+package context
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Binary index layout (all integers little-endian):
+//
+//	header   magic "CRAFTIDX", version u32, node count u32,
+//	         records, vectors and strings section lengths (u64 each),
+//	         SHA-256 of the three sections
+//	records  one fixed-size record per node: nine string refs
+//	         (offset u32, length u32) for ID, path, content, symbol, kind,
+//	         model, file hash, refs and defs; start and end line (u32); mtime
+//	         (i64, Unix nanoseconds); vector offset and length in floats (u32)
+//	vectors  every embedding as float32, back to back
+//	strings  deduplicated string bytes referenced by the records
+//
+// Sections are fixed-offset and 4-byte aligned so the file can be mapped
+// and read in place; Load reads it in one call and slices strings out of a
+// single allocation, so duplicate chunks and paths share memory too.
+//
+// Version 1 records had no refs string and version 2 no defs string. Their
+// nodes are loaded without a file hash or mtime so the next Update
+// re-indexes them and extracts what is missing.
+const (
+	indexMagic   = "CRAFTIDX"
+	indexVersion = 3
+
+	indexHeaderSize = 8 + 4 + 4 + 8 + 8 + 8 + sha256.Size
+)
+
+// recordLayout returns the number of strings and the record size of a version
+func recordLayout(version uint32) (count, size uint64) {
+	count = 9
+	if version < 3 {
+		count = 6 + uint64(version)
+	}
+	return count, count*8 + 4 + 4 + 8 + 4 + 4
+}
+
+// Refs and defs are each stored as one string: fields separated by
+// refFieldSep, entries separated by refSep
+const (
+	refFieldSep = "\x1f"
+	refSep      = "\x1e"
+)
+
+func encodeRefs(refs []Ref) string {
+	parts := make([]string, len(refs))
+	for i, r := range refs {
+		parts[i] = r.Kind + refFieldSep + r.Target
+	}
+	return strings.Join(parts, refSep)
+}
+
+func decodeRefs(s string) ([]Ref, bool) {
+	if s == "" {
+		return nil, true
+	}
+	var refs []Ref
+	for _, part := range strings.Split(s, refSep) {
+		kind, target, ok := strings.Cut(part, refFieldSep)
+		if !ok {
+			return nil, false
+		}
+		refs = append(refs, Ref{Kind: kind, Target: target})
+	}
+	return refs, true
+}
+
+func encodeDefs(defs []Symbol) string {
+	parts := make([]string, len(defs))
+	for i, d := range defs {
+		parts[i] = strings.Join([]string{
+			d.Name, d.Kind, d.Container,
+			strconv.Itoa(d.StartLine), strconv.Itoa(d.EndLine),
+			d.Signature, d.Doc,
+		}, refFieldSep)
+	}
+	return strings.Join(parts, refSep)
+}
+
+func decodeDefs(s string) ([]Symbol, bool) {
+	if s == "" {
+		return nil, true
+	}
+	var defs []Symbol
+	for _, part := range strings.Split(s, refSep) {
+		f := strings.Split(part, refFieldSep)
+		if len(f) != 7 {
+			return nil, false
+		}
+		start, err1 := strconv.Atoi(f[3])
+		end, err2 := strconv.Atoi(f[4])
+		if err1 != nil || err2 != nil {
+			return nil, false
+		}
+		defs = append(defs, Symbol{Name: f[0], Kind: f[1], Container: f[2], StartLine: start, EndLine: end, Signature: f[5], Doc: f[6]})
+	}
+	return defs, true
+}
+
+// LegacyIndexPath is the JSON index written by earlier versions. It is
+// migrated to the binary format by LoadIndex.
+const LegacyIndexPath = ".craft-index.json"
+
+var (
+	// ErrIndexCorrupt means the index failed its integrity checks
+	ErrIndexCorrupt = errors.New("context index is corrupt")
+	// ErrIndexVersion means the index was written by a newer craft
+	ErrIndexVersion = errors.New("context index version is not supported")
+)
+
+// LoadIndex loads the index at path. When path does not exist but a JSON
+// index from an earlier version sits next to it, that index is loaded,
+// saved at path in the binary format and removed; migrated reports this.
+// Errors wrapping ErrIndexCorrupt or ErrIndexVersion mean the index should
+// be rebuilt.
+func (g *Graph) LoadIndex(path string) (migrated bool, err error) {
+	_, statErr := os.Stat(path)
+	legacy := filepath.Join(filepath.Dir(path), LegacyIndexPath)
+	if !os.IsNotExist(statErr) || legacy == filepath.Clean(path) {
+		return false, g.Load(path)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return false, statErr
+	}
+
+	if err := g.Load(legacy); err != nil {
+		return false, err
+	}
+	if err := g.Save(path); err != nil {
+		return false, fmt.Errorf("failed to migrate %s: %w", legacy, err)
+	}
+	os.Remove(legacy)
+	return true, nil
+}
+
+// encodeIndex serializes nodes in the binary format
+func encodeIndex(nodes []*Node) []byte {
+	return encodeIndexVersion(nodes, indexVersion)
+}
+
+// encodeIndexVersion serializes nodes in the layout of a version; earlier
+// versions leave out the strings they did not have
+func encodeIndexVersion(nodes []*Node, version uint32) []byte {
+	var strs bytes.Buffer
+	refs := make(map[string][2]uint32)
+	ref := func(s string) [2]uint32 {
+		if r, ok := refs[s]; ok {
+			return r
+		}
+		r := [2]uint32{uint32(strs.Len()), uint32(len(s))}
+		strs.WriteString(s)
+		refs[s] = r
+		return r
+	}
+
+	recordStrings, recordSize := recordLayout(version)
+	records := make([]byte, 0, uint64(len(nodes))*recordSize)
+	var vectors []byte
+	var floats uint32
+	for _, n := range nodes {
+		fields := []string{n.ID, n.Path, n.Content, n.Symbol, n.Kind, n.Model, n.Hash, encodeRefs(n.Refs), encodeDefs(n.Defs)}
+		for _, s := range fields[:recordStrings] {
+			r := ref(s)
+			records = binary.LittleEndian.AppendUint32(records, r[0])
+			records = binary.LittleEndian.AppendUint32(records, r[1])
+		}
+		records = binary.LittleEndian.AppendUint32(records, uint32(n.StartLine))
+		records = binary.LittleEndian.AppendUint32(records, uint32(n.EndLine))
+		var mtime int64
+		if !n.ModTime.IsZero() {
+			mtime = n.ModTime.UnixNano()
+		}
+		records = binary.LittleEndian.AppendUint64(records, uint64(mtime))
+		records = binary.LittleEndian.AppendUint32(records, floats)
+		records = binary.LittleEndian.AppendUint32(records, uint32(len(n.Embedding)))
+		for _, v := range n.Embedding {
+			vectors = binary.LittleEndian.AppendUint32(vectors, math.Float32bits(float32(v)))
+		}
+		floats += uint32(len(n.Embedding))
+	}
+
+	sum := sha256.New()
+	sum.Write(records)
+	sum.Write(vectors)
+	sum.Write(strs.Bytes())
+
+	out := make([]byte, 0, indexHeaderSize+len(records)+len(vectors)+strs.Len())
+	out = append(out, indexMagic...)
+	out = binary.LittleEndian.AppendUint32(out, version)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(nodes)))
+	out = binary.LittleEndian.AppendUint64(out, uint64(len(records)))
+	out = binary.LittleEndian.AppendUint64(out, uint64(len(vectors)))
+	out = binary.LittleEndian.AppendUint64(out, uint64(strs.Len()))
+	out = sum.Sum(out)
+	out = append(out, records...)
+	out = append(out, vectors...)
+	return append(out, strs.Bytes()...)
+}
+
+// decodeIndex parses and verifies a binary index
+func decodeIndex(data []byte) (map[string]*Node, error) {
+	corrupt := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrIndexCorrupt, fmt.Sprintf(format, args...))
+	}
+	if len(data) < indexHeaderSize || string(data[:8]) != indexMagic {
+		return nil, corrupt("bad header")
+	}
+	version := binary.LittleEndian.Uint32(data[8:])
+	if version > indexVersion {
+		return nil, fmt.Errorf("%w: version %d, this craft reads up to %d", ErrIndexVersion, version, indexVersion)
+	}
+	if version == 0 {
+		return nil, corrupt("version 0")
+	}
+	recordStrings, recordSize := recordLayout(version)
+	count := uint64(binary.LittleEndian.Uint32(data[12:]))
+	recordsLen := binary.LittleEndian.Uint64(data[16:])
+	vectorsLen := binary.LittleEndian.Uint64(data[24:])
+	stringsLen := binary.LittleEndian.Uint64(data[32:])
+	body := data[indexHeaderSize:]
+	// Each length is checked against what is left of the file before it is
+	// used, so crafted lengths cannot overflow a sum
+	size := uint64(len(body))
+	if recordsLen > size || vectorsLen > size-recordsLen || stringsLen != size-recordsLen-vectorsLen ||
+		recordsLen != count*recordSize || vectorsLen%4 != 0 {
+		return nil, corrupt("section sizes do not match the file (%d bytes)", len(data))
+	}
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], data[40:indexHeaderSize]) {
+		return nil, corrupt("checksum mismatch")
+	}
+
+	records := body[:recordsLen]
+	vectors := body[recordsLen : recordsLen+vectorsLen]
+	// One allocation for every string; nodes share slices of it
+	strs := string(body[recordsLen+vectorsLen:])
+	floats := uint64(len(vectors) / 4)
+
+	nodes := make(map[string]*Node, count)
+	for i := uint64(0); i < count; i++ {
+		rec := records[i*recordSize : (i+1)*recordSize]
+		fields := make([]string, recordStrings)
+		for j := range fields {
+			off := uint64(binary.LittleEndian.Uint32(rec[j*8:]))
+			length := uint64(binary.LittleEndian.Uint32(rec[j*8+4:]))
+			if off+length > uint64(len(strs)) {
+				return nil, corrupt("record %d: string out of range", i)
+			}
+			fields[j] = strs[off : off+length]
+		}
+		rest := rec[recordStrings*8:]
+		n := &Node{
+			ID:        fields[0],
+			Path:      fields[1],
+			Content:   fields[2],
+			Symbol:    fields[3],
+			Kind:      fields[4],
+			Model:     fields[5],
+			Hash:      fields[6],
+			StartLine: int(binary.LittleEndian.Uint32(rest[0:])),
+			EndLine:   int(binary.LittleEndian.Uint32(rest[4:])),
+		}
+		if mtime := int64(binary.LittleEndian.Uint64(rest[8:])); mtime != 0 {
+			n.ModTime = time.Unix(0, mtime)
+		}
+		vecOff := uint64(binary.LittleEndian.Uint32(rest[16:]))
+		vecLen := uint64(binary.LittleEndian.Uint32(rest[20:]))
+		if vecOff+vecLen > floats {
+			return nil, corrupt("record %d: vector out of range", i)
+		}
+		if vecLen > 0 {
+			n.Embedding = make([]float64, vecLen)
+			for k := range n.Embedding {
+				n.Embedding[k] = float64(math.Float32frombits(binary.LittleEndian.Uint32(vectors[(vecOff+uint64(k))*4:])))
+			}
+		}
+		if version < 3 {
+			// Force re-indexing so refs and defs are extracted
+			n.Hash, n.ModTime = "", time.Time{}
+		}
+		if version >= 2 {
+			refs, ok := decodeRefs(fields[7])
+			if !ok {
+				return nil, corrupt("record %d: malformed refs", i)
+			}
+			n.Refs = refs
+		}
+		if version >= 3 {
+			defs, ok := decodeDefs(fields[8])
+			if !ok {
+				return nil, corrupt("record %d: malformed defs", i)
+			}
+			n.Defs = defs
+		}
+		if n.ID == "" {
+			return nil, corrupt("record %d: empty node ID", i)
+		}
+		if _, dup := nodes[n.ID]; dup {
+			return nil, corrupt("record %d: duplicate node %s", i, n.ID)
+		}
+		nodes[n.ID] = n
+	}
+	return nodes, nil
+}
+
+// decodeLegacyIndex parses the JSON index written by earlier versions
+func decodeLegacyIndex(data []byte) (map[string]*Node, error) {
+	var legacy struct {
+		Nodes map[string]*Node `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIndexCorrupt, err)
+	}
+	if legacy.Nodes == nil {
+		legacy.Nodes = make(map[string]*Node)
+	}
+	for id, n := range legacy.Nodes {
+		if n == nil {
+			return nil, fmt.Errorf("%w: null node %s", ErrIndexCorrupt, id)
+		}
+		// Legacy nodes have no refs or defs; force re-indexing
+		n.Hash, n.ModTime = "", time.Time{}
+	}
+	return legacy.Nodes, nil
+}
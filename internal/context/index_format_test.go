@@ -0,0 +1,168 @@
+package context
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testNodes() []*Node {
+	mtime := time.Unix(1700000000, 123456789)
+	return []*Node{
+		{
+			ID: "a.go#1", Path: "a.go", Content: "func A() {}\n",
+			StartLine: 1, EndLine: 1, Symbol: "A", Kind: "func",
+			Embedding: []float64{0.5, -1.25, 0}, Model: "test-model",
+			Hash: "h1", ModTime: mtime,
+			Refs: []Ref{{Kind: "import", Target: "fmt"}, {Kind: "call", Target: "B"}},
+			Defs: []Symbol{{Name: "A", Kind: "func", StartLine: 1, EndLine: 1, Signature: "func A()", Doc: "A does nothing"}},
+		},
+		{
+			// Shares its path and content strings with the node above
+			ID: "a.go#2", Path: "a.go", Content: "func A() {}\n",
+			StartLine: 3, EndLine: 4, Hash: "h1", ModTime: mtime,
+		},
+		{ID: "README.md#1", Path: "README.md", Content: "# Title\n", Kind: "section"},
+	}
+}
+
+func byID(nodes []*Node) map[string]*Node {
+	out := make(map[string]*Node, len(nodes))
+	for _, n := range nodes {
+		out[n.ID] = n
+	}
+	return out
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	tests := []struct {
+		version uint32
+		// adjust turns a written node into what the version reads back
+		adjust func(n *Node)
+	}{
+		{3, func(n *Node) {}},
+		{2, func(n *Node) {
+			n.Defs = nil
+			n.Hash, n.ModTime = "", time.Time{}
+		}},
+		{1, func(n *Node) {
+			n.Refs, n.Defs = nil, nil
+			n.Hash, n.ModTime = "", time.Time{}
+		}},
+	}
+	for _, tt := range tests {
+		got, err := decodeIndex(encodeIndexVersion(testNodes(), tt.version))
+		if err != nil {
+			t.Fatalf("version %d: %v", tt.version, err)
+		}
+		want := byID(testNodes())
+		for _, n := range want {
+			tt.adjust(n)
+		}
+		if !reflect.DeepEqual(got, want) {
+			for id := range want {
+				if !reflect.DeepEqual(got[id], want[id]) {
+					t.Errorf("version %d: node %s = %+v, want %+v", tt.version, id, got[id], want[id])
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("version %d: %d nodes, want %d", tt.version, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestIndexEmpty(t *testing.T) {
+	got, err := decodeIndex(encodeIndex(nil))
+	if err != nil || len(got) != 0 {
+		t.Errorf("decode of an empty index = %v, %v", got, err)
+	}
+}
+
+func TestIndexChecksum(t *testing.T) {
+	data := encodeIndex(testNodes())
+	// Flip a byte in each section and in the stored checksum
+	for _, at := range []int{indexHeaderSize, len(data) - 1, len(data) / 2, 40} {
+		bad := append([]byte(nil), data...)
+		bad[at] ^= 0xff
+		if _, err := decodeIndex(bad); !errors.Is(err, ErrIndexCorrupt) {
+			t.Errorf("byte %d flipped: err = %v, want ErrIndexCorrupt", at, err)
+		}
+	}
+}
+
+func TestIndexTruncated(t *testing.T) {
+	data := encodeIndex(testNodes())
+	for _, n := range []int{0, 7, indexHeaderSize - 1, indexHeaderSize, len(data) - 1} {
+		if _, err := decodeIndex(data[:n]); !errors.Is(err, ErrIndexCorrupt) {
+			t.Errorf("truncated to %d bytes: err = %v, want ErrIndexCorrupt", n, err)
+		}
+	}
+}
+
+func TestIndexSectionLengths(t *testing.T) {
+	data := encodeIndex(testNodes())
+	records := binary.LittleEndian.Uint64(data[16:])
+	vectors := binary.LittleEndian.Uint64(data[24:])
+	strs := binary.LittleEndian.Uint64(data[32:])
+	tests := []struct {
+		name                      string
+		records, vectors, strings uint64
+	}{
+		// The three lengths wrap around to the real body size
+		{"overflowing", records, vectors + 1<<63, strs + 1<<63},
+		{"huge records", 1<<64 - 1, 0, 0},
+		{"too long", records, vectors, uint64(len(data))},
+	}
+	for _, tt := range tests {
+		bad := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(bad[16:], tt.records)
+		binary.LittleEndian.PutUint64(bad[24:], tt.vectors)
+		binary.LittleEndian.PutUint64(bad[32:], tt.strings)
+		if _, err := decodeIndex(bad); !errors.Is(err, ErrIndexCorrupt) {
+			t.Errorf("%s: err = %v, want ErrIndexCorrupt", tt.name, err)
+		}
+	}
+}
+
+func TestIndexVersion(t *testing.T) {
+	data := encodeIndexVersion(testNodes(), indexVersion+1)
+	if _, err := decodeIndex(data); !errors.Is(err, ErrIndexVersion) {
+		t.Errorf("newer version: err = %v, want ErrIndexVersion", err)
+	}
+	binary.LittleEndian.PutUint32(data[8:], 0)
+	if _, err := decodeIndex(data); !errors.Is(err, ErrIndexCorrupt) {
+		t.Errorf("version 0: err = %v, want ErrIndexCorrupt", err)
+	}
+}
+
+func TestLoadIndexMigratesLegacy(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, LegacyIndexPath)
+	data := `{"nodes": {"a.go#1": {"id": "a.go#1", "path": "a.go", "content": "x", "hash": "h"}}}`
+	if err := os.WriteFile(legacy, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "index.bin")
+	g := lexicalGraph()
+	migrated, err := g.LoadIndex(path)
+	if err != nil || !migrated {
+		t.Fatalf("LoadIndex = %v, %v, want a migration", migrated, err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("legacy index kept after migration")
+	}
+	n := g.Nodes["a.go#1"]
+	if n == nil || n.Content != "x" || n.Hash != "" {
+		t.Errorf("migrated node = %+v, want its content without a hash", n)
+	}
+
+	g = lexicalGraph()
+	if migrated, err := g.LoadIndex(path); err != nil || migrated || len(g.Nodes) != 1 {
+		t.Errorf("reload = %v, %v with %d nodes", migrated, err, len(g.Nodes))
+	}
+}
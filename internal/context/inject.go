@@ -0,0 +1,147 @@
+package context
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// charsPerToken is the rough size of a token used for context budgets
+const charsPerToken = 4
+
+// Injection is the retrieved context for one user turn
+type Injection struct {
+	Block    string         // text to prepend to the user message; empty if nothing fit
+	Memories []SearchResult // the remembered facts included in Block
+	Results  []SearchResult // the snippets included in Block, in order
+	Tokens   int            // estimated size of Block
+}
+
+// Locations lists the injected memories as "memory <id>" and snippets as
+// "path:start-end (symbol)"
+func (inj *Injection) Locations() []string {
+	var out []string
+	for _, r := range inj.Memories {
+		out = append(out, "memory "+r.Node.Symbol)
+	}
+	for _, r := range inj.Results {
+		loc := r.Node.Location()
+		if r.Node.Symbol != "" {
+			loc += " (" + r.Node.Symbol + ")"
+		}
+		out = append(out, loc)
+	}
+	return out
+}
+
+// Inject searches the graph for the query and packs up to memories
+// relevant memories and the best k results into a context block of at most
+// budget tokens. Memories come first; results that do not fit are skipped
+// in favour of smaller ones further down, and the first result is cut to
+// fit rather than dropped. When embedding the query fails the search falls
+// back to lexical results.
+func (g *Graph) Inject(query string, k, memories, budget int) *Injection {
+	inj := &Injection{}
+	remaining := budget * charsPerToken
+	var memoryBlock string
+	if memories > 0 {
+		memoryBlock = g.injectMemories(inj, query, memories, remaining)
+		remaining -= len(memoryBlock)
+	}
+
+	results, err := g.Search(query, k)
+	if err != nil {
+		results = g.expand(g.SearchLexical(query, k))
+	}
+
+	const header = "<context>\nRelevant code from the project index. It may be out of date: read a file before editing it.\n"
+	const footer = "</context>"
+	remaining -= len(header) + len(footer)
+
+	var b strings.Builder
+	for _, r := range results {
+		snippet := formatSnippet(r.Node, r.Node.Content)
+		if len(snippet) > remaining {
+			if len(inj.Results) > 0 {
+				continue
+			}
+			snippet = truncateSnippet(r.Node, remaining)
+			if snippet == "" {
+				break
+			}
+		}
+		b.WriteString(snippet)
+		remaining -= len(snippet)
+		inj.Results = append(inj.Results, r)
+	}
+	var blocks []string
+	if memoryBlock != "" {
+		blocks = append(blocks, memoryBlock)
+	}
+	if len(inj.Results) > 0 {
+		blocks = append(blocks, header+b.String()+footer)
+	}
+	inj.Block = strings.Join(blocks, "\n\n")
+	inj.Tokens = len(inj.Block) / charsPerToken
+	return inj
+}
+
+// injectMemories renders the memories relevant to query that fit in size
+// bytes, recording them in inj
+func (g *Graph) injectMemories(inj *Injection, query string, k, size int) string {
+	const header = "<memory>\nFacts saved in earlier sessions that may apply here:\n"
+	const footer = "</memory>"
+	remaining := size - len(header) - len(footer)
+	var b strings.Builder
+	for _, r := range g.SearchMemories(query, k) {
+		line := "- " + strings.ReplaceAll(r.Node.Content, "\n", "\n  ") + "\n"
+		if len(line) > remaining {
+			continue
+		}
+		b.WriteString(line)
+		remaining -= len(line)
+		inj.Memories = append(inj.Memories, r)
+	}
+	if len(inj.Memories) == 0 {
+		return ""
+	}
+	return header + b.String() + footer
+}
+
+func formatSnippet(n *Node, content string) string {
+	title := n.Location()
+	if n.Symbol != "" {
+		title += " (" + strings.TrimSpace(n.Kind+" "+n.Symbol) + ")"
+	}
+	lang := strings.TrimPrefix(strings.ToLower(filepath.Ext(n.Path)), ".")
+	fence := codeFence(content)
+	return fmt.Sprintf("\n### %s\n%s%s\n%s\n%s\n", title, fence, lang, strings.TrimRight(content, "\n"), fence)
+}
+
+// codeFence returns a backtick fence longer than any backtick run in
+// content, so Markdown files and code that embed fences cannot close it
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for i := 0; i < len(content); i++ {
+		if content[i] == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// truncateSnippet keeps as many whole leading lines of the node as fit in
+// size bytes, noting the cut, or returns "" if not even one line fits
+func truncateSnippet(n *Node, size int) string {
+	lines := strings.Split(n.Content, "\n")
+	for keep := len(lines) - 1; keep > 0; keep-- {
+		cut := strings.Join(lines[:keep], "\n") + fmt.Sprintf("\n// ... %d more lines", len(lines)-keep)
+		if snippet := formatSnippet(n, cut); len(snippet) <= size {
+			return snippet
+		}
+	}
+	return ""
+}
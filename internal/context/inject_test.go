@@ -0,0 +1,35 @@
+package context
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodeFence(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		{"plain code", "```"},
+		{"inline `code` and ``more``", "```"},
+		{"```go\nx\n```", "````"},
+		{"`````", "``````"},
+		{"", "```"},
+	}
+	for _, tt := range tests {
+		if got := codeFence(tt.content); got != tt.want {
+			t.Errorf("codeFence(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSnippetNestedFence(t *testing.T) {
+	n := &Node{ID: "README.md#1", Path: "README.md", StartLine: 1, EndLine: 4}
+	got := formatSnippet(n, "Usage:\n```sh\ncraft -p hi\n```\n")
+	want := "\n### README.md:1-4\n````md\nUsage:\n```sh\ncraft -p hi\n```\n````\n"
+	if got != want {
+		t.Errorf("formatSnippet = %q, want %q", got, want)
+	}
+	if res := FormatResults([]SearchResult{{Node: &Node{Path: "a.md", Content: "```\nx\n```"}}}, 0); !strings.Contains(res, "````md\n```\nx\n```\n````") {
+		t.Errorf("FormatResults = %q, want the snippet in a longer fence", res)
+	}
+}
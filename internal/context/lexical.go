@@ -0,0 +1,208 @@
+package context
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// rrfK damps the contribution of lower ranks in reciprocal rank fusion
+	rrfK = 60
+)
+
+// lexicalIndex is an inverted index over node content, paths and symbols
+// scored with BM25. It is maintained incrementally under Graph.mu and
+// rebuilt when its gen falls behind the graph's.
+type lexicalIndex struct {
+	postings map[string]map[string]int // term -> node ID -> term frequency
+	docTerms map[string][]string       // node ID -> distinct terms, for removal
+	docLen   map[string]int
+	totalLen int
+	gen      uint64 // Graph.gen of the nodes indexed
+}
+
+func newLexicalIndex() *lexicalIndex {
+	return &lexicalIndex{
+		postings: make(map[string]map[string]int),
+		docTerms: make(map[string][]string),
+		docLen:   make(map[string]int),
+	}
+}
+
+func (ix *lexicalIndex) add(n *Node) {
+	ix.remove(n.ID)
+	terms := tokenize(n.Path + " " + n.Symbol + " " + n.Content)
+	var distinct []string
+	for _, t := range terms {
+		docs := ix.postings[t]
+		if docs == nil {
+			docs = make(map[string]int)
+			ix.postings[t] = docs
+		}
+		if docs[n.ID] == 0 {
+			distinct = append(distinct, t)
+		}
+		docs[n.ID]++
+	}
+	ix.docTerms[n.ID] = distinct
+	ix.docLen[n.ID] = len(terms)
+	ix.totalLen += len(terms)
+}
+
+func (ix *lexicalIndex) remove(id string) {
+	length, ok := ix.docLen[id]
+	if !ok {
+		return
+	}
+	for _, t := range ix.docTerms[id] {
+		docs := ix.postings[t]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	delete(ix.docTerms, id)
+	delete(ix.docLen, id)
+	ix.totalLen -= length
+}
+
+type scoredID struct {
+	id    string
+	score float64
+}
+
+// search returns node IDs ranked by BM25 for the query
+func (ix *lexicalIndex) search(query string) []scoredID {
+	n := float64(len(ix.docLen))
+	if n == 0 {
+		return nil
+	}
+	avgLen := float64(ix.totalLen) / n
+
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, t := range tokenize(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		docs := ix.postings[t]
+		if len(docs) == 0 {
+			continue
+		}
+		df := float64(len(docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, tf := range docs {
+			f := float64(tf)
+			norm := 1 - bm25B + bm25B*float64(ix.docLen[id])/avgLen
+			scores[id] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+
+	out := make([]scoredID, 0, len(scores))
+	for id, s := range scores {
+		out = append(out, scoredID{id, s})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].id < out[j].id
+	})
+	return out
+}
+
+// tokenize lowercases text into terms. Identifiers are kept whole and also
+// split on camelCase, snake_case and digits, so "getSystemPrompt" matches
+// queries for "system prompt" as well as "getsystemprompt".
+func tokenize(text string) []string {
+	var terms []string
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, w := range words {
+		parts := splitIdentifier(w)
+		if len(parts) > 1 {
+			if whole := strings.ToLower(strings.ReplaceAll(w, "_", "")); len(whole) > 1 {
+				terms = append(terms, whole)
+			}
+		}
+		for _, p := range parts {
+			p = strings.ToLower(p)
+			if len(p) > 1 && !stopWords[p] {
+				terms = append(terms, p)
+			}
+		}
+	}
+	return terms
+}
+
+// splitIdentifier breaks an identifier into words at underscores, case
+// changes and letter/digit boundaries ("HTTPServer_v2" -> HTTP, Server, v, 2)
+func splitIdentifier(s string) []string {
+	var parts []string
+	runes := []rune(s)
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			parts = append(parts, string(runes[start:end]))
+		}
+		start = end
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '_' {
+			flush(i)
+			start = i + 1
+			continue
+		}
+		if i == start {
+			continue
+		}
+		prev := runes[i-1]
+		switch {
+		case unicode.IsLower(prev) && unicode.IsUpper(r):
+			flush(i)
+		case unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+			flush(i)
+		case unicode.IsDigit(prev) != unicode.IsDigit(r) && prev != '_':
+			flush(i)
+		}
+	}
+	flush(len(runes))
+	return parts
+}
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "or": true, "of": true, "to": true, "in": true,
+	"is": true, "it": true, "a": true, "an": true, "for": true, "on": true,
+	"with": true, "as": true, "be": true, "this": true, "that": true, "are": true,
+	"how": true, "what": true, "where": true, "which": true,
+}
+
+// fuseRRF merges rankings with reciprocal rank fusion: each list contributes
+// 1/(rrfK+rank) for every ID it contains
+func fuseRRF(rankings ...[]string) []scoredID {
+	scores := make(map[string]float64)
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			scores[id] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	out := make([]scoredID, 0, len(scores))
+	for id, s := range scores {
+		out = append(out, scoredID{id, s})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].id < out[j].id
+	})
+	return out
+}
@@ -0,0 +1,239 @@
+package context
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"getSystemPrompt", []string{"getsystemprompt", "get", "system", "prompt"}},
+		{"HTTPServer_v2", []string{"httpserverv2", "http", "server"}},
+		{"the index of a file", []string{"index", "file"}},
+		{"read_file(path)", []string{"readfile", "read", "file", "path"}},
+		{"x", nil},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitIdentifier(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"getSystemPrompt", []string{"get", "System", "Prompt"}},
+		{"HTTPServer_v2", []string{"HTTP", "Server", "v", "2"}},
+		{"snake_case_name", []string{"snake", "case", "name"}},
+		{"_leading", []string{"leading"}},
+		{"plain", []string{"plain"}},
+	}
+	for _, tt := range tests {
+		if got := splitIdentifier(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("splitIdentifier(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func lexicalOf(docs map[string]string) *lexicalIndex {
+	ix := newLexicalIndex()
+	for id, content := range docs {
+		ix.add(&Node{ID: id, Content: content})
+	}
+	return ix
+}
+
+func ids(scored []scoredID) []string {
+	out := make([]string, len(scored))
+	for i, s := range scored {
+		out[i] = s.id
+	}
+	return out
+}
+
+func TestBM25Ranking(t *testing.T) {
+	ix := lexicalOf(map[string]string{
+		"common":   "watcher watcher config",
+		"rare":     "watcher debounce",
+		"repeated": "debounce debounce debounce config",
+		"other":    "config loader",
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Only documents containing a term score
+		{"loader", []string{"other"}},
+		// Higher term frequency ranks first
+		{"debounce", []string{"repeated", "rare"}},
+		// The rarer term outweighs the common one, and among documents
+		// matching config once the shorter ranks first
+		{"debounce config", []string{"repeated", "rare", "other", "common"}},
+		// Repeated query terms count once
+		{"loader loader", []string{"other"}},
+		{"missing", []string{}},
+	}
+	for _, tt := range tests {
+		if got := ids(ix.search(tt.query)); !slices.Equal(got, tt.want) {
+			t.Errorf("search(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestBM25LengthNormalization(t *testing.T) {
+	ix := lexicalOf(map[string]string{
+		"short": "token",
+		"long":  "token padding padding padding padding padding padding",
+	})
+	got := ix.search("token")
+	if len(got) != 2 || got[0].id != "short" || got[0].score <= got[1].score {
+		t.Errorf("search = %+v, want the shorter document first", got)
+	}
+}
+
+func TestLexicalRemove(t *testing.T) {
+	ix := lexicalOf(map[string]string{
+		"a": "alpha beta",
+		"b": "beta gamma",
+	})
+	ix.remove("a")
+	ix.remove("missing")
+	if got := ids(ix.search("alpha beta")); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("after remove, search = %q, want [b]", got)
+	}
+	if _, ok := ix.postings["alpha"]; ok {
+		t.Error("empty posting list for alpha kept")
+	}
+	if ix.totalLen != ix.docLen["b"] {
+		t.Errorf("totalLen = %d, want %d", ix.totalLen, ix.docLen["b"])
+	}
+
+	// Re-adding a document replaces it
+	ix.add(&Node{ID: "b", Content: "delta"})
+	if got := ids(ix.search("gamma")); len(got) != 0 {
+		t.Errorf("search(gamma) = %q after replacing b", got)
+	}
+}
+
+func TestFuseRRF(t *testing.T) {
+	got := fuseRRF(
+		[]string{"a", "b", "c"},
+		[]string{"c", "a", "d"},
+	)
+	want := []string{"a", "c", "b", "d"}
+	if !slices.Equal(ids(got), want) {
+		t.Fatalf("fuseRRF order = %q, want %q", ids(got), want)
+	}
+	score := func(ranks ...int) float64 {
+		s := 0.0
+		for _, r := range ranks {
+			s += 1 / float64(rrfK+r)
+		}
+		return s
+	}
+	wantScores := map[string]float64{
+		"a": score(1, 2),
+		"c": score(3, 1),
+		"b": score(2),
+		"d": score(3),
+	}
+	for _, s := range got {
+		if math.Abs(s.score-wantScores[s.id]) > 1e-12 {
+			t.Errorf("score of %s = %v, want %v", s.id, s.score, wantScores[s.id])
+		}
+	}
+}
+
+func TestFuseRRFTies(t *testing.T) {
+	// Equal ranks in two lists tie; the ID breaks the tie
+	got := ids(fuseRRF([]string{"y", "x"}, []string{"x", "y"}))
+	if !slices.Equal(got, []string{"x", "y"}) {
+		t.Errorf("fuseRRF ties = %q, want [x y]", got)
+	}
+	if got := fuseRRF(); len(got) != 0 {
+		t.Errorf("fuseRRF() = %v, want nothing", got)
+	}
+}
+
+func lexicalGraph() *Graph {
+	g := NewGraph()
+	g.SetEmbedder(nil)
+	return g
+}
+
+func TestSearchLexicalFollowsGraph(t *testing.T) {
+	g := lexicalGraph()
+	if err := g.AddFile("a.txt", "alpha"); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.SearchLexical("alpha", 0); len(got) != 1 {
+		t.Fatalf("search(alpha) = %d results, want 1", len(got))
+	}
+
+	// Replacing a file keeps the node count but must still be seen
+	if err := g.AddFile("a.txt", "beta"); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.SearchLexical("alpha", 0); len(got) != 0 {
+		t.Errorf("search(alpha) = %d results after the file changed, want 0", len(got))
+	}
+	if got := g.SearchLexical("beta", 0); len(got) != 1 {
+		t.Errorf("search(beta) = %d results, want 1", len(got))
+	}
+
+	// Nodes replaced wholesale, as Load does, with the same count
+	g.mu.Lock()
+	for _, n := range g.Nodes {
+		n.Content = "gamma"
+	}
+	g.gen++
+	g.mu.Unlock()
+	if got := g.SearchLexical("gamma", 0); len(got) != 1 {
+		t.Errorf("search(gamma) = %d results after a generation bump, want 1", len(got))
+	}
+
+	g.RemoveFile("a.txt")
+	if got := g.SearchLexical("gamma", 0); len(got) != 0 {
+		t.Errorf("search(gamma) = %d results after removal, want 0", len(got))
+	}
+}
+
+func TestSearchLexicalConcurrent(t *testing.T) {
+	g := lexicalGraph()
+	for i := range 20 {
+		if err := g.AddFile(fmt.Sprintf("f%d.txt", i), "shared term"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				if i == 0 {
+					g.AddFile(fmt.Sprintf("new%d.txt", j), "shared term")
+					continue
+				}
+				if len(g.SearchLexical("shared", 0)) < 20 {
+					t.Error("search lost nodes")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(g.SearchLexical("shared", 0)); got != 70 {
+		t.Errorf("search(shared) = %d results, want 70", got)
+	}
+}
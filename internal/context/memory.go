@@ -0,0 +1,275 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"craft-cli/internal/config"
+)
+
+// Memory scopes: project memories live in the project, user memories follow
+// the user across projects
+const (
+	MemoryProject = "project"
+	MemoryUser    = "user"
+)
+
+// memoryMinSimilarity is the embedding similarity above which a memory is
+// relevant to a prompt that shares no words with it
+const memoryMinSimilarity = 0.5
+
+// Memory is a fact kept across sessions, one file per memory
+type Memory struct {
+	ID      string // file name without extension, e.g. 20261019-153000-prefer-tabs
+	Scope   string
+	Text    string
+	Updated time.Time
+	Path    string
+}
+
+// MemoryDir returns where memories of a scope are stored: .craft/memory
+// in the project root, or memory next to the user config file
+func MemoryDir(scope string) string {
+	if scope == MemoryUser {
+		path := config.UserPath()
+		if path == "" {
+			return ""
+		}
+		return filepath.Join(filepath.Dir(path), "memory")
+	}
+	return filepath.Join(config.ProjectRoot(), ".craft", "memory")
+}
+
+// LoadMemories reads the project memories, then the user ones, each oldest
+// first. A missing directory holds no memories.
+func LoadMemories() ([]Memory, error) {
+	var all []Memory
+	for _, scope := range []string{MemoryProject, MemoryUser} {
+		dir := MemoryDir(scope)
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return all, err
+		}
+		var memories []Memory
+		for _, e := range entries {
+			if e.IsDir() || filepath.Ext(e.Name()) != ".md" {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return all, err
+			}
+			info, err := e.Info()
+			if err != nil {
+				return all, err
+			}
+			text := strings.TrimSpace(string(data))
+			if text == "" {
+				continue
+			}
+			memories = append(memories, Memory{
+				ID:      strings.TrimSuffix(e.Name(), ".md"),
+				Scope:   scope,
+				Text:    text,
+				Updated: info.ModTime(),
+				Path:    path,
+			})
+		}
+		// IDs start with the creation time
+		sort.Slice(memories, func(i, j int) bool { return memories[i].ID < memories[j].ID })
+		all = append(all, memories...)
+	}
+	return all, nil
+}
+
+var memorySlugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Remember saves text as a new memory of scope
+func Remember(scope, text string) (Memory, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Memory{}, fmt.Errorf("nothing to remember")
+	}
+	if scope != MemoryProject && scope != MemoryUser {
+		return Memory{}, fmt.Errorf("unknown memory scope %q (want %s or %s)", scope, MemoryProject, MemoryUser)
+	}
+	dir := MemoryDir(scope)
+	if dir == "" {
+		return Memory{}, fmt.Errorf("no user config directory for %s memories", scope)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Memory{}, err
+	}
+
+	words := strings.Fields(memorySlugRe.ReplaceAllString(strings.ToLower(text), " "))
+	if len(words) > 5 {
+		words = words[:5]
+	}
+	now := time.Now()
+	id := now.Format("20060102-150405")
+	if len(words) > 0 {
+		id += "-" + strings.Join(words, "-")
+	}
+	path := filepath.Join(dir, id+".md")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = filepath.Join(dir, id+"-"+strconv.Itoa(i)+".md")
+	}
+	m := Memory{ID: strings.TrimSuffix(filepath.Base(path), ".md"), Scope: scope, Text: text, Updated: now, Path: path}
+	return m, os.WriteFile(path, []byte(text+"\n"), 0644)
+}
+
+// FindMemory picks a memory by ID, ID prefix or 1-based position in
+// memories (as listed by /memory)
+func FindMemory(memories []Memory, ref string) (Memory, error) {
+	ref = strings.TrimSpace(ref)
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(memories) {
+			return Memory{}, fmt.Errorf("no memory #%d (there are %d)", n, len(memories))
+		}
+		return memories[n-1], nil
+	}
+	var found []Memory
+	for _, m := range memories {
+		if m.ID == ref {
+			return m, nil
+		}
+		if ref != "" && strings.HasPrefix(m.ID, ref) {
+			found = append(found, m)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Memory{}, fmt.Errorf("no memory %q", ref)
+	case 1:
+		return found[0], nil
+	}
+	return Memory{}, fmt.Errorf("%q matches %d memories", ref, len(found))
+}
+
+// Edit replaces the text of a memory
+func (m Memory) Edit(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("a memory cannot be empty; forget it instead")
+	}
+	return os.WriteFile(m.Path, []byte(text+"\n"), 0644)
+}
+
+// Forget deletes a memory
+func (m Memory) Forget() error {
+	return os.Remove(m.Path)
+}
+
+// FormatMemories lists memories numbered for /memory
+func FormatMemories(memories []Memory) string {
+	var b strings.Builder
+	for i, m := range memories {
+		fmt.Fprintf(&b, "%d. [%s %s] %s (%s)\n", i+1, m.Scope, m.Updated.Format("2006-01-02"), strings.ReplaceAll(m.Text, "\n", " "), m.ID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// SetMemories replaces the memory nodes of the graph, embedding them when
+// the graph has an embedder. Memory nodes are searched only through
+// SearchMemories and are never saved to the index; their files are the
+// store.
+func (g *Graph) SetMemories(memories []Memory) error {
+	var nodes []*Node
+	for _, m := range memories {
+		nodes = append(nodes, &Node{
+			ID:      "memory:" + m.Scope + "/" + m.ID,
+			Path:    m.Path,
+			Content: m.Text,
+			Symbol:  m.ID,
+			Kind:    KindMemory,
+			Hash:    hashContent(m.Text),
+			ModTime: m.Updated,
+		})
+	}
+	var embedErr error
+	if embedder := g.Embedder(); embedder != nil && len(nodes) > 0 {
+		texts := make([]string, len(nodes))
+		for i, n := range nodes {
+			texts[i] = n.Content
+		}
+		if embeddings, err := embedder.Embed(texts); err != nil {
+			// Still searchable lexically
+			embedErr = fmt.Errorf("failed to embed memories: %w", err)
+		} else {
+			for i, n := range nodes {
+				n.Embedding, n.Model = embeddings[i], embedder.Model()
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for id, n := range g.Nodes {
+		if n.Kind == KindMemory {
+			delete(g.Nodes, id)
+		}
+	}
+	for _, n := range nodes {
+		g.Nodes[n.ID] = n
+	}
+	g.gen++
+	g.lexical = nil
+	return embedErr
+}
+
+// SearchMemories returns up to k memories relevant to query: those sharing
+// words with it and, with an embedder, those similar enough in meaning,
+// fused by reciprocal rank
+func (g *Graph) SearchMemories(query string, k int) []SearchResult {
+	lexical := g.searchLexical(query, true)
+	var vector []SearchResult
+	if embedder := g.Embedder(); embedder != nil {
+		if vectors, err := embedder.Embed([]string{query}); err == nil {
+			for _, n := range g.Snapshot() {
+				if n.Kind != KindMemory || n.Model != embedder.Model() || len(n.Embedding) == 0 {
+					continue
+				}
+				if sim := cosineSimilarity(vectors[0], n.Embedding); sim >= memoryMinSimilarity {
+					vector = append(vector, SearchResult{Node: n, Similarity: sim})
+				}
+			}
+			sort.Slice(vector, func(i, j int) bool { return vector[i].Similarity > vector[j].Similarity })
+		}
+	}
+
+	byID := make(map[string]SearchResult)
+	var vectorIDs, lexicalIDs []string
+	for _, r := range vector {
+		byID[r.Node.ID] = r
+		vectorIDs = append(vectorIDs, r.Node.ID)
+	}
+	for _, r := range lexical {
+		if _, ok := byID[r.Node.ID]; !ok {
+			byID[r.Node.ID] = r
+		}
+		lexicalIDs = append(lexicalIDs, r.Node.ID)
+	}
+	var results []SearchResult
+	for _, f := range fuseRRF(vectorIDs, lexicalIDs) {
+		r := byID[f.id]
+		r.Score = f.score
+		results = append(results, r)
+	}
+	return truncate(results, k)
+}
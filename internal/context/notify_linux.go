@@ -0,0 +1,218 @@
+//go:build linux
+
+package context
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// inotifyNotifier watches every non-ignored directory under a root with inotify
+type inotifyNotifier struct {
+	fd     int
+	epfd   int
+	root   string
+	events chan string
+
+	mu   sync.Mutex
+	dirs map[int]string
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newNotifier(root string) (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init failed: %w", err)
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("epoll create failed: %w", err)
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		syscall.Close(fd)
+		syscall.Close(epfd)
+		return nil, fmt.Errorf("epoll ctl failed: %w", err)
+	}
+
+	n := &inotifyNotifier{
+		fd:     fd,
+		epfd:   epfd,
+		root:   filepath.Clean(root),
+		events: make(chan string, 256),
+		dirs:   make(map[int]string),
+		done:   make(chan struct{}),
+	}
+	if _, err := n.addTree(root); err != nil {
+		n.Close()
+		return nil, err
+	}
+	n.wg.Add(1)
+	go n.read()
+	return n, nil
+}
+
+func (n *inotifyNotifier) Events() <-chan string {
+	return n.events
+}
+
+func (n *inotifyNotifier) Close() error {
+	select {
+	case <-n.done:
+		return nil
+	default:
+	}
+	close(n.done)
+	n.wg.Wait()
+	syscall.Close(n.epfd)
+	return syscall.Close(n.fd)
+}
+
+// addTree adds a watch for dir and every directory below it, and returns
+// the files found in them. Paths are relative to the project root.
+func (n *inotifyNotifier) addTree(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	var files []string
+	err := walkProject(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			if !ignored(path, false) {
+				files = append(files, path)
+			}
+			return nil
+		}
+		if path != dir && ignored(path, true) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(n.fd, projectAbs(path), inotifyMask)
+		if err != nil {
+			if path == dir {
+				return fmt.Errorf("cannot watch %s: %w", path, err)
+			}
+			return nil
+		}
+		n.mu.Lock()
+		n.dirs[wd] = path
+		n.mu.Unlock()
+		return nil
+	})
+	return files, err
+}
+
+// removeTree stops watching dir and every directory below it
+func (n *inotifyNotifier) removeTree(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for wd, path := range n.dirs {
+		if within(dir, path) {
+			syscall.InotifyRmWatch(n.fd, uint32(wd))
+			delete(n.dirs, wd)
+		}
+	}
+}
+
+func (n *inotifyNotifier) read() {
+	defer n.wg.Done()
+	defer close(n.events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	epollEvents := make([]syscall.EpollEvent, 1)
+	for {
+		select {
+		case <-n.done:
+			return
+		default:
+		}
+
+		// Wake up periodically so Close is noticed
+		ready, err := syscall.EpollWait(n.epfd, epollEvents, 200)
+		if err != nil && err != syscall.EINTR {
+			return
+		}
+		if ready <= 0 {
+			continue
+		}
+
+		count, err := syscall.Read(n.fd, buf)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil || count < syscall.SizeofInotifyEvent {
+			return
+		}
+
+		overflow := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				overflow = true
+				continue
+			}
+
+			n.mu.Lock()
+			dir, ok := n.dirs[int(raw.Wd)]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(n.dirs, int(raw.Wd))
+			}
+			n.mu.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			if ignored(path, raw.Mask&syscall.IN_ISDIR != 0) {
+				continue
+			}
+			paths := []string{path}
+			if raw.Mask&syscall.IN_ISDIR != 0 {
+				switch {
+				case raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+					// New directories need their own watches, and the files
+					// that came with them are indexed
+					paths, _ = n.addTree(path)
+				case raw.Mask&syscall.IN_MOVED_FROM != 0:
+					// A directory moved away, or renamed, takes its files out
+					// of the tree; the watcher drops them all
+					n.removeTree(path)
+				default:
+					continue
+				}
+			}
+
+			for _, p := range paths {
+				select {
+				case n.events <- p:
+				case <-n.done:
+					return
+				}
+			}
+		}
+
+		if overflow {
+			// The kernel queue filled up and dropped events: watch the
+			// directories created meanwhile, and have the whole root
+			// rescanned
+			n.addTree(n.root)
+			select {
+			case n.events <- n.root:
+			case <-n.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInotifyOverflow(t *testing.T) {
+	data, err := os.ReadFile("/proc/sys/fs/inotify/max_queued_events")
+	if err != nil {
+		t.Skip("inotify queue size unknown")
+	}
+	limit, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	if limit <= 0 || limit > 1<<15 {
+		t.Skipf("inotify queue of %d events is too long to fill", limit)
+	}
+	root := inSubdir(t, ".")
+	n, err := newNotifier(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	// Nothing reads the events, so the notifier blocks once its channel is
+	// full and the kernel queue fills up behind it
+	for i := 0; i <= limit; i++ {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("f%d", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case path, ok := <-n.Events():
+			if !ok {
+				t.Fatal("notifier stopped")
+			}
+			if path == "." {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no rescan of the root after the event queue overflowed")
+		}
+	}
+}
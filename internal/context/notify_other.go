@@ -0,0 +1,107 @@
+//go:build !linux
+
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often the polling notifier rescans the tree
+const pollInterval = time.Second
+
+// pollNotifier detects changes by comparing mtimes on platforms without inotify
+type pollNotifier struct {
+	root   string
+	events chan string
+	seen   map[string]time.Time
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newNotifier(root string) (notifier, error) {
+	if _, err := os.Stat(projectAbs(root)); err != nil {
+		return nil, err
+	}
+	n := &pollNotifier{
+		root:   filepath.Clean(root),
+		events: make(chan string, 256),
+		done:   make(chan struct{}),
+	}
+	n.seen = n.scan()
+	n.wg.Add(1)
+	go n.poll()
+	return n, nil
+}
+
+func (n *pollNotifier) Events() <-chan string {
+	return n.events
+}
+
+func (n *pollNotifier) Close() error {
+	select {
+	case <-n.done:
+		return nil
+	default:
+	}
+	close(n.done)
+	n.wg.Wait()
+	return nil
+}
+
+func (n *pollNotifier) scan() map[string]time.Time {
+	out := make(map[string]time.Time)
+	walkProject(n.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != n.root && ignored(path, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		out[path] = info.ModTime()
+		return nil
+	})
+	return out
+}
+
+func (n *pollNotifier) poll() {
+	defer n.wg.Done()
+	defer close(n.events)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		current := n.scan()
+		var changed []string
+		for path, mtime := range current {
+			if old, ok := n.seen[path]; !ok || !old.Equal(mtime) {
+				changed = append(changed, path)
+			}
+		}
+		for path := range n.seen {
+			if _, ok := current[path]; !ok {
+				changed = append(changed, path)
+			}
+		}
+		n.seen = current
+
+		for _, path := range changed {
+			select {
+			case n.events <- path:
+			case <-n.done:
+				return
+			}
+		}
+	}
+}
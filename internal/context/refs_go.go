@@ -0,0 +1,306 @@
+package context
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// goRefs extracts the imports of a Go file and, by type-checking its
+// package, the project functions, methods, types and package-level values
+// each chunk uses. The result has one entry per chunk.
+func (g *Graph) goRefs(path, content string, chunks []Chunk) [][]Ref {
+	refs := make([][]Ref, len(chunks))
+	chunkAt := func(line int) int {
+		for i, c := range chunks {
+			if line >= c.StartLine && line <= c.EndLine {
+				return i
+			}
+		}
+		return -1
+	}
+
+	dir := filepath.Dir(path)
+	mod := g.goPackages.module(dir)
+	pkg := g.goPackages.load(dir)
+	var file *ast.File
+	var fset *token.FileSet
+	if pkg != nil {
+		// Positions only line up if the checked file is the one being indexed
+		if f, ok := pkg.files[filepath.Base(path)]; ok && pkg.hashes[filepath.Base(path)] == hashContent(content) {
+			file, fset = f, pkg.fset
+		}
+	}
+	if file == nil {
+		fset = token.NewFileSet()
+		f, err := parser.ParseFile(fset, path, content, parser.ImportsOnly)
+		if err != nil {
+			return refs
+		}
+		file = f
+	}
+
+	for _, imp := range file.Imports {
+		importPath := strings.Trim(imp.Path.Value, `"`)
+		target, ok := mod.dir(importPath)
+		if !ok {
+			continue
+		}
+		if i := chunkAt(fset.Position(imp.Pos()).Line); i >= 0 {
+			refs[i] = appendRef(refs[i], Ref{Kind: EdgeImports, Target: "pkg:" + target})
+		}
+	}
+	if pkg == nil || file == nil || pkg.files[filepath.Base(path)] != file {
+		return refs
+	}
+
+	for ident, obj := range pkg.info.Uses {
+		if fset.File(ident.Pos()) != fset.File(file.Pos()) || obj.Pkg() == nil {
+			continue
+		}
+		targetDir := dir
+		if obj.Pkg() != pkg.types {
+			var ok bool
+			if targetDir, ok = mod.dir(obj.Pkg().Path()); !ok {
+				continue
+			}
+		}
+		kind, name := goObjectRef(obj)
+		if name == "" {
+			continue
+		}
+		if i := chunkAt(fset.Position(ident.Pos()).Line); i >= 0 {
+			refs[i] = appendRef(refs[i], Ref{Kind: kind, Target: "go:" + targetDir + ":" + name})
+		}
+	}
+	for i := range refs {
+		sort.Slice(refs[i], func(a, b int) bool {
+			if refs[i][a].Kind != refs[i][b].Kind {
+				return refs[i][a].Kind < refs[i][b].Kind
+			}
+			return refs[i][a].Target < refs[i][b].Target
+		})
+	}
+	return refs
+}
+
+// goObjectRef names a package-level object the way ChunkFile names its
+// declaration ("Name" or "Recv.Name"); other objects get an empty name
+func goObjectRef(obj types.Object) (kind, name string) {
+	switch o := obj.(type) {
+	case *types.Func:
+		sig, ok := o.Type().(*types.Signature)
+		if !ok {
+			return "", ""
+		}
+		if recv := sig.Recv(); recv != nil {
+			t := recv.Type()
+			if p, ok := t.(*types.Pointer); ok {
+				t = p.Elem()
+			}
+			named, ok := t.(*types.Named)
+			if !ok {
+				// Interface methods have no declaration of their own
+				return "", ""
+			}
+			return EdgeCalls, named.Obj().Name() + "." + o.Name()
+		}
+		return EdgeCalls, o.Name()
+	case *types.TypeName, *types.Var, *types.Const:
+		if o.Parent() == nil || o.Parent() != o.Pkg().Scope() {
+			return "", ""
+		}
+		return EdgeReferences, o.Name()
+	}
+	return "", ""
+}
+
+// goModule maps import paths to directories for one go.mod
+type goModule struct {
+	root string // directory holding go.mod, in the same form as node paths
+	path string // module path
+}
+
+// dir returns the directory of an import path inside the module
+func (m *goModule) dir(importPath string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	if importPath == m.path {
+		return m.root, true
+	}
+	if rest, ok := strings.CutPrefix(importPath, m.path+"/"); ok {
+		return filepath.Join(m.root, filepath.FromSlash(rest)), true
+	}
+	return "", false
+}
+
+// goPackage is one type-checked directory
+type goPackage struct {
+	stamp  string
+	fset   *token.FileSet
+	files  map[string]*ast.File // by base name
+	hashes map[string]string
+	types  *types.Package
+	info   *types.Info
+}
+
+// goPackageCache type-checks project packages on demand and keeps them
+// until their files change. Packages outside the module (including the
+// standard library) are replaced by empty stand-ins, so checking is fast,
+// offline and tolerant of errors; only project symbols are resolved.
+type goPackageCache struct {
+	mu       sync.Mutex
+	packages map[string]*goPackage
+	modules  map[string]*goModule
+	loading  map[string]bool
+}
+
+func (c *goPackageCache) module(dir string) *goModule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.moduleLocked(dir)
+}
+
+func (c *goPackageCache) moduleLocked(dir string) *goModule {
+	if c.modules == nil {
+		c.modules = make(map[string]*goModule)
+	}
+	if m, ok := c.modules[dir]; ok {
+		return m
+	}
+	var m *goModule
+	if data, err := os.ReadFile(filepath.Join(projectAbs(dir), "go.mod")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+				m = &goModule{root: dir, path: strings.Trim(strings.TrimSpace(rest), `"`)}
+				break
+			}
+		}
+	} else if parent := filepath.Dir(dir); parent != dir {
+		m = c.moduleLocked(parent)
+	}
+	c.modules[dir] = m
+	return m
+}
+
+// load returns the type-checked package in dir, or nil if it has no Go files
+func (c *goPackageCache) load(dir string) *goPackage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadLocked(dir)
+}
+
+func (c *goPackageCache) loadLocked(dir string) *goPackage {
+	if c.packages == nil {
+		c.packages = make(map[string]*goPackage)
+		c.loading = make(map[string]bool)
+	}
+	names, stamp := goSources(dir)
+	if len(names) == 0 {
+		return nil
+	}
+	if pkg, ok := c.packages[dir]; ok && pkg.stamp == stamp {
+		return pkg
+	}
+	if c.loading[dir] {
+		// Import cycle; the checker reports it and carries on
+		return nil
+	}
+	c.loading[dir] = true
+	defer delete(c.loading, dir)
+
+	pkg := &goPackage{
+		stamp:  stamp,
+		fset:   token.NewFileSet(),
+		files:  make(map[string]*ast.File),
+		hashes: make(map[string]string),
+		info:   &types.Info{Uses: make(map[*ast.Ident]types.Object)},
+	}
+	var files []*ast.File
+	for _, name := range names {
+		src, err := os.ReadFile(filepath.Join(projectAbs(dir), name))
+		if err != nil {
+			continue
+		}
+		f, err := parser.ParseFile(pkg.fset, filepath.Join(dir, name), src, parser.SkipObjectResolution)
+		if f == nil {
+			continue
+		}
+		pkg.files[name] = f
+		pkg.hashes[name] = hashContent(string(src))
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	mod := c.moduleLocked(dir)
+	importPath := dir
+	if mod != nil {
+		if rel, err := filepath.Rel(mod.root, dir); err == nil {
+			importPath = strings.TrimSuffix(mod.path+"/"+filepath.ToSlash(rel), "/.")
+		}
+	}
+	conf := types.Config{
+		Importer:    goImporter{cache: c, mod: mod},
+		Error:       func(error) {},
+		FakeImportC: true,
+	}
+	pkg.types, _ = conf.Check(importPath, pkg.fset, files, pkg.info)
+	c.packages[dir] = pkg
+	return pkg
+}
+
+// goSources lists the non-test Go files of dir that build on this platform,
+// with a stamp that changes whenever one of them does
+func goSources(dir string) ([]string, string) {
+	entries, err := os.ReadDir(projectAbs(dir))
+	if err != nil {
+		return nil, ""
+	}
+	var names []string
+	var stamp strings.Builder
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(projectAbs(dir), name); err != nil || !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+		fmt.Fprintf(&stamp, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return names, stamp.String()
+}
+
+// goImporter resolves project imports from source and stubs out the rest
+type goImporter struct {
+	cache *goPackageCache
+	mod   *goModule
+}
+
+func (i goImporter) Import(path string) (*types.Package, error) {
+	if dir, ok := i.mod.dir(path); ok {
+		if pkg := i.cache.loadLocked(dir); pkg != nil && pkg.types != nil {
+			return pkg.types, nil
+		}
+	}
+	name := path[strings.LastIndex(path, "/")+1:]
+	stub := types.NewPackage(path, name)
+	stub.MarkComplete()
+	return stub, nil
+}
@@ -0,0 +1,171 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	jsImportFrom = regexp.MustCompile(`(?:import|export)\s+(?:type\s+)?([\w$*{}\s,]*?)\s*from\s*['"]([^'"]+)['"]`)
+	jsImportBare = regexp.MustCompile(`import\s*\(?\s*['"]([^'"]+)['"]`)
+	jsRequire    = regexp.MustCompile(`require\s*\(\s*['"]([^'"]+)['"]\s*\)`)
+	pyImport     = regexp.MustCompile(`^\s*import\s+([\w.]+(?:\s+as\s+\w+)?(?:\s*,\s*[\w.]+(?:\s+as\s+\w+)?)*)`)
+	pyFromImport = regexp.MustCompile(`^\s*from\s+(\.*[\w.]*)\s+import\s+\(?([\w\s,*]+)\)?`)
+
+	jsExtensions = []string{".ts", ".tsx", ".js", ".jsx", ".mjs", ".cjs"}
+)
+
+// scriptRefs extracts the relative imports of JS/TS files and the project
+// imports of Python files, one entry per chunk. Package imports
+// ("react", "os") are not part of the project and are skipped.
+func scriptRefs(path, content string, chunks []Chunk) [][]Ref {
+	var lineRefs func(line string) []Ref
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		lineRefs = func(line string) []Ref { return jsLineRefs(path, line) }
+	case ".py":
+		lineRefs = func(line string) []Ref { return pyLineRefs(path, line) }
+	default:
+		return nil
+	}
+
+	refs := make([][]Ref, len(chunks))
+	for i, chunk := range chunks {
+		for _, line := range strings.Split(chunk.Content, "\n") {
+			for _, r := range lineRefs(line) {
+				refs[i] = appendRef(refs[i], r)
+			}
+		}
+	}
+	return refs
+}
+
+func jsLineRefs(path, line string) []Ref {
+	var refs []Ref
+	add := func(spec, names string) {
+		target, ok := resolveJSImport(path, spec)
+		if !ok {
+			return
+		}
+		refs = append(refs, Ref{Kind: EdgeImports, Target: "file:" + target})
+		// import { a, b as c } from './x' also references a and b
+		if open := strings.Index(names, "{"); open >= 0 {
+			inner := strings.TrimSuffix(strings.TrimSpace(names[open+1:]), "}")
+			for _, name := range strings.Split(inner, ",") {
+				name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "type "))
+				name, _, _ = strings.Cut(name, " ")
+				if name != "" {
+					refs = append(refs, Ref{Kind: EdgeReferences, Target: "def:" + target + ":" + name})
+				}
+			}
+		}
+	}
+	for _, m := range jsImportFrom.FindAllStringSubmatch(line, -1) {
+		add(m[2], m[1])
+	}
+	if !jsImportFrom.MatchString(line) {
+		for _, m := range jsImportBare.FindAllStringSubmatch(line, -1) {
+			add(m[1], "")
+		}
+	}
+	for _, m := range jsRequire.FindAllStringSubmatch(line, -1) {
+		add(m[1], "")
+	}
+	return refs
+}
+
+// resolveJSImport finds the file a relative specifier points to, trying
+// the usual extensions and index files
+func resolveJSImport(from, spec string) (string, bool) {
+	if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") {
+		return "", false
+	}
+	base := filepath.Join(filepath.Dir(from), filepath.FromSlash(spec))
+	candidates := []string{base}
+	for _, ext := range jsExtensions {
+		candidates = append(candidates, base+ext)
+	}
+	for _, ext := range jsExtensions {
+		candidates = append(candidates, filepath.Join(base, "index"+ext))
+	}
+	return firstFile(candidates)
+}
+
+func pyLineRefs(path, line string) []Ref {
+	var refs []Ref
+	if m := pyFromImport.FindStringSubmatch(line); m != nil {
+		target, ok := resolvePyModule(path, m[1])
+		if ok {
+			refs = append(refs, Ref{Kind: EdgeImports, Target: "file:" + target})
+		}
+		prefix := m[1]
+		if !strings.HasSuffix(prefix, ".") {
+			prefix += "."
+		}
+		for _, name := range strings.Split(m[2], ",") {
+			name, _, _ = strings.Cut(strings.TrimSpace(name), " ")
+			if name == "" || name == "*" {
+				continue
+			}
+			// "from pkg import mod" may name a submodule rather than a symbol
+			if sub, ok := resolvePyModule(path, prefix+name); ok {
+				refs = append(refs, Ref{Kind: EdgeImports, Target: "file:" + sub})
+			} else if target != "" {
+				refs = append(refs, Ref{Kind: EdgeReferences, Target: "def:" + target + ":" + name})
+			}
+		}
+		return refs
+	}
+	if m := pyImport.FindStringSubmatch(line); m != nil {
+		for _, module := range strings.Split(m[1], ",") {
+			module, _, _ = strings.Cut(strings.TrimSpace(module), " ")
+			if target, ok := resolvePyModule(path, module); ok {
+				refs = append(refs, Ref{Kind: EdgeImports, Target: "file:" + target})
+			}
+		}
+	}
+	return refs
+}
+
+// resolvePyModule maps a module name to a project file. Relative modules
+// (".x", "..x") start from the importing file's package; absolute ones are
+// looked up from each enclosing directory, innermost first.
+func resolvePyModule(from, module string) (string, bool) {
+	dots := len(module) - len(strings.TrimLeft(module, "."))
+	rel := filepath.FromSlash(strings.ReplaceAll(module[dots:], ".", "/"))
+
+	var roots []string
+	if dots > 0 {
+		dir := filepath.Dir(from)
+		for i := 1; i < dots; i++ {
+			dir = filepath.Dir(dir)
+		}
+		roots = []string{dir}
+	} else {
+		for dir := filepath.Dir(from); ; dir = filepath.Dir(dir) {
+			roots = append(roots, dir)
+			if parent := filepath.Dir(dir); parent == dir {
+				break
+			}
+		}
+	}
+
+	for _, root := range roots {
+		base := filepath.Join(root, rel)
+		if target, ok := firstFile([]string{base + ".py", filepath.Join(base, "__init__.py")}); ok {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+func firstFile(candidates []string) (string, bool) {
+	for _, c := range candidates {
+		if info, err := os.Stat(projectAbs(c)); err == nil && info.Mode().IsRegular() {
+			return filepath.Clean(c), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,248 @@
+package context
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// repoMapHeader identifies a cached repo map and the index it was built from
+	repoMapHeader = "craft-repomap"
+	// maxMapSymbols caps the symbols listed per file
+	maxMapSymbols = 8
+)
+
+// repoMapPath is the repo map cache stored next to the index file
+func repoMapPath(indexPath string) string {
+	return indexPath + ".map"
+}
+
+// repoMapFile is a file of the repo map with its rank and exported symbols
+type repoMapFile struct {
+	path    string
+	rank    float64
+	symbols []string
+}
+
+// RepoMap outlines the project for the system prompt: its directories and
+// files, most depended-on first (PageRank over the dependency edges between
+// files), each with its exported top-level symbols, cut to about budget
+// tokens. It returns "" for an empty graph.
+func (g *Graph) RepoMap(budget int) string {
+	nodes := g.Snapshot()
+	if len(nodes) == 0 {
+		return ""
+	}
+	g.mu.Lock()
+	var edges []Edge
+	for _, out := range g.linksLocked().out {
+		edges = append(edges, out...)
+	}
+	g.mu.Unlock()
+
+	pathOf := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		if n.Kind != KindMemory {
+			pathOf[n.ID] = n.Path
+		}
+	}
+	// Uses of a node from other files rank its symbols within the file
+	inDegree := make(map[string]int)
+	links := make(map[string]map[string]int)
+	for _, e := range edges {
+		from, to := pathOf[e.From], pathOf[e.To]
+		if from == "" || to == "" || from == to {
+			continue
+		}
+		inDegree[e.To]++
+		if links[from] == nil {
+			links[from] = make(map[string]int)
+		}
+		links[from][to]++
+	}
+
+	files := make(map[string]*repoMapFile)
+	weights := make(map[string]map[string]int) // path -> symbol -> uses
+	for _, n := range nodes {
+		if n.Kind == KindMemory {
+			continue
+		}
+		f, ok := files[n.Path]
+		if !ok {
+			f = &repoMapFile{path: n.Path}
+			files[n.Path] = f
+			weights[n.Path] = make(map[string]int)
+		}
+		for _, d := range n.Defs {
+			if !exportedSymbol(n.Path, d) {
+				continue
+			}
+			if _, seen := weights[n.Path][d.Name]; !seen {
+				f.symbols = append(f.symbols, d.Name)
+			}
+			weights[n.Path][d.Name] += inDegree[n.ID]
+		}
+	}
+	ranks := pageRank(files, links)
+
+	ranked := make([]*repoMapFile, 0, len(files))
+	for path, f := range files {
+		f.rank = ranks[path]
+		w := weights[path]
+		// Stable keeps declaration order among equally used symbols
+		sort.SliceStable(f.symbols, func(i, j int) bool { return w[f.symbols[i]] > w[f.symbols[j]] })
+		ranked = append(ranked, f)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank > ranked[j].rank
+		}
+		return ranked[i].path < ranked[j].path
+	})
+	return renderRepoMap(ranked, budget)
+}
+
+// pageRank ranks files by the files that depend on them
+func pageRank(files map[string]*repoMapFile, links map[string]map[string]int) map[string]float64 {
+	const damping, iterations = 0.85, 30
+	n := float64(len(files))
+	rank := make(map[string]float64, len(files))
+	for path := range files {
+		rank[path] = 1 / n
+	}
+	outWeight := make(map[string]int)
+	for from, tos := range links {
+		for _, w := range tos {
+			outWeight[from] += w
+		}
+	}
+	for i := 0; i < iterations; i++ {
+		next := make(map[string]float64, len(files))
+		dangling := 0.0
+		for path, r := range rank {
+			if outWeight[path] == 0 {
+				dangling += r
+			}
+		}
+		for path := range files {
+			next[path] = (1-damping)/n + damping*dangling/n
+		}
+		for from, tos := range links {
+			for to, w := range tos {
+				next[to] += damping * rank[from] * float64(w) / float64(outWeight[from])
+			}
+		}
+		rank = next
+	}
+	return rank
+}
+
+// exportedSymbol reports whether a declaration belongs in the repo map:
+// top-level and visible outside its file or package
+func exportedSymbol(path string, s Symbol) bool {
+	if s.Kind == KindMethod || s.Container != "" {
+		return false
+	}
+	switch scriptFamily(path) {
+	case "go":
+		r, _ := utf8.DecodeRuneInString(s.Name)
+		return unicode.IsUpper(r)
+	case "js":
+		return strings.HasPrefix(s.Signature, "export")
+	case "python":
+		return !strings.HasPrefix(s.Name, "_")
+	}
+	return true
+}
+
+// renderRepoMap packs files in rank order into budget tokens, then prints
+// them grouped by directory
+func renderRepoMap(ranked []*repoMapFile, budget int) string {
+	const header = "# Repository map\nFiles of the project by directory, most depended-on first, with their exported symbols. Use find_symbol, search_codebase or read_file for details.\n"
+	remaining := budget*charsPerToken - len(header)
+
+	var dirs []string
+	byDir := make(map[string][]string)
+	shown := 0
+	for _, f := range ranked {
+		line := "  " + filepath.Base(f.path)
+		if len(f.symbols) > 0 {
+			names := f.symbols
+			if len(names) > maxMapSymbols {
+				names = append(names[:maxMapSymbols:maxMapSymbols], "…")
+			}
+			line += ": " + strings.Join(names, ", ")
+		}
+		line += "\n"
+		dir := filepath.ToSlash(filepath.Dir(f.path)) + "/"
+		cost := len(line)
+		if _, ok := byDir[dir]; !ok {
+			cost += len(dir) + 2
+		}
+		if cost > remaining {
+			continue
+		}
+		remaining -= cost
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], line)
+		shown++
+	}
+	if shown == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(header)
+	for _, dir := range dirs {
+		fmt.Fprintf(&b, "\n%s\n", dir)
+		for _, line := range byDir[dir] {
+			b.WriteString(line)
+		}
+	}
+	if more := len(ranked) - shown; more > 0 {
+		fmt.Fprintf(&b, "\n(%d more files not shown)\n", more)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Fingerprint identifies the indexed content: it changes whenever a file is
+// added, removed or re-indexed
+func (g *Graph) Fingerprint() string {
+	nodes := g.Snapshot()
+	keys := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Kind != KindMemory {
+			keys = append(keys, n.ID+"\x00"+n.Hash)
+		}
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// CachedRepoMap returns the repo map stored at path when it was built from
+// the current index with the same budget, and otherwise builds it and
+// stores it there. Failing to write the cache is not an error.
+func (g *Graph) CachedRepoMap(path string, budget int) string {
+	key := fmt.Sprintf("%s %s %d\n", repoMapHeader, g.Fingerprint(), budget)
+	if data, err := os.ReadFile(path); err == nil && strings.HasPrefix(string(data), key) {
+		return string(data[len(key):])
+	}
+	m := g.RepoMap(budget)
+	if m != "" {
+		os.WriteFile(path, []byte(key+m), 0644)
+	}
+	return m
+}
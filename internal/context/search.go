@@ -0,0 +1,292 @@
+package context
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// SearchFilter narrows search results to part of the project. The zero
+// value matches every node.
+type SearchFilter struct {
+	// Path is a glob matched against the node path ("internal/**/*.go",
+	// "*_test.go"); a pattern without a slash also matches the base name and
+	// a plain directory matches everything below it
+	Path string
+	// Language is a language name ("go", "python", "typescript") or a file
+	// extension with or without the dot
+	Language string
+	// Root is a workspace root directory (see Workspace)
+	Root string
+	// Package is a monorepo package by directory or declared name (see
+	// PackageOf)
+	Package string
+}
+
+func (f SearchFilter) empty() bool {
+	return f == SearchFilter{}
+}
+
+// Match reports whether the node passes the filter
+func (f SearchFilter) Match(n *Node) bool {
+	if f.Language != "" && !languageMatches(f.Language, n.Path) {
+		return false
+	}
+	if f.Root != "" && !within(filepath.Clean(f.Root), n.Path) {
+		return false
+	}
+	if f.Package != "" {
+		if pkg := PackageOf(n.Path); pkg == nil || !pkg.matches(f.Package) {
+			return false
+		}
+	}
+	return f.Path == "" || MatchPath(f.Path, n.Path)
+}
+
+func (f SearchFilter) apply(results []SearchResult) []SearchResult {
+	if f.empty() {
+		return results
+	}
+	kept := results[:0:0]
+	for _, r := range results {
+		if f.Match(r.Node) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// languages maps file extensions to the names accepted by SearchFilter
+var languages = map[string]string{
+	".go":       "go",
+	".py":       "python",
+	".js":       "javascript",
+	".jsx":      "javascript",
+	".mjs":      "javascript",
+	".cjs":      "javascript",
+	".ts":       "typescript",
+	".tsx":      "typescript",
+	".rs":       "rust",
+	".java":     "java",
+	".c":        "c",
+	".h":        "c",
+	".cc":       "cpp",
+	".cpp":      "cpp",
+	".hpp":      "cpp",
+	".rb":       "ruby",
+	".md":       "markdown",
+	".markdown": "markdown",
+	".mdx":      "markdown",
+	".rst":      "text",
+	".txt":      "text",
+	".sh":       "shell",
+	".toml":     "toml",
+	".yaml":     "yaml",
+	".yml":      "yaml",
+	".json":     "json",
+}
+
+// Language returns the language name of a path, or its extension without
+// the dot when the language is not known
+func Language(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if lang, ok := languages[ext]; ok {
+		return lang
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+func languageMatches(want, path string) bool {
+	want = strings.ToLower(strings.TrimSpace(want))
+	switch want {
+	case "golang":
+		want = "go"
+	case "js":
+		want = "javascript"
+	case "ts":
+		want = "typescript"
+	case "c++":
+		want = "cpp"
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	return Language(path) == want || ext == want || ext == "."+want
+}
+
+// MatchPath reports whether a slash-separated glob matches path. Besides
+// the filepath.Match syntax, "**" matches any number of directories.
+func MatchPath(pattern, path string) bool {
+	pattern = filepath.ToSlash(strings.TrimPrefix(filepath.Clean(pattern), "./"))
+	path = filepath.ToSlash(filepath.Clean(path))
+	if pattern == "." {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return path == pattern || strings.HasPrefix(path, pattern+"/")
+	}
+	if !strings.Contains(pattern, "/") {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	if !strings.Contains(pattern, "**") {
+		ok, _ := filepath.Match(pattern, path)
+		return ok
+	}
+	return globRegexp(pattern).MatchString(path)
+}
+
+// globRegexp translates a glob with "**" into a regular expression
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// "**/" matches zero or more whole directories
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return regexp.MustCompile("^$")
+	}
+	return re
+}
+
+// FormatResults renders ranked results for the model or a terminal: one
+// numbered heading per result with its score, location and symbol, then up
+// to maxLines lines of the snippet (all of it when maxLines is 0)
+func FormatResults(results []SearchResult, maxLines int) string {
+	var b strings.Builder
+	for i, r := range results {
+		n := r.Node
+		fmt.Fprintf(&b, "%d. [%.4f] %s", i+1, r.Score, n.Location())
+		if n.Symbol != "" {
+			fmt.Fprintf(&b, " (%s)", strings.TrimSpace(n.Kind+" "+n.Symbol))
+		}
+		if r.Similarity != 0 {
+			fmt.Fprintf(&b, " similarity %.3f", r.Similarity)
+		}
+		if r.Via != "" {
+			fmt.Fprintf(&b, " ← related to %s", r.Via)
+		}
+		b.WriteString("\n")
+
+		lines := strings.Split(strings.Trim(n.Content, "\n"), "\n")
+		more := 0
+		if maxLines > 0 && len(lines) > maxLines {
+			more = len(lines) - maxLines
+			lines = lines[:maxLines]
+		}
+		snippet := strings.Join(lines, "\n")
+		fence := codeFence(snippet)
+		fmt.Fprintf(&b, "%s%s\n%s\n", fence, strings.TrimPrefix(strings.ToLower(filepath.Ext(n.Path)), "."), snippet)
+		if more > 0 {
+			fmt.Fprintf(&b, "... %d more lines\n", more)
+		}
+		b.WriteString(fence + "\n\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// QueryTerms returns the lexical terms of a query as a set, for Highlight
+func QueryTerms(query string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, t := range tokenize(query) {
+		terms[t] = true
+	}
+	return terms
+}
+
+// matchesTerms reports whether a word shares a lexical term with the query
+func matchesTerms(word string, terms map[string]bool) bool {
+	for _, t := range tokenize(word) {
+		if terms[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// Excerpt picks up to maxLines lines of the node around its first line
+// that mentions a query term, or its opening lines when none does. It
+// returns the file line number of the first line and the lines.
+func Excerpt(n *Node, query string, maxLines int) (int, []string) {
+	lines := strings.Split(strings.TrimRight(n.Content, "\n"), "\n")
+	first := max(n.StartLine, 1)
+	for len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+		lines, first = lines[1:], first+1
+	}
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return first, lines
+	}
+	terms := QueryTerms(query)
+	start := 0
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" && Highlight(line, terms, nil) {
+			// A little context above the match
+			start = max(0, min(i-2, len(lines)-maxLines))
+			break
+		}
+	}
+	return first + start, lines[start : start+maxLines]
+}
+
+// Highlight reports whether line contains a word matching terms and, when
+// mark is set, passes each matching word to it in order with the text in
+// between, so callers can rebuild the line with the matches styled
+func Highlight(line string, terms map[string]bool, mark func(text string, match bool)) bool {
+	found := false
+	start := 0
+	inWord := false
+	flush := func(end int) {
+		if end <= start {
+			return
+		}
+		text := line[start:end]
+		match := inWord && matchesTerms(text, terms)
+		found = found || match
+		if mark != nil {
+			mark(text, match)
+		}
+		start = end
+	}
+	for i, r := range line {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+		if word != inWord {
+			flush(i)
+			inWord = word
+		}
+	}
+	flush(len(line))
+	return found
+}
@@ -0,0 +1,435 @@
+package context
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Symbol is a declaration recorded in the symbol table
+type Symbol struct {
+	Name      string // declared name
+	Kind      string // KindFunc, KindMethod, KindType or KindValue
+	Container string // receiver type or enclosing class of a method
+	Path      string // file, filled in from the node that holds the symbol
+	StartLine int
+	EndLine   int
+	Signature string // declaration line(s) without the body
+	Doc       string // doc comment or docstring
+}
+
+// QualifiedName is Container.Name for methods and Name otherwise
+func (s Symbol) QualifiedName() string {
+	if s.Container != "" {
+		return s.Container + "." + s.Name
+	}
+	return s.Name
+}
+
+// Location formats the symbol as path:start-end, with the path relative to
+// the working directory
+func (s Symbol) Location() string {
+	return fmt.Sprintf("%s:%d-%d", DisplayPath(s.Path), s.StartLine, s.EndLine)
+}
+
+// Reference is a line that uses a symbol
+type Reference struct {
+	Path   string
+	Line   int
+	Text   string // the line, trimmed
+	Symbol string // declaration the line belongs to, if any
+	// Exact is set when the use was resolved through imports or type
+	// information; otherwise the line merely mentions the name
+	Exact bool
+}
+
+const (
+	// maxSymbolDoc caps the doc comment kept per symbol
+	maxSymbolDoc = 500
+	// maxSymbolSignature caps the signature kept per symbol
+	maxSymbolSignature = 300
+	// maxReferences caps the references FindReferences returns
+	maxReferences = 200
+)
+
+// chunkDefs extracts the declarations of a file and assigns each one to
+// the chunk it starts in, one entry per chunk
+func chunkDefs(path, content string, chunks []Chunk) [][]Symbol {
+	defs := make([][]Symbol, len(chunks))
+	if len(chunks) == 0 {
+		return defs
+	}
+	var symbols []Symbol
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		symbols = goSymbols(path, content)
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		symbols = jsSymbols(content)
+	case ".py":
+		symbols = pySymbols(content)
+	}
+	for _, sym := range symbols {
+		sym.Signature = cleanSymbolText(sym.Signature, maxSymbolSignature)
+		sym.Doc = cleanSymbolText(sym.Doc, maxSymbolDoc)
+		i := 0
+		for j, c := range chunks {
+			if sym.StartLine >= c.StartLine && sym.StartLine <= c.EndLine {
+				i = j
+				break
+			}
+		}
+		defs[i] = append(defs[i], sym)
+	}
+	return defs
+}
+
+// cleanSymbolText trims text, drops the separators used by the index
+// format and caps its length
+func cleanSymbolText(s string, limit int) string {
+	s = strings.TrimSpace(strings.NewReplacer(refFieldSep, " ", refSep, " ").Replace(s))
+	if len(s) > limit {
+		s = strings.TrimSpace(s[:limit]) + "…"
+	}
+	return s
+}
+
+// goSymbols lists the top-level declarations of a Go file, and the methods
+// of its interfaces, with their signatures and doc comments
+func goSymbols(path, content string) []Symbol {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	var symbols []Symbol
+	add := func(name, kind, container string, node ast.Node, signature string, doc *ast.CommentGroup) {
+		if name == "_" {
+			return
+		}
+		sym := Symbol{
+			Name:      name,
+			Kind:      kind,
+			Container: container,
+			StartLine: fset.Position(node.Pos()).Line,
+			EndLine:   fset.Position(node.End()).Line,
+			Signature: signature,
+		}
+		if doc != nil {
+			sym.Doc = doc.Text()
+		}
+		symbols = append(symbols, sym)
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			kind, container := KindFunc, ""
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				kind, container = KindMethod, receiverName(d.Recv.List[0].Type)
+			}
+			fn := *d
+			fn.Body, fn.Doc = nil, nil
+			add(d.Name.Name, kind, container, d, goSource(fset, &fn), d.Doc)
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					var node ast.Node = s
+					doc := s.Doc
+					if len(d.Specs) == 1 {
+						node, doc = d, d.Doc
+					}
+					add(s.Name.Name, KindType, "", node, "type "+s.Name.Name+goTypeParams(fset, s)+" "+goTypeSummary(fset, s.Type), doc)
+					if iface, ok := s.Type.(*ast.InterfaceType); ok {
+						for _, m := range iface.Methods.List {
+							ft, ok := m.Type.(*ast.FuncType)
+							if !ok || len(m.Names) == 0 {
+								continue
+							}
+							sig := strings.TrimPrefix(goSource(fset, ft), "func")
+							add(m.Names[0].Name, KindMethod, s.Name.Name, m, m.Names[0].Name+sig, m.Doc)
+						}
+					}
+				case *ast.ValueSpec:
+					var node ast.Node = s
+					doc := s.Doc
+					if len(d.Specs) == 1 {
+						node, doc = d, d.Doc
+					}
+					sig := d.Tok.String() + " " + firstLine(goSource(fset, s))
+					for _, name := range s.Names {
+						add(name.Name, KindValue, "", node, sig, doc)
+					}
+				}
+			}
+		}
+	}
+	return symbols
+}
+
+func goSource(fset *token.FileSet, node interface{}) string {
+	var buf bytes.Buffer
+	if err := (&printer.Config{Mode: printer.UseSpaces, Tabwidth: 4}).Fprint(&buf, fset, node); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+func goTypeParams(fset *token.FileSet, s *ast.TypeSpec) string {
+	if s.TypeParams == nil || len(s.TypeParams.List) == 0 {
+		return ""
+	}
+	var params []string
+	for _, f := range s.TypeParams.List {
+		var names []string
+		for _, n := range f.Names {
+			names = append(names, n.Name)
+		}
+		params = append(params, strings.Join(names, ", ")+" "+goSource(fset, f.Type))
+	}
+	return "[" + strings.Join(params, ", ") + "]"
+}
+
+// goTypeSummary keeps struct and interface bodies out of type signatures
+func goTypeSummary(fset *token.FileSet, expr ast.Expr) string {
+	switch expr.(type) {
+	case *ast.StructType:
+		return "struct{…}"
+	case *ast.InterfaceType:
+		return "interface{…}"
+	}
+	return goSource(fset, expr)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// symbolTable indexes every node's Defs by name and qualified name
+type symbolTable map[string][]Symbol
+
+func buildSymbolTable(nodes map[string]*Node) symbolTable {
+	table := make(symbolTable)
+	for _, n := range nodes {
+		for _, d := range n.Defs {
+			d.Path = n.Path
+			table[d.Name] = append(table[d.Name], d)
+			if d.Container != "" {
+				table[d.QualifiedName()] = append(table[d.QualifiedName()], d)
+			}
+		}
+	}
+	for _, syms := range table {
+		sortSymbols(syms)
+	}
+	return table
+}
+
+func sortSymbols(syms []Symbol) {
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].Path != syms[j].Path {
+			return syms[i].Path < syms[j].Path
+		}
+		return syms[i].StartLine < syms[j].StartLine
+	})
+}
+
+// FindSymbol looks up declarations by name ("Search") or qualified name
+// ("Graph.Search"), limited to the files that match filter. When nothing
+// has that exact name, declarations whose name matches case-insensitively
+// or contains it are returned instead and exact is false.
+func (g *Graph) FindSymbol(name string, filter SearchFilter) (symbols []Symbol, exact bool) {
+	name = strings.TrimSpace(name)
+	g.mu.Lock()
+	table := g.linksLocked().symbols
+	g.mu.Unlock()
+
+	keep := func(s Symbol) bool { return filter.Match(&Node{Path: s.Path}) }
+	for _, s := range table[name] {
+		if keep(s) {
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) > 0 {
+		return symbols, true
+	}
+
+	lower := strings.ToLower(name)
+	seen := make(map[string]bool)
+	var similar, partial []Symbol
+	for key, syms := range table {
+		k := strings.ToLower(key)
+		if k != lower && !strings.Contains(k, lower) {
+			continue
+		}
+		for _, s := range syms {
+			id := s.Path + ":" + s.QualifiedName() + fmt.Sprint(s.StartLine)
+			if seen[id] || !keep(s) {
+				continue
+			}
+			seen[id] = true
+			if k == lower {
+				similar = append(similar, s)
+			} else {
+				partial = append(partial, s)
+			}
+		}
+	}
+	sortSymbols(similar)
+	sortSymbols(partial)
+	symbols = append(similar, partial...)
+	if len(symbols) > 20 {
+		symbols = symbols[:20]
+	}
+	return symbols, false
+}
+
+// FindReferences returns the declarations named name and the lines that
+// use them. Go uses come from type-checked refs and imports of other
+// languages from their import statements (both Exact); for languages
+// without call resolution, lines that mention the name in files of the
+// same language are added too.
+func (g *Graph) FindReferences(name string, filter SearchFilter) ([]Symbol, []Reference) {
+	defs, exact := g.FindSymbol(name, SearchFilter{})
+	if !exact {
+		return nil, nil
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var refs []Reference
+	seen := make(map[string]bool)
+	add := func(n *Node, line int, text string, exact bool) {
+		key := fmt.Sprintf("%s:%d", n.Path, line)
+		if seen[key] || len(refs) >= maxReferences {
+			return
+		}
+		seen[key] = true
+		refs = append(refs, Reference{Path: n.Path, Line: line, Text: strings.TrimSpace(text), Symbol: n.Symbol, Exact: exact})
+	}
+	isDecl := func(n *Node, line int) bool {
+		for _, d := range defs {
+			if d.Path == n.Path && d.StartLine == line {
+				return true
+			}
+		}
+		return false
+	}
+
+	targets := make(map[string]bool)
+	textual := make(map[string]bool) // languages searched by name
+	for _, d := range defs {
+		if strings.EqualFold(filepath.Ext(d.Path), ".go") {
+			targets["go:"+filepath.Dir(d.Path)+":"+d.QualifiedName()] = true
+			if d.Kind == KindMethod && !strings.HasPrefix(d.Signature, "func") {
+				// Calls through an interface are not resolved to it
+				textual["go"] = true
+			}
+		} else {
+			targets["def:"+d.Path+":"+d.Name] = true
+			textual[scriptFamily(d.Path)] = true
+		}
+	}
+	word := regexp.MustCompile(`\b` + regexp.QuoteMeta(defs[0].Name) + `\b`)
+
+	for _, id := range sortedNodeIDs(g.Nodes) {
+		n := g.Nodes[id]
+		if !filter.Match(n) {
+			continue
+		}
+		resolved := false
+		for _, r := range n.Refs {
+			if targets[r.Target] {
+				resolved = true
+				break
+			}
+		}
+		if !resolved && !textual[scriptFamily(n.Path)] {
+			continue
+		}
+		lines := strings.Split(n.Content, "\n")
+		found := false
+		for i, line := range lines {
+			lineNo := n.StartLine + i
+			if word.MatchString(line) && !isDecl(n, lineNo) {
+				add(n, lineNo, line, resolved)
+				found = true
+			}
+		}
+		if resolved && !found {
+			// Used through an alias or a whole-package import
+			add(n, n.StartLine, firstLine(strings.TrimLeft(n.Content, "\n")), true)
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].Exact != refs[j].Exact {
+			return refs[i].Exact
+		}
+		if refs[i].Path != refs[j].Path {
+			return refs[i].Path < refs[j].Path
+		}
+		return refs[i].Line < refs[j].Line
+	})
+	return defs, refs
+}
+
+// scriptFamily groups languages that can reference each other by name
+func scriptFamily(path string) string {
+	switch lang := Language(path); lang {
+	case "javascript", "typescript":
+		return "js"
+	default:
+		return lang
+	}
+}
+
+// FormatSymbols renders declarations with their signature and the first
+// paragraph of their doc comment
+func FormatSymbols(symbols []Symbol) string {
+	var b strings.Builder
+	for _, s := range symbols {
+		fmt.Fprintf(&b, "%s %s  %s\n", s.Kind, s.QualifiedName(), s.Location())
+		if s.Signature != "" {
+			fmt.Fprintf(&b, "    %s\n", s.Signature)
+		}
+		if doc, _, _ := strings.Cut(s.Doc, "\n\n"); doc != "" {
+			for _, line := range strings.Split(doc, "\n") {
+				fmt.Fprintf(&b, "    // %s\n", line)
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// FormatReferences renders the result of FindReferences
+func FormatReferences(defs []Symbol, refs []Reference) string {
+	var b strings.Builder
+	b.WriteString(FormatSymbols(defs))
+	fmt.Fprintf(&b, "\n\n%d reference(s)", len(refs))
+	if len(refs) == maxReferences {
+		b.WriteString(" (limit reached)")
+	}
+	b.WriteString(":\n")
+	for _, r := range refs {
+		fmt.Fprintf(&b, "%s:%d", DisplayPath(r.Path), r.Line)
+		if r.Symbol != "" {
+			fmt.Fprintf(&b, " (in %s)", r.Symbol)
+		}
+		if !r.Exact {
+			b.WriteString(" [name match]")
+		}
+		fmt.Fprintf(&b, ": %s\n", r.Text)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,290 @@
+package context
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	jsArrow     = regexp.MustCompile(`^(?:export\s+)?(?:const|let|var)\s+[A-Za-z_$][\w$]*\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]+)?=>|[A-Za-z_$][\w$]*\s*=>)`)
+	jsMethod    = regexp.MustCompile(`^\s+(?:(?:public|private|protected|static|readonly|abstract|override|async|get|set)\s+)*\*?([A-Za-z_$#][\w$]*)\s*(?:<[^>]*>)?\s*\(`)
+	jsNotMethod = map[string]bool{"if": true, "for": true, "while": true, "switch": true, "catch": true, "return": true, "function": true, "super": true}
+
+	pyDef    = regexp.MustCompile(`^(\s*)(?:async\s+)?def\s+([A-Za-z_]\w*)`)
+	pyClass  = regexp.MustCompile(`^(\s*)class\s+([A-Za-z_]\w*)`)
+	pyAssign = regexp.MustCompile(`^([A-Za-z_]\w*)\s*(?::[^=]+)?=[^=]`)
+)
+
+// jsSymbols lists the top-level declarations of a JS/TS file and the
+// methods of its classes. Bodies are delimited by brace matching, which is
+// good enough for formatted code.
+func jsSymbols(content string) []Symbol {
+	lines := strings.Split(content, "\n")
+	depths := braceDepths(lines)
+	var symbols []Symbol
+	for i, line := range lines {
+		if line == "" || depths[i] != 0 || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		for _, p := range jsPatterns {
+			m := p.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			kind := p.kind
+			if kind == KindValue && jsArrow.MatchString(line) {
+				kind = KindFunc
+			}
+			end := blockEnd(lines, depths, i)
+			symbols = append(symbols, Symbol{
+				Name:      m[1],
+				Kind:      kind,
+				StartLine: i + 1,
+				EndLine:   end + 1,
+				Signature: jsSignature(line),
+				Doc:       commentAbove(lines, i),
+			})
+			if strings.Contains(line, "class ") {
+				symbols = append(symbols, jsMethods(lines, depths, i, end, m[1])...)
+			}
+			break
+		}
+	}
+	return symbols
+}
+
+// jsMethods finds the methods declared directly in the class body between
+// lines start and end
+func jsMethods(lines []string, depths []int, start, end int, class string) []Symbol {
+	var methods []Symbol
+	for i := start + 1; i < end; i++ {
+		if depths[i] != 1 {
+			continue
+		}
+		m := jsMethod.FindStringSubmatch(lines[i])
+		if m == nil || jsNotMethod[m[1]] || strings.HasSuffix(strings.TrimSpace(lines[i]), ";") {
+			continue
+		}
+		last := blockEnd(lines, depths, i)
+		methods = append(methods, Symbol{
+			Name:      m[1],
+			Kind:      KindMethod,
+			Container: class,
+			StartLine: i + 1,
+			EndLine:   last + 1,
+			Signature: jsSignature(lines[i]),
+			Doc:       commentAbove(lines, i),
+		})
+		i = last
+	}
+	return methods
+}
+
+func jsSignature(line string) string {
+	line = strings.TrimSpace(line)
+	if i := strings.Index(line, "=>"); i >= 0 {
+		return strings.TrimSpace(line[:i+2])
+	}
+	return strings.TrimSpace(strings.TrimSuffix(line, "{"))
+}
+
+// braceDepths returns the brace nesting depth at the start of each line,
+// ignoring braces in strings and line comments
+func braceDepths(lines []string) []int {
+	depths := make([]int, len(lines))
+	depth := 0
+	for i, line := range lines {
+		depths[i] = depth
+		var quote byte
+		for j := 0; j < len(line); j++ {
+			c := line[j]
+			switch {
+			case quote != 0:
+				if c == '\\' {
+					j++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'' || c == '`':
+				quote = c
+			case c == '/' && j+1 < len(line) && line[j+1] == '/':
+				j = len(line)
+			case c == '{':
+				depth++
+			case c == '}':
+				depth = max(depth-1, 0)
+			}
+		}
+	}
+	return depths
+}
+
+// blockEnd returns the line where the declaration starting at line start
+// ends: where its braces close, or the line itself when it opens none
+func blockEnd(lines []string, depths []int, start int) int {
+	for i := start + 1; i < len(lines); i++ {
+		if depths[i] <= depths[start] {
+			if i == start+1 && !strings.Contains(lines[start], "{") {
+				return start
+			}
+			return i - 1
+		}
+	}
+	return len(lines) - 1
+}
+
+// commentAbove returns the comment block directly above line i
+func commentAbove(lines []string, i int) string {
+	var doc []string
+	for j := i - 1; j >= 0 && isCommentLine(lines[j]) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "@"); j-- {
+		t := strings.TrimSpace(lines[j])
+		for _, prefix := range []string{"/**", "/*", "*/", "//", "#", "*"} {
+			t = strings.TrimPrefix(t, prefix)
+		}
+		t = strings.TrimSpace(strings.TrimSuffix(t, "*/"))
+		if t != "" {
+			doc = append([]string{t}, doc...)
+		}
+	}
+	return strings.Join(doc, "\n")
+}
+
+// pySymbols lists the top-level functions, classes and assignments of a
+// Python file and the methods of its classes, delimited by indentation
+func pySymbols(content string) []Symbol {
+	lines := strings.Split(content, "\n")
+	type scope struct {
+		indent int
+		class  string // "" for a function
+	}
+	var stack []scope
+	var symbols []Symbol
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		for len(stack) > 0 && indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		inClass := len(stack) > 0 && stack[len(stack)-1].class != ""
+		nested := false
+		for _, s := range stack {
+			nested = nested || s.class == ""
+		}
+
+		if m := pyClass.FindStringSubmatch(line); m != nil {
+			stack = append(stack, scope{indent: indent, class: m[2]})
+			if nested || (len(stack) > 1 && !inClass) {
+				continue
+			}
+			sig, body := pySignature(lines, i)
+			symbols = append(symbols, Symbol{
+				Name:      m[2],
+				Kind:      KindType,
+				StartLine: i + 1,
+				EndLine:   pyBlockEnd(lines, i, indent) + 1,
+				Signature: sig,
+				Doc:       pyDocstring(lines, body),
+			})
+			continue
+		}
+		if m := pyDef.FindStringSubmatch(line); m != nil {
+			stack = append(stack, scope{indent: indent})
+			if nested || (indent > 0 && !inClass) {
+				continue
+			}
+			sym := Symbol{Name: m[2], Kind: KindFunc, StartLine: i + 1, EndLine: pyBlockEnd(lines, i, indent) + 1}
+			if inClass {
+				sym.Kind, sym.Container = KindMethod, stack[len(stack)-2].class
+			}
+			var body int
+			sym.Signature, body = pySignature(lines, i)
+			sym.Doc = pyDocstring(lines, body)
+			symbols = append(symbols, sym)
+			continue
+		}
+		if indent == 0 {
+			if m := pyAssign.FindStringSubmatch(line); m != nil {
+				symbols = append(symbols, Symbol{
+					Name:      m[1],
+					Kind:      KindValue,
+					StartLine: i + 1,
+					EndLine:   i + 1,
+					Signature: t,
+					Doc:       commentAbove(lines, i),
+				})
+			}
+		}
+	}
+	return symbols
+}
+
+// pySignature joins the lines of a def or class header up to its colon and
+// returns the index of the first body line
+func pySignature(lines []string, i int) (string, int) {
+	var parts []string
+	for j := i; j < len(lines) && j < i+20; j++ {
+		t := strings.TrimSpace(lines[j])
+		parts = append(parts, t)
+		if strings.HasSuffix(t, ":") {
+			return strings.TrimSuffix(strings.Join(parts, " "), ":"), j + 1
+		}
+	}
+	return strings.TrimSpace(lines[i]), i + 1
+}
+
+// pyBlockEnd returns the last non-blank line indented deeper than indent
+// after line i
+func pyBlockEnd(lines []string, i, indent int) int {
+	end := i
+	for j := i + 1; j < len(lines); j++ {
+		t := strings.TrimSpace(lines[j])
+		if t == "" {
+			continue
+		}
+		if len(lines[j])-len(strings.TrimLeft(lines[j], " \t")) <= indent && !strings.HasPrefix(t, "#") {
+			break
+		}
+		end = j
+	}
+	return end
+}
+
+// pyDocstring returns the docstring starting at line i, if there is one
+func pyDocstring(lines []string, i int) string {
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	if i >= len(lines) {
+		return ""
+	}
+	t := strings.TrimSpace(lines[i])
+	t = strings.TrimLeft(t, "rRbBuU")
+	var quote string
+	for _, q := range []string{`"""`, `'''`, `"`, `'`} {
+		if strings.HasPrefix(t, q) {
+			quote = q
+			break
+		}
+	}
+	if quote == "" {
+		return ""
+	}
+	t = t[len(quote):]
+	if end := strings.Index(t, quote); end >= 0 {
+		return strings.TrimSpace(t[:end])
+	}
+	doc := []string{t}
+	for j := i + 1; j < len(lines) && j < i+50; j++ {
+		line := strings.TrimSpace(lines[j])
+		if end := strings.Index(line, quote); end >= 0 {
+			doc = append(doc, line[:end])
+			break
+		}
+		doc = append(doc, line)
+	}
+	return strings.TrimSpace(strings.Join(doc, "\n"))
+}
@@ -0,0 +1,382 @@
+package context
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"craft-cli/internal/config"
+)
+
+// UpdateSummary reports what an incremental Update changed
+type UpdateSummary struct {
+	Added     []string
+	Changed   []string
+	Removed   []string
+	Unchanged int
+	Touched   int   // unchanged files whose new mtime was recorded
+	Skipped   int   // binary files and files over index.max_file_size
+	Bytes     int64 // size of the files indexed or checked
+	Failed    map[string]error
+	Duration  time.Duration
+}
+
+// String formats the summary for logs and the terminal
+func (s *UpdateSummary) String() string {
+	out := fmt.Sprintf("%d added, %d changed, %d removed, %d unchanged",
+		len(s.Added), len(s.Changed), len(s.Removed), s.Unchanged)
+	if s.Skipped > 0 {
+		out += fmt.Sprintf(", %d skipped", s.Skipped)
+	}
+	if len(s.Failed) > 0 {
+		out += fmt.Sprintf(", %d failed", len(s.Failed))
+	}
+	return out + fmt.Sprintf(" in %s", s.Duration.Round(time.Millisecond))
+}
+
+// Empty reports whether the update left the graph untouched, including the
+// recorded mtimes, so there is nothing to save
+func (s *UpdateSummary) Empty() bool {
+	return len(s.Added)+len(s.Changed)+len(s.Removed)+s.Touched == 0
+}
+
+// fileChange classifies what updateFile did with one file
+type fileChange int
+
+const (
+	fileUnchanged fileChange = iota
+	fileTouched              // content unchanged, mtime refreshed
+	fileAdded
+	fileChanged
+	fileSkipped
+)
+
+// UpdateOptions tunes UpdateWith
+type UpdateOptions struct {
+	// Workers is the number of files indexed concurrently (default
+	// index.workers); embedding requests are rate limited by the embedder
+	Workers int
+	// Progress, if set, is called after each file with the totals so far
+	Progress func(Progress)
+}
+
+// Progress reports how far an update has got
+type Progress struct {
+	Path       string // file just processed
+	Files      int
+	TotalFiles int
+	Bytes      int64
+	TotalBytes int64
+	Elapsed    time.Duration
+}
+
+// ETA estimates the time left from the bytes processed so far
+func (p Progress) ETA() time.Duration {
+	if p.Bytes == 0 || p.Bytes >= p.TotalBytes {
+		return 0
+	}
+	rate := float64(p.Elapsed) / float64(p.Bytes)
+	return time.Duration(rate * float64(p.TotalBytes-p.Bytes))
+}
+
+// Update brings the graph in line with the files under root. Files whose
+// mtime is unchanged are skipped without being read, files whose content hash
+// is unchanged only get their mtime refreshed, and only added or modified
+// files are re-embedded. Nodes for files that no longer exist are dropped.
+// Files excluded by index.ignore, .gitignore or .craftignore are not indexed.
+func (g *Graph) Update(root string) (*UpdateSummary, error) {
+	return g.UpdateWith(root, UpdateOptions{})
+}
+
+// UpdateWith is Update with concurrency and progress reporting
+func (g *Graph) UpdateWith(root string, opts UpdateOptions) (*UpdateSummary, error) {
+	root = filepath.Clean(root)
+	start := time.Now()
+	summary := &UpdateSummary{Failed: make(map[string]error)}
+	seen := make(map[string]bool)
+	byPath := g.nodesByPath()
+
+	// List the files first so progress has totals to report against
+	type candidate struct {
+		path string
+		info os.FileInfo
+	}
+	var files []candidate
+	var totalBytes int64
+	err := walkProject(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != root && ignored(path, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored(path, false) || !info.Mode().IsRegular() || isIndexFile(path) {
+			return nil
+		}
+		path = filepath.Clean(path)
+		if info.Size() > int64(config.Get().Index.MaxFileSize) {
+			// Any old nodes are dropped below
+			summary.Skipped++
+			return nil
+		}
+		files = append(files, candidate{path, info})
+		totalBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = config.Get().Index.Workers
+	}
+	workers = max(1, min(workers, len(files)))
+
+	var mu sync.Mutex
+	var done int
+	jobs := make(chan candidate)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				change, err := g.updateFile(f.path, f.info, byPath[f.path])
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					summary.Failed[f.path] = err
+					// Keep the old nodes rather than dropping them below
+					seen[f.path] = true
+				case change == fileSkipped:
+					summary.Skipped++
+				case change == fileAdded:
+					summary.Added = append(summary.Added, f.path)
+				case change == fileChanged:
+					summary.Changed = append(summary.Changed, f.path)
+				case change == fileTouched:
+					summary.Unchanged++
+					summary.Touched++
+				default:
+					summary.Unchanged++
+				}
+				if err == nil && change != fileSkipped {
+					seen[f.path] = true
+				}
+				done++
+				summary.Bytes += f.info.Size()
+				if opts.Progress != nil {
+					opts.Progress(Progress{
+						Path:       f.path,
+						Files:      done,
+						TotalFiles: len(files),
+						Bytes:      summary.Bytes,
+						TotalBytes: totalBytes,
+						Elapsed:    time.Since(start),
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, f := range files {
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Drop nodes for files under root that were deleted or are now ignored
+	for path := range byPath {
+		if !seen[path] && within(root, path) {
+			g.RemoveFile(path)
+			summary.Removed = append(summary.Removed, path)
+		}
+	}
+
+	sort.Strings(summary.Added)
+	sort.Strings(summary.Changed)
+	sort.Strings(summary.Removed)
+	summary.Duration = time.Since(start)
+	return summary, nil
+}
+
+// UpdateFile re-indexes a single file, or drops its nodes when it was
+// deleted or is no longer indexable. It reports whether the graph changed.
+func (g *Graph) UpdateFile(path string) (bool, error) {
+	path = filepath.Clean(path)
+	existing := g.nodesByPath()[path]
+
+	info, err := os.Stat(projectAbs(path))
+	if err != nil || !indexable(path, info) {
+		if len(existing) == 0 {
+			return false, nil
+		}
+		g.RemoveFile(path)
+		return true, nil
+	}
+
+	change, err := g.updateFile(path, info, existing)
+	if change == fileSkipped && len(existing) > 0 {
+		g.RemoveFile(path)
+		return true, err
+	}
+	return change == fileAdded || change == fileChanged, err
+}
+
+func (g *Graph) updateFile(path string, info os.FileInfo, existing []*Node) (fileChange, error) {
+	// Nodes embedded by another model must be re-embedded even if unchanged
+	if g.staleEmbeddings(existing) {
+		content, err := os.ReadFile(projectAbs(path))
+		if err != nil {
+			return fileUnchanged, err
+		}
+		if isBinary(content) {
+			return fileSkipped, nil
+		}
+		if err := g.AddFile(path, string(content)); err != nil {
+			return fileUnchanged, err
+		}
+		return fileChanged, nil
+	}
+	if len(existing) > 0 && existing[0].ModTime.Equal(info.ModTime()) {
+		return fileUnchanged, nil
+	}
+
+	content, err := os.ReadFile(projectAbs(path))
+	if err != nil {
+		return fileUnchanged, err
+	}
+	if isBinary(content) {
+		return fileSkipped, nil
+	}
+
+	if len(existing) > 0 && existing[0].Hash == hashContent(string(content)) {
+		g.mu.Lock()
+		for _, n := range existing {
+			n.ModTime = info.ModTime()
+		}
+		g.mu.Unlock()
+		return fileTouched, nil
+	}
+
+	if err := g.AddFile(path, string(content)); err != nil {
+		return fileUnchanged, err
+	}
+	if len(existing) > 0 {
+		return fileChanged, nil
+	}
+	return fileAdded, nil
+}
+
+// staleEmbeddings reports whether nodes were embedded by a model other
+// than the current embedder's
+func (g *Graph) staleEmbeddings(nodes []*Node) bool {
+	embedder := g.Embedder()
+	if embedder == nil {
+		return false
+	}
+	model := embedder.Model()
+	for _, n := range nodes {
+		if n.Model != model {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveFile drops every node that belongs to path
+func (g *Graph) RemoveFile(path string) {
+	path = filepath.Clean(path)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removePathLocked(path)
+}
+
+// RemoveTree drops the nodes of path and of every file below it, as when a
+// file is deleted or a directory is moved away, and returns the files whose
+// nodes were dropped
+func (g *Graph) RemoveTree(path string) []string {
+	path = filepath.Clean(path)
+	var files []string
+	for p := range g.nodesByPath() {
+		if within(path, p) {
+			files = append(files, p)
+		}
+	}
+	sort.Strings(files)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, p := range files {
+		g.removePathLocked(p)
+	}
+	return files
+}
+
+func (g *Graph) nodesByPath() map[string][]*Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := make(map[string][]*Node)
+	for _, n := range g.Nodes {
+		if n.Kind == KindMemory {
+			continue
+		}
+		out[n.Path] = append(out[n.Path], n)
+	}
+	return out
+}
+
+// indexable reports whether a file should be part of the index
+func indexable(path string, info os.FileInfo) bool {
+	if ignored(path, false) || !info.Mode().IsRegular() || info.Size() > int64(config.Get().Index.MaxFileSize) {
+		return false
+	}
+	return !isIndexFile(path)
+}
+
+// isIndexFile reports whether path is the index itself, its ANN sidecar,
+// its legacy form or a save in progress, which are never indexed
+func isIndexFile(path string) bool {
+	base, index := filepath.Base(path), filepath.Base(config.Get().Index.Path)
+	return base == LegacyIndexPath || strings.HasPrefix(base, index)
+}
+
+// ignored reports whether any path element matches index.ignore or the
+// path is excluded by a .gitignore or .craftignore. The memory store is
+// never indexed as files; see SetMemories.
+func ignored(path string, isDir bool) bool {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		if part == "memory" && i > 0 && parts[i-1] == ".craft" {
+			return true
+		}
+		for _, pattern := range config.Get().Index.Ignore {
+			if part == pattern {
+				return true
+			}
+		}
+	}
+	return ignoreFiles.ignoredByFiles(path, isDir)
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isBinary treats content with a NUL byte in the first 8KB as binary
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
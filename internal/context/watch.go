@@ -0,0 +1,166 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultDebounce is how long a Watcher waits for changes to settle
+const DefaultDebounce = 500 * time.Millisecond
+
+// notifier delivers paths of files that changed under a root. A directory
+// that still exists means changes were lost, so all of it is rescanned.
+type notifier interface {
+	Events() <-chan string
+	Close() error
+}
+
+// Watcher keeps a graph in sync with the files under a root while a
+// session runs. Changes are debounced and re-indexed in the background.
+type Watcher struct {
+	graph    *Graph
+	root     string
+	debounce time.Duration
+	notify   notifier
+
+	// OnUpdate, if set, is called after each batch of changes is applied
+	OnUpdate func(changed []string, err error)
+
+	mu       sync.Mutex
+	pending  map[string]bool
+	timer    *time.Timer
+	done     chan struct{}
+	wg       sync.WaitGroup
+	flushing sync.Mutex // held while a batch is applied
+}
+
+// Watch starts watching root and feeding changes into the graph.
+// Call Close to stop it.
+func (g *Graph) Watch(root string, debounce time.Duration) (*Watcher, error) {
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	n, err := newNotifier(root)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		graph:    g,
+		root:     root,
+		debounce: debounce,
+		notify:   n,
+		pending:  make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w, nil
+}
+
+// Invalidate drops the nodes of a file right away, so searches cannot
+// return stale content, and queues it for re-indexing. Tools that write
+// files call this as soon as the write succeeds.
+func (w *Watcher) Invalidate(path string) {
+	w.graph.RemoveFile(path)
+	w.queue(path)
+}
+
+// Close stops the watcher and waits for the background goroutine and for
+// a batch being applied, so OnUpdate is not called after it returns
+func (w *Watcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	err := w.notify.Close()
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+	w.flushing.Lock()
+	w.flushing.Unlock()
+	return err
+}
+
+func (w *Watcher) loop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		case path, ok := <-w.notify.Events():
+			if !ok {
+				return
+			}
+			w.queue(path)
+		}
+	}
+}
+
+func (w *Watcher) queue(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[filepath.Clean(path)] = true
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.debounce, w.flush)
+	} else {
+		w.timer.Reset(w.debounce)
+	}
+}
+
+func (w *Watcher) flush() {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+
+	w.mu.Lock()
+	paths := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		paths = append(paths, p)
+	}
+	w.pending = make(map[string]bool)
+	w.mu.Unlock()
+
+	var changed []string
+	var firstErr error
+	for _, p := range paths {
+		info, err := os.Lstat(projectAbs(p))
+		if os.IsNotExist(err) {
+			// A deleted file, or a directory moved out of the tree
+			changed = append(changed, w.graph.RemoveTree(p)...)
+			continue
+		}
+		if err == nil && info.IsDir() {
+			summary, err := w.graph.Update(p)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if summary != nil {
+				changed = append(changed, summary.Added...)
+				changed = append(changed, summary.Changed...)
+				changed = append(changed, summary.Removed...)
+			}
+			continue
+		}
+		ok, err := w.graph.UpdateFile(p)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if ok {
+			changed = append(changed, p)
+		}
+	}
+	if w.OnUpdate != nil && (len(changed) > 0 || firstErr != nil) {
+		w.OnUpdate(changed, firstErr)
+	}
+}
@@ -0,0 +1,181 @@
+package context
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchUpdates starts a watcher on the project root and returns the batches
+// it applies
+func watchUpdates(t *testing.T, g *Graph, debounce time.Duration) (*Watcher, <-chan []string) {
+	t.Helper()
+	w, err := g.Watch(".", debounce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	updates := make(chan []string, 16)
+	w.OnUpdate = func(changed []string, err error) {
+		if err != nil {
+			t.Errorf("update failed: %v", err)
+		}
+		slices.Sort(changed)
+		updates <- changed
+	}
+	return w, updates
+}
+
+func nextUpdate(t *testing.T, updates <-chan []string) []string {
+	t.Helper()
+	select {
+	case changed := <-updates:
+		return changed
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update from the watcher")
+		return nil
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hasPath(g *Graph, path string) bool {
+	for _, n := range g.Snapshot() {
+		if n.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWatcherFollowsFiles(t *testing.T) {
+	inSubdir(t, ".")
+	g := lexicalGraph()
+	_, updates := watchUpdates(t, g, 50*time.Millisecond)
+
+	writeFile(t, "a.go", "package a\n\nfunc Alpha() {}\n")
+	if changed := nextUpdate(t, updates); !slices.Equal(changed, []string{"a.go"}) || !hasPath(g, "a.go") {
+		t.Fatalf("update = %v, want a.go indexed", changed)
+	}
+
+	writeFile(t, "a.go", "package a\n\nfunc Beta() {}\n")
+	nextUpdate(t, updates)
+	if res := g.SearchLexicalFiltered("Beta", 1, SearchFilter{}); len(res) != 1 {
+		t.Errorf("search for the new content = %v", res)
+	}
+
+	if err := os.Remove("a.go"); err != nil {
+		t.Fatal(err)
+	}
+	if changed := nextUpdate(t, updates); !slices.Equal(changed, []string{"a.go"}) || hasPath(g, "a.go") {
+		t.Errorf("update = %v, want a.go dropped", changed)
+	}
+}
+
+func TestWatcherDebounces(t *testing.T) {
+	inSubdir(t, ".")
+	g := lexicalGraph()
+	_, updates := watchUpdates(t, g, 300*time.Millisecond)
+
+	for _, name := range []string{"a.go", "b.go", "a.go", "c.go"} {
+		writeFile(t, name, "package x\n// "+name+"\n")
+	}
+	if changed := nextUpdate(t, updates); !slices.Equal(changed, []string{"a.go", "b.go", "c.go"}) {
+		t.Errorf("first batch = %v, want every file once", changed)
+	}
+	select {
+	case changed := <-updates:
+		t.Errorf("second batch %v for writes within the debounce", changed)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestWatcherIgnores(t *testing.T) {
+	inSubdir(t, ".")
+	writeFile(t, ".gitignore", "*.log\n")
+	if err := os.Mkdir("node_modules", 0755); err != nil {
+		t.Fatal(err)
+	}
+	g := lexicalGraph()
+	_, updates := watchUpdates(t, g, 100*time.Millisecond)
+
+	writeFile(t, "debug.log", "noise\n")
+	writeFile(t, "node_modules/dep.js", "module.exports = 1\n")
+	writeFile(t, "kept.go", "package kept\n")
+	if changed := nextUpdate(t, updates); !slices.Equal(changed, []string{"kept.go"}) {
+		t.Errorf("update = %v, want only kept.go", changed)
+	}
+	if hasPath(g, "debug.log") {
+		t.Error("ignored file indexed")
+	}
+}
+
+func TestWatcherClose(t *testing.T) {
+	inSubdir(t, ".")
+	g := lexicalGraph()
+	w, updates := watchUpdates(t, g, 50*time.Millisecond)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+	writeFile(t, "late.go", "package late\n")
+	w.Invalidate("late.go")
+	select {
+	case changed := <-updates:
+		t.Errorf("update %v after Close", changed)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if hasPath(g, "late.go") {
+		t.Error("file indexed after Close")
+	}
+}
+
+// fakeNotifier delivers the paths sent to it
+type fakeNotifier struct {
+	events chan string
+	once   sync.Once
+}
+
+func (n *fakeNotifier) Events() <-chan string { return n.events }
+func (n *fakeNotifier) Close() error {
+	n.once.Do(func() { close(n.events) })
+	return nil
+}
+
+func TestWatcherRescansDirectory(t *testing.T) {
+	inSubdir(t, ".")
+	g := lexicalGraph()
+	if err := g.AddFile("gone.go", "package gone\n"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, "missed.go", "package missed\n")
+
+	// What a notifier sends after its event queue overflowed
+	n := &fakeNotifier{events: make(chan string, 1)}
+	w := &Watcher{graph: g, root: ".", debounce: 10 * time.Millisecond, notify: n, pending: make(map[string]bool), done: make(chan struct{})}
+	updates := make(chan []string, 1)
+	w.OnUpdate = func(changed []string, err error) {
+		slices.Sort(changed)
+		updates <- changed
+	}
+	w.wg.Add(1)
+	go w.loop()
+	defer w.Close()
+
+	n.events <- "."
+	if changed := nextUpdate(t, updates); !slices.Equal(changed, []string{"gone.go", "missed.go"}) {
+		t.Errorf("rescan changed %v, want the missed file added and the deleted one dropped", changed)
+	}
+	if !hasPath(g, "missed.go") || hasPath(g, "gone.go") {
+		t.Error("graph does not match the directory after the rescan")
+	}
+}
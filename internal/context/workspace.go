@@ -0,0 +1,339 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"craft-cli/internal/config"
+)
+
+// Workspace is a project made of one or more index roots, such as the
+// services of a monorepo. Each root keeps its own index file under the
+// root directory; at query time they are merged into one Graph so ranking
+// and dependency edges span the whole project. Paths are relative to the
+// project root (see ProjectRel), whatever the working directory.
+type Workspace struct {
+	Roots []string // cleaned, relative to the project root; "." is the whole project
+	Graph *Graph
+
+	saveMu sync.Mutex // one Save at a time, e.g. from several watchers
+
+	// The last repo map, kept until the graph changes
+	mapMu     sync.Mutex
+	mapGen    uint64
+	mapBudget int
+	mapText   string
+}
+
+// NewWorkspace creates an empty workspace for the configured index.roots
+func NewWorkspace() *Workspace {
+	roots := config.Get().Index.Roots
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	w := &Workspace{Graph: NewGraph()}
+	for _, r := range roots {
+		w.Roots = append(w.Roots, filepath.Clean(r))
+	}
+	sort.Strings(w.Roots)
+	return w
+}
+
+// IndexPath returns the index file of a root
+func (w *Workspace) IndexPath(root string) string {
+	return projectAbs(filepath.Join(root, config.Get().Index.Path))
+}
+
+// sharedIndexPath names the files kept for a workspace with several roots
+// as a whole, at the project root: the ANN sidecar spans every root, since
+// searches do
+func (w *Workspace) sharedIndexPath() string {
+	return w.IndexPath(".") + ".workspace"
+}
+
+// RepoMap returns the repo map of the workspace (see Graph.RepoMap),
+// cached next to the index at the project root. It is cheap to call for
+// every prompt: the map is only rebuilt after the graph changes.
+func (w *Workspace) RepoMap(budget int) string {
+	w.mapMu.Lock()
+	defer w.mapMu.Unlock()
+	gen := w.Graph.Generation()
+	if w.mapText != "" && w.mapGen == gen && w.mapBudget == budget {
+		return w.mapText
+	}
+	w.mapText = w.Graph.CachedRepoMap(repoMapPath(w.IndexPath(".")), budget)
+	w.mapGen, w.mapBudget = gen, budget
+	return w.mapText
+}
+
+// RootOf returns the innermost root containing path, or "" if none does
+func (w *Workspace) RootOf(path string) string {
+	best := ""
+	for _, r := range w.Roots {
+		if within(r, path) && (best == "" || len(r) > len(best)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// Load reads every root's index into the graph. Roots without an index are
+// skipped; the errors of the others are returned by root, so a corrupt
+// index can be rebuilt without losing the rest. migrated lists roots whose
+// legacy JSON index was converted. Several roots share one ANN sidecar at
+// the project root.
+func (w *Workspace) Load() (migrated []string, errs map[string]error) {
+	errs = make(map[string]error)
+	if len(w.Roots) == 1 {
+		// One root keeps its ANN sidecar next to its index
+		root := w.Roots[0]
+		m, err := w.Graph.LoadIndex(w.IndexPath(root))
+		if m {
+			migrated = append(migrated, root)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			errs[root] = err
+		}
+		return migrated, errs
+	}
+
+	nodes := make(map[string]*Node)
+	for _, root := range w.Roots {
+		part := NewGraph()
+		m, err := part.LoadIndex(w.IndexPath(root))
+		if m {
+			migrated = append(migrated, root)
+		}
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs[root] = err
+			}
+			continue
+		}
+		for id, n := range part.Nodes {
+			// A node belongs to the innermost root; nested roots may both
+			// have indexed it
+			if w.RootOf(n.Path) == root {
+				nodes[id] = n
+			}
+		}
+	}
+	ann := w.Graph.loadANN(w.sharedIndexPath(), nodes)
+	w.Graph.mu.Lock()
+	w.Graph.Nodes = nodes
+	w.Graph.gen++
+	w.Graph.lexical = nil
+	w.Graph.links = nil
+	w.Graph.ann = ann
+	w.Graph.mu.Unlock()
+	return migrated, errs
+}
+
+// Save writes each root's nodes to its own index. It is safe to call from
+// several goroutines.
+func (w *Workspace) Save() error {
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+	if len(w.Roots) == 1 {
+		return w.Graph.Save(w.IndexPath(w.Roots[0]))
+	}
+	parts := make(map[string]*Graph, len(w.Roots))
+	for _, root := range w.Roots {
+		parts[root] = &Graph{Nodes: make(map[string]*Node)}
+	}
+	for _, n := range w.Graph.Snapshot() {
+		if part, ok := parts[w.RootOf(n.Path)]; ok {
+			part.Nodes[n.ID] = n
+		}
+	}
+	for _, root := range w.Roots {
+		// The parts have no ANN index, so this also drops a sidecar left
+		// from when the root was indexed alone
+		if err := parts[root].Save(w.IndexPath(root)); err != nil {
+			return fmt.Errorf("%s: %w", root, err)
+		}
+	}
+	return w.Graph.saveANN(w.sharedIndexPath())
+}
+
+// Update brings every root up to date, returning a summary per root
+func (w *Workspace) Update(opts UpdateOptions) (map[string]*UpdateSummary, error) {
+	summaries := make(map[string]*UpdateSummary)
+	for _, root := range w.Roots {
+		summary, err := w.Graph.UpdateWith(root, opts)
+		if err != nil {
+			return summaries, fmt.Errorf("%s: %w", root, err)
+		}
+		summaries[root] = summary
+	}
+	return summaries, nil
+}
+
+// projectDir is the project root that node paths are relative to, and
+// workDir the directory craft runs in, at or below it
+var (
+	projectDir = sync.OnceValue(config.ProjectRoot)
+	workDir    = sync.OnceValue(func() string {
+		dir, _ := os.Getwd()
+		return dir
+	})
+)
+
+// projectAbs resolves a path relative to the project root for file access
+func projectAbs(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(projectDir(), path)
+}
+
+// walkProject walks the tree under root like filepath.Walk, passing fn
+// paths relative to the project root
+func walkProject(root string, fn filepath.WalkFunc) error {
+	dir := projectDir()
+	return filepath.Walk(projectAbs(root), func(path string, info os.FileInfo, err error) error {
+		if rel, relErr := filepath.Rel(dir, path); relErr == nil {
+			path = rel
+		}
+		return fn(path, info, err)
+	})
+}
+
+// ProjectRel turns a path relative to the working directory, or an
+// absolute one, into a path relative to the project root
+func ProjectRel(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workDir(), path)
+	}
+	root := projectDir()
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !within(".", rel) {
+		return "", fmt.Errorf("%s is outside the project at %s", path, root)
+	}
+	return rel, nil
+}
+
+// DisplayPath turns a path relative to the project root into one relative
+// to the working directory, so it can be passed to file tools and shell
+// commands as shown. Other paths are returned unchanged.
+func DisplayPath(path string) string {
+	if filepath.IsAbs(path) || projectDir() == workDir() {
+		return path
+	}
+	rel, err := filepath.Rel(workDir(), projectAbs(path))
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// ProjectPattern turns a path filter given relative to the working
+// directory into one relative to the project root (see SearchFilter.Path).
+// Patterns without a slash match base names anywhere and are kept as is.
+func ProjectPattern(pattern string) string {
+	if pattern == "" || (!strings.Contains(filepath.ToSlash(pattern), "/") && !isDirectory(pattern)) {
+		return pattern
+	}
+	if rel, err := ProjectRel(pattern); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return pattern
+}
+
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Package is a unit of a monorepo: a directory with a go.mod,
+// package.json, Cargo.toml or pyproject.toml
+type Package struct {
+	Dir  string // relative to the project root
+	Name string // declared name or module path, or the directory name
+}
+
+// packageManifests are the files that make a directory a package, in the
+// order their names are preferred
+var packageManifests = []string{"go.mod", "package.json", "Cargo.toml", "pyproject.toml"}
+
+var (
+	tomlName   = regexp.MustCompile(`(?m)^\s*name\s*=\s*"([^"]+)"`)
+	goModuleRe = regexp.MustCompile(`(?m)^\s*module\s+"?([^"\s]+)"?`)
+)
+
+type packageCache struct {
+	mu   sync.Mutex
+	dirs map[string]*Package // nil entries: not a package directory
+}
+
+var packages = &packageCache{dirs: make(map[string]*Package)}
+
+// PackageOf returns the package containing path, or nil
+func PackageOf(path string) *Package {
+	for dir := filepath.Dir(filepath.Clean(path)); ; dir = filepath.Dir(dir) {
+		if pkg := packages.at(dir); pkg != nil {
+			return pkg
+		}
+		if dir == "." || dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+// at returns the package rooted at dir, or nil
+func (c *packageCache) at(dir string) *Package {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pkg, ok := c.dirs[dir]; ok {
+		return pkg
+	}
+	var pkg *Package
+	for _, manifest := range packageManifests {
+		data, err := os.ReadFile(projectAbs(filepath.Join(dir, manifest)))
+		if err != nil {
+			continue
+		}
+		pkg = &Package{Dir: dir, Name: manifestName(manifest, data)}
+		if pkg.Name == "" {
+			pkg.Name = filepath.Base(dir)
+		}
+		break
+	}
+	c.dirs[dir] = pkg
+	return pkg
+}
+
+func manifestName(manifest string, data []byte) string {
+	switch manifest {
+	case "go.mod":
+		if m := goModuleRe.FindSubmatch(data); m != nil {
+			return string(m[1])
+		}
+	case "package.json":
+		var pkg struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(data, &pkg) == nil {
+			return pkg.Name
+		}
+	default:
+		if m := tomlName.FindSubmatch(data); m != nil {
+			return string(m[1])
+		}
+	}
+	return ""
+}
+
+// matches reports whether the package is the one a filter names: by
+// directory, declared name or last path element of either
+func (p *Package) matches(name string) bool {
+	name = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(name)), "/")
+	dir := filepath.ToSlash(p.Dir)
+	return name == dir || name == p.Name || name == filepath.Base(dir) || name == p.Name[strings.LastIndex(p.Name, "/")+1:]
+}
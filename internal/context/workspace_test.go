@@ -0,0 +1,208 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"craft-cli/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	// Tests run in the package directory; keep it from resolving to the
+	// repository around it
+	cwd, _ := os.Getwd()
+	projectDir = func() string { return cwd }
+	workDir = projectDir
+	os.Exit(m.Run())
+}
+
+// inSubdir makes dir, below a new project root, the working directory for
+// the paths of the test
+func inSubdir(t *testing.T, dir string) string {
+	t.Helper()
+	root := t.TempDir()
+	work := filepath.Join(root, dir)
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(work)
+	oldProject, oldWork := projectDir, workDir
+	projectDir = func() string { return root }
+	workDir = func() string { return work }
+	t.Cleanup(func() { projectDir, workDir = oldProject, oldWork })
+	return root
+}
+
+func TestProjectRel(t *testing.T) {
+	root := inSubdir(t, "services/api")
+	tests := []struct {
+		path, want string
+		fails      bool
+	}{
+		{"main.go", "services/api/main.go", false},
+		{".", "services/api", false},
+		{"../web/app.ts", "services/web/app.ts", false},
+		{filepath.Join(root, "README.md"), "README.md", false},
+		{"../../..", "", true},
+		{"/elsewhere/file", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ProjectRel(tt.path)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("ProjectRel(%q) = %q, want an error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("ProjectRel(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestDisplayPath(t *testing.T) {
+	inSubdir(t, "services/api")
+	tests := []struct{ path, want string }{
+		{"services/api/main.go", "main.go"},
+		{"services/web/app.ts", "../web/app.ts"},
+		{"README.md", "../../README.md"},
+		{"/abs/memory.md", "/abs/memory.md"},
+	}
+	for _, tt := range tests {
+		if got := DisplayPath(filepath.FromSlash(tt.path)); got != filepath.FromSlash(tt.want) {
+			t.Errorf("DisplayPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	n := &Node{Path: filepath.FromSlash("services/api/main.go"), StartLine: 3, EndLine: 9}
+	if got := n.Location(); got != "main.go:3-9" {
+		t.Errorf("Location = %q, want main.go:3-9", got)
+	}
+}
+
+func TestProjectPattern(t *testing.T) {
+	inSubdir(t, "services/api")
+	if err := os.Mkdir("handlers", 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ pattern, want string }{
+		{"", ""},
+		// Base name patterns match anywhere
+		{"*_test.go", "*_test.go"},
+		{"handlers", "services/api/handlers"},
+		{"internal/**/*.go", "services/api/internal/**/*.go"},
+		{"../web/**", "services/web/**"},
+		{".", "services/api"},
+	}
+	for _, tt := range tests {
+		if got := ProjectPattern(tt.pattern); got != tt.want {
+			t.Errorf("ProjectPattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateFromSubdir(t *testing.T) {
+	root := inSubdir(t, "sub")
+	for path, content := range map[string]string{
+		"top.go":          "package top\n",
+		"sub/low.go":      "package sub\n",
+		".gitignore":      "ignored.txt\n",
+		"sub/ignored.txt": "not indexed\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := lexicalGraph()
+	summary, err := g.Update(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]bool)
+	for _, n := range g.Snapshot() {
+		paths[n.Path] = true
+	}
+	if !paths["top.go"] || !paths[filepath.Join("sub", "low.go")] || paths[filepath.Join("sub", "ignored.txt")] {
+		t.Errorf("indexed %v (%s), want the whole project relative to its root, without ignored files", paths, summary)
+	}
+}
+
+func TestRepoMapFollowsGraph(t *testing.T) {
+	root := inSubdir(t, ".")
+	w := &Workspace{Roots: []string{"."}, Graph: lexicalGraph()}
+	if err := w.Graph.AddFile("a.go", "package a\n\nfunc Alpha() {}\n"); err != nil {
+		t.Fatal(err)
+	}
+	first := w.RepoMap(500)
+	if !strings.Contains(first, "Alpha") {
+		t.Fatalf("repo map lacks Alpha:\n%s", first)
+	}
+	if _, err := os.Stat(repoMapPath(filepath.Join(root, config.Get().Index.Path))); err != nil {
+		t.Errorf("repo map not cached next to the index: %v", err)
+	}
+
+	if err := w.Graph.AddFile("b.go", "package a\n\nfunc Beta() {}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if m := w.RepoMap(500); !strings.Contains(m, "Beta") {
+		t.Errorf("repo map not rebuilt after the graph changed:\n%s", m)
+	}
+}
+
+func TestWorkspaceANNPersists(t *testing.T) {
+	inSubdir(t, ".")
+	roots := []string{"api", "web"}
+	for _, r := range roots {
+		if err := os.Mkdir(r, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corpus, _ := annCorpus(40, 8, 0)
+	newWorkspace := func() *Workspace {
+		w := &Workspace{Roots: roots, Graph: lexicalGraph()}
+		w.Graph.SetANN(testANNParams, 10)
+		return w
+	}
+
+	w := newWorkspace()
+	for i, v := range corpus {
+		id := fmt.Sprintf("%s/f%d.go#1", roots[i%2], i)
+		w.Graph.Nodes[id] = &Node{ID: id, Path: strings.Split(id, "#")[0], Content: "x", Embedding: v, Model: "test"}
+	}
+	built := w.Graph.buildANN("test", 8)
+	if built == nil || built.Len() != len(corpus) {
+		t.Fatal("ANN index not built over both roots")
+	}
+	if err := w.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = newWorkspace()
+	if _, errs := w.Load(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if w.Graph.Len() != len(corpus) {
+		t.Fatalf("loaded %d nodes, want %d", w.Graph.Len(), len(corpus))
+	}
+	if w.Graph.ann == nil || w.Graph.ann.Len() != len(corpus) {
+		t.Fatal("the ANN index of the workspace was not loaded")
+	}
+	q := corpus[3]
+	if res := w.Graph.ann.search(q, 1, testANNParams.EfSearch); len(res) != 1 || res[0].id != "web/f3.go#1" {
+		t.Errorf("loaded index finds %v for a stored point", res)
+	}
+
+	// A sidecar that no longer covers every embedded node is not used
+	delete(w.Graph.Nodes, "api/f0.go#1")
+	if err := w.Save(); err != nil {
+		t.Fatal(err)
+	}
+	w = newWorkspace()
+	w.Load()
+	if w.Graph.ann != nil && w.Graph.ann.Len() != w.Graph.Len() {
+		t.Errorf("stale ANN index of %d points loaded for %d nodes", w.Graph.ann.Len(), w.Graph.Len())
+	}
+}
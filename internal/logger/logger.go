@@ -0,0 +1,293 @@
+// Package logger writes craft's diagnostic log. Records have a level, a
+// message and key/value fields and are written as text lines
+//
+//	[23:08:58] INFO: tool finished turn=2 tool=bash duration_ms=812
+//
+// or as one JSON object per line. The file lives under the user state
+// directory and is rotated by size, keeping a fixed number of old files.
+// Settings come from the [log] section of the config; the level can be
+// changed while running with SetLevel.
+//
+// Until Init is called, and after Close, records are discarded.
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"craft-cli/internal/config"
+)
+
+// Well-known field keys, so the same fact is named the same everywhere
+const (
+	KeySession  = "session"
+	KeyTurn     = "turn"
+	KeyTool     = "tool"
+	KeyDuration = "duration_ms"
+	KeyTokens   = "tokens"
+	KeyError    = "error"
+)
+
+// Turn, Tool, Duration, Tokens and Err build the well-known fields
+func Turn(n int) slog.Attr               { return slog.Int(KeyTurn, n) }
+func Tool(name string) slog.Attr         { return slog.String(KeyTool, name) }
+func Duration(d time.Duration) slog.Attr { return slog.Int64(KeyDuration, d.Milliseconds()) }
+func Tokens(n int) slog.Attr             { return slog.Int(KeyTokens, n) }
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.Attr{} // dropped by the handlers
+	}
+	return slog.String(KeyError, err.Error())
+}
+
+// Options configures Init; the zero value of a field means its default
+type Options struct {
+	Level    string // debug, info, warn or error
+	Format   string // text or json
+	Path     string // log file; DefaultPath when empty
+	MaxSize  int64  // rotate when the file would grow past this many bytes (0 = never)
+	MaxFiles int    // rotated files kept (craft.log.1 is the newest)
+}
+
+var (
+	level = new(slog.LevelVar)
+
+	mu      sync.Mutex
+	current = slog.New(slog.DiscardHandler)
+	file    *rotatingFile
+	path    string
+	format  string
+	session string
+)
+
+// Init opens the log with the [log] settings of the loaded configuration
+func Init() error {
+	c := config.Get().Log
+	return InitWith(Options{Level: c.Level, Format: c.Format, Path: c.Path, MaxSize: int64(c.MaxSize), MaxFiles: c.MaxFiles})
+}
+
+// InitWith opens the log file and starts a new session, closing any log
+// opened before
+func InitWith(opts Options) error {
+	Close()
+	if opts.Level != "" {
+		if err := SetLevel(opts.Level); err != nil {
+			return err
+		}
+	}
+	if opts.Path == "" {
+		opts.Path = DefaultPath()
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := openRotating(opts.Path, opts.MaxSize, opts.MaxFiles)
+	if err != nil {
+		return err
+	}
+
+	id := newSessionID()
+	var l *slog.Logger
+	switch opts.Format {
+	case "", "text":
+		l = slog.New(&textHandler{out: f, mu: new(sync.Mutex)}).With(KeySession, id)
+		fmt.Fprintf(f, "--- SESSION STARTED: %s (%s) ---\n", time.Now().Format(time.RFC3339), id)
+	case "json":
+		l = slog.New(slog.NewJSONHandler(f, &slog.HandlerOptions{Level: level})).With(KeySession, id)
+		l.Info("session started")
+	default:
+		f.Close()
+		return fmt.Errorf("unknown log format %q (want text or json)", opts.Format)
+	}
+
+	mu.Lock()
+	current, file, path, format, session = l, f, opts.Path, opts.Format, id
+	mu.Unlock()
+	return nil
+}
+
+// Close ends the session and closes the log file
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return
+	}
+	if format == "json" {
+		current.Info("session ended")
+	} else {
+		fmt.Fprintf(file, "--- SESSION ENDED: %s ---\n", time.Now().Format(time.RFC3339))
+	}
+	file.Close()
+	current, file, path, format, session = slog.New(slog.DiscardHandler), nil, "", "", ""
+}
+
+// DefaultPath is craft/craft.log under $XDG_STATE_HOME, or ~/.local/state
+// when it is not set
+func DefaultPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(os.TempDir(), "craft", "craft.log")
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "craft", "craft.log")
+}
+
+// ParseLevel accepts debug, info, warn (or warning) and error
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+// SetLevel changes the minimum level written, effective immediately
+func SetLevel(s string) error {
+	l, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level returns the current minimum level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SessionID identifies the current session in every record, or is empty
+// before Init
+func SessionID() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return session
+}
+
+// Path returns the file being written, or "" before Init
+func Path() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return path
+}
+
+// L returns the session logger, for callers that attach fields once with
+// With and log several records
+func L() *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// With returns the session logger with fields added to every record
+func With(fields ...any) *slog.Logger {
+	return L().With(fields...)
+}
+
+// Debug, Info, Warn and Error log msg with key/value pairs or slog.Attr
+// fields
+func Debug(msg string, fields ...any) { L().Debug(msg, fields...) }
+func Info(msg string, fields ...any)  { L().Info(msg, fields...) }
+func Warn(msg string, fields ...any)  { L().Warn(msg, fields...) }
+func Error(msg string, fields ...any) { L().Error(msg, fields...) }
+
+// Debugf, Infof, Warnf and Errorf log a formatted message without fields
+func Debugf(format string, args ...any) { logf(slog.LevelDebug, format, args...) }
+func Infof(format string, args ...any)  { logf(slog.LevelInfo, format, args...) }
+func Warnf(format string, args ...any)  { logf(slog.LevelWarn, format, args...) }
+func Errorf(format string, args ...any) { logf(slog.LevelError, format, args...) }
+
+func logf(l slog.Level, format string, args ...any) {
+	logger := L()
+	if logger.Enabled(context.Background(), l) {
+		logger.Log(context.Background(), l, fmt.Sprintf(format, args...))
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// textHandler writes "[15:04:05] LEVEL: message key=value ..." lines
+type textHandler struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	attrs  string // preformatted fields from WithAttrs
+	prefix string // group prefix from WithGroup
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s: %s%s", r.Time.Format("15:04:05"), r.Level, r.Message, h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.prefix, a)
+		return true
+	})
+	b.WriteString("\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		writeAttr(&b, h.prefix, a)
+	}
+	next := *h
+	next.attrs += b.String()
+	return &next
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.prefix += name + "."
+	return &next
+}
+
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, sub := range a.Value.Group() {
+			writeAttr(b, prefix+a.Key+".", sub)
+		}
+		return
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, value)
+}
@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTextFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "craft.log")
+	if err := InitWith(Options{Path: path, Level: "info"}); err != nil {
+		t.Fatal(err)
+	}
+	id := SessionID()
+	Info("tool finished", Turn(2), Tool("bash"), "note", "two words", Err(nil))
+	Warn("model call failed", Err(errors.New("boom")))
+	Debug("hidden")
+	Close()
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, path)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("log has %d lines, want a session start and end around two records:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	want := []string{
+		`INFO: tool finished session=` + id + ` turn=2 tool=bash note="two words"`,
+		`WARN: model call failed session=` + id + ` error=boom`,
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(lines[i+1], w) {
+			t.Errorf("line %d = %q, want it to end with %q", i+2, lines[i+1], w)
+		}
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "craft.log")
+	if err := InitWith(Options{Path: path, Format: "json", Level: "info"}); err != nil {
+		t.Fatal(err)
+	}
+	id := SessionID()
+	Info("tool finished", Tool("bash"), Err(nil))
+	Close()
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, path)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("log has %d lines, want 3", len(lines))
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["msg"] != "tool finished" || rec[KeySession] != id || rec[KeyTool] != "bash" {
+		t.Errorf("record = %v", rec)
+	}
+	if _, ok := rec[KeyError]; ok {
+		t.Errorf("nil error logged: %v", rec)
+	}
+}
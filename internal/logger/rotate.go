@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rotatingFile appends to a log file and, once a write would take it past
+// maxSize, renames it to path.1 (shifting older files up) and starts a new
+// one. Files beyond path.maxFiles are deleted.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func openRotating(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: max(maxFiles, 0)}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.N to path.N+1, drops the ones past maxFiles and
+// reopens path empty; r.mu must be held
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+	if r.maxFiles > 0 {
+		os.Remove(r.backup(r.maxFiles))
+	}
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		os.Rename(r.backup(i), r.backup(i+1))
+	}
+	if r.maxFiles > 0 {
+		os.Rename(r.path, r.backup(1))
+	} else {
+		os.Remove(r.path)
+	}
+	// Leftovers from a larger max_files
+	old, _ := filepath.Glob(r.path + ".*")
+	for _, path := range old {
+		if n, err := strconv.Atoi(strings.TrimPrefix(path, r.path+".")); err == nil && n > r.maxFiles {
+			os.Remove(path)
+		}
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "craft.log")
+	// A file from an earlier run with more backups
+	if err := os.WriteFile(path+".5", []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openRotating(path, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// Each line is 10 bytes, so every file holds two
+	for i := 0; i < 7; i++ {
+		if _, err := fmt.Fprintf(r, "line %04d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "line 0006\n",
+		path + ".1": "line 0004\nline 0005\n",
+		path + ".2": "line 0002\nline 0003\n",
+	}
+	for p, content := range want {
+		if got := readFile(t, p); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(p), got, content)
+		}
+	}
+	for _, p := range []string{path + ".3", path + ".5"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s kept past max_files", filepath.Base(p))
+		}
+	}
+}
+
+func TestRotateWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "craft.log")
+	r, err := openRotating(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Write([]byte("first\n"))
+	r.Write([]byte("second\n"))
+	if got := readFile(t, path); got != "second\n" {
+		t.Errorf("log = %q, want only the line after the rotation", got)
+	}
+	if old, _ := filepath.Glob(path + ".*"); len(old) != 0 {
+		t.Errorf("backups %v kept with max_files 0", old)
+	}
+}
+
+func TestRotateResumesSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "craft.log")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 15)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The existing 16 bytes count towards the limit
+	r, err := openRotating(path, 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Write([]byte("new line\n"))
+	if got := readFile(t, path); got != "new line\n" {
+		t.Errorf("log = %q, want a rotation before the first write", got)
+	}
+	if got := readFile(t, path+".1"); len(got) != 16 {
+		t.Errorf("backup = %q, want the old content", got)
+	}
+}
+
+// A single write larger than the limit still goes to the file
+func TestRotateLargeWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "craft.log")
+	r, err := openRotating(path, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if n, err := r.Write([]byte("a long line\n")); err != nil || n != 12 {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if got := readFile(t, path); got != "a long line\n" {
+		t.Errorf("log = %q", got)
+	}
+	r.Close()
+	if _, err := r.Write([]byte("x")); err != os.ErrClosed {
+		t.Errorf("Write after Close = %v, want os.ErrClosed", err)
+	}
+}
@@ -0,0 +1,304 @@
+package mockllm_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"craft-cli/internal/mockllm"
+)
+
+// craftBin is the headless craft binary built from craft.go by TestMain
+var craftBin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "craft-agent-test")
+	if err != nil {
+		panic(err)
+	}
+	craftBin = filepath.Join(dir, "craft")
+	build := exec.Command("go", "build", "-o", craftBin, "craft.go")
+	build.Dir = filepath.Join("..", "..")
+	if out, err := build.CombinedOutput(); err != nil {
+		os.Stderr.Write(out)
+		craftBin = ""
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// result is the final object of -output-format json
+type result struct {
+	Result   string `json:"result"`
+	IsError  bool   `json:"is_error"`
+	Error    string `json:"error"`
+	NumTurns int    `json:"num_turns"`
+}
+
+// event is an output line of -output-format stream-json, an Event or the
+// final Result
+type event struct {
+	Type      string `json:"type"`
+	Turn      int    `json:"turn"`
+	Content   string `json:"content"`
+	Tool      string `json:"tool"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	Usage     *struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// craft answers prompt headlessly against srv in a fresh project with the
+// given .craft/config.toml, and returns its stdout, exit code and stderr
+func craft(t *testing.T, srv *mockllm.Server, config, prompt string, args ...string) ([]byte, int, string) {
+	t.Helper()
+	if craftBin == "" {
+		t.Fatal("craft.go did not build")
+	}
+	project, state := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(project, ".craft"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, ".craft", "config.toml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, "note.txt"), []byte("the answer is 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(craftBin, append([]string{"-p", prompt, "-no-context"}, args...)...)
+	cmd.Dir = project
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + state,
+		"XDG_STATE_HOME=" + filepath.Join(state, "state"),
+		"XDG_CONFIG_HOME=" + filepath.Join(state, "config"),
+		"GROQ_API_KEY=test",
+		"GROQ_BASE_URL=" + srv.URL + "/v1",
+		"CRAFT_EMBEDDING_PROVIDER=none",
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out, code, stderr.String()
+}
+
+// runCraft is craft with -output-format json, returning the result
+func runCraft(t *testing.T, srv *mockllm.Server, config, prompt string, args ...string) (result, int, string) {
+	t.Helper()
+	out, code, stderr := craft(t, srv, config, prompt, append([]string{"-output-format", "json"}, args...)...)
+	var res result
+	if err := json.Unmarshal(out, &res); err != nil {
+		t.Fatalf("output is not a result: %v\n%s\nstderr: %s", err, out, stderr)
+	}
+	return res, code, stderr
+}
+
+func TestAgentToolRoundTrip(t *testing.T) {
+	srv := mockllm.NewServer(mockllm.MustParse(`
+turn 1: tool_call read_file(path="note.txt")
+turn 2: text "it says 42"
+`))
+	defer srv.Close()
+
+	res, code, stderr := runCraft(t, srv, "", "what does note.txt say?")
+	if code != 0 || res.IsError || res.Result != "it says 42" || res.NumTurns != 2 {
+		t.Fatalf("exit %d, result %+v\nstderr: %s", code, res, stderr)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("%d requests, want 2", len(reqs))
+	}
+	if names := reqs[0].ToolNames(); !slices.Contains(names, "read_file") {
+		t.Errorf("tools offered = %v, want read_file among them", names)
+	}
+	last := reqs[1].Messages[len(reqs[1].Messages)-1]
+	if last.Role != "tool" || last.ToolCallID != "call_1_0" || last.Content != "the answer is 42\n" {
+		t.Errorf("tool result sent back = %+v", last)
+	}
+}
+
+// toolResult returns the tool message answering call id in a request
+func toolResult(req mockllm.Request, id string) (mockllm.Message, bool) {
+	for _, m := range req.Messages {
+		if m.Role == "tool" && m.ToolCallID == id {
+			return m, true
+		}
+	}
+	return mockllm.Message{}, false
+}
+
+func TestAgentUnknownTool(t *testing.T) {
+	srv := mockllm.NewServer(mockllm.MustParse(`
+turn 1: tool_call launch_rockets(count=3)
+turn 2: tool_call read_file(path="note.txt")
+turn 3: text "no rockets, but it says 42"
+`))
+	defer srv.Close()
+
+	res, code, stderr := runCraft(t, srv, "", "launch the rockets")
+	if code != 0 || res.IsError || res.Result != "no rockets, but it says 42" || res.NumTurns != 3 {
+		t.Fatalf("exit %d, result %+v\nstderr: %s", code, res, stderr)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("%d requests, want 3", len(reqs))
+	}
+	if m, ok := toolResult(reqs[1], "call_1_0"); !ok || m.Content != "Unknown tool: launch_rockets" {
+		t.Errorf("result of the unknown tool = %+v, want it reported to the model", m)
+	}
+	if m, ok := toolResult(reqs[2], "call_2_0"); !ok || m.Content != "the answer is 42\n" {
+		t.Errorf("result of the next call = %+v", m)
+	}
+}
+
+func TestAgentMalformedArguments(t *testing.T) {
+	srv := mockllm.NewServer(mockllm.NewScenario().
+		RawToolCall(1, "read_file", `{"path": "note.txt"`).
+		ToolCall(2, "read_file", map[string]interface{}{"path": "note.txt"}).
+		Text(3, "it says 42"))
+	defer srv.Close()
+
+	res, code, stderr := runCraft(t, srv, "", "what does note.txt say?")
+	if code != 0 || res.IsError || res.Result != "it says 42" || res.NumTurns != 3 {
+		t.Fatalf("exit %d, result %+v\nstderr: %s", code, res, stderr)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("%d requests, want 3", len(reqs))
+	}
+	if m, ok := toolResult(reqs[1], "call_1_0"); !ok || !strings.HasPrefix(m.Content, "Error parsing arguments: ") {
+		t.Errorf("result of the malformed call = %+v, want the parse error reported to the model", m)
+	}
+	if m, ok := toolResult(reqs[2], "call_2_0"); !ok || m.Content != "the answer is 42\n" {
+		t.Errorf("result of the retried call = %+v", m)
+	}
+}
+
+func TestAgentStreamJSON(t *testing.T) {
+	srv := mockllm.NewServer(mockllm.MustParse(`
+turn 1: tool_call read_file(path="note.txt")
+turn 2: text "the note says the answer is 42"
+`))
+	defer srv.Close()
+
+	out, code, stderr := craft(t, srv, "", "what does note.txt say?", "-output-format", "stream-json")
+	if code != 0 {
+		t.Fatalf("exit %d\nstderr: %s", code, stderr)
+	}
+	var events []event
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var e event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("output line %d: %v\n%s", len(events)+1, err, out)
+		}
+		events = append(events, e)
+	}
+
+	var types []string
+	var streamed string
+	deltas := 0
+	for _, e := range events {
+		types = append(types, e.Type)
+		if e.Type == "assistant_delta" {
+			if e.Turn != 2 {
+				t.Errorf("delta %q in turn %d, want 2", e.Content, e.Turn)
+			}
+			streamed += e.Content
+			deltas++
+		}
+	}
+	want := []string{"turn_start", "usage", "tool_call", "tool_result", "turn_start"}
+	if len(types) < len(want) || !slices.Equal(types[:len(want)], want) {
+		t.Fatalf("events %v, want %v first", types, want)
+	}
+	if call := events[2]; call.Tool != "read_file" || call.Arguments != `{"path":"note.txt"}` {
+		t.Errorf("tool_call = %+v, want the streamed call put back together", call)
+	}
+	if deltas < 2 || streamed != "the note says the answer is 42" {
+		t.Errorf("%d deltas streaming %q", deltas, streamed)
+	}
+	// The deltas come first, then the usage and the whole message
+	last := events[len(events)-3:]
+	if last[0].Type != "usage" || last[0].Usage == nil || last[0].Usage.TotalTokens == 0 ||
+		last[1].Type != "assistant" || last[1].Content != streamed ||
+		last[2].Type != "result" || last[2].Result != streamed {
+		t.Errorf("events end with %+v", last)
+	}
+	for _, r := range srv.Requests() {
+		if !r.Stream || !r.StreamOptions.IncludeUsage {
+			t.Errorf("turn %d request did not ask for a stream with usage", r.Turn)
+		}
+	}
+}
+
+func TestAgentMaxTurns(t *testing.T) {
+	srv := mockllm.NewServer(mockllm.MustParse(`turn *: tool_call list_dir(path=.)`))
+	defer srv.Close()
+
+	res, code, _ := runCraft(t, srv, "", "loop forever", "-max-turns", "3")
+	if code != 1 || !res.IsError || res.NumTurns != 3 || !strings.Contains(res.Error, "stopped after 3 turns") {
+		t.Errorf("exit %d, result %+v, want the 3-turn limit error", code, res)
+	}
+	if srv.Turns() != 3 {
+		t.Errorf("server saw %d requests, want 3", srv.Turns())
+	}
+}
+
+func TestAgentRateLimitFallback(t *testing.T) {
+	srv := mockllm.NewServer(mockllm.MustParse(`
+turn 1 model=primary: error 429 "rate limit reached"
+turn 2 model=backup: text "answered by the fallback"
+`))
+	defer srv.Close()
+
+	res, code, stderr := runCraft(t, srv, `
+[models]
+default = "primary"
+fallback = ["backup"]
+`, "hello")
+	if code != 0 || res.Result != "answered by the fallback" || res.NumTurns != 1 {
+		t.Fatalf("exit %d, result %+v\nstderr: %s", code, res, stderr)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 2 || reqs[0].Model != "primary" || reqs[1].Model != "backup" {
+		t.Errorf("requests went to %v, want primary then backup", models(reqs))
+	}
+}
+
+func TestAgentRateLimitExhausted(t *testing.T) {
+	srv := mockllm.NewServer(mockllm.MustParse(`turn *: error 429 "rate limit reached"`))
+	defer srv.Close()
+
+	res, code, _ := runCraft(t, srv, `
+[models]
+default = "primary"
+fallback = ["backup"]
+`, "hello")
+	if code != 1 || !res.IsError || !strings.Contains(res.Error, "rate limit reached") || srv.Turns() != 2 {
+		t.Errorf("exit %d, result %+v after %d requests, want the 429 after trying both models", code, res, srv.Turns())
+	}
+}
+
+func models(reqs []mockllm.Request) []string {
+	var out []string
+	for _, r := range reqs {
+		out = append(out, r.Model)
+	}
+	return out
+}
@@ -0,0 +1,310 @@
+// Package mockllm is a scriptable, OpenAI-compatible chat completions server
+// for end-to-end agent tests. Responses are driven by a Scenario, written
+// either with the builder methods or in a small line-based DSL:
+//
+//	# comments and blank lines are ignored
+//	turn 1: tool_call read_file(path="main.go")
+//	turn 2: text "main.go defines the entry point"
+//	turn 3-5 model=llama-3.3-70b-versatile: error 429 "rate limit reached"
+//	turn *: tool_call list_dir(path=.)
+//	turn 6: tool_call read_file raw "{\"path\": "
+//
+// A raw tool call sends its arguments verbatim, so a scenario can hand the
+// agent malformed JSON.
+//
+// Several lines for the same turn are combined into one response, so a turn
+// can return text and multiple tool calls together. Exact turns win over
+// ranges, ranges win over `turn *`.
+package mockllm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Step kinds
+const (
+	KindText     = "text"
+	KindToolCall = "tool_call"
+	KindError    = "error"
+)
+
+// Step is one scripted piece of a model response
+type Step struct {
+	From  int    // first turn (1-based); 0 matches any turn
+	To    int    // last turn, inclusive
+	Model string // only match requests for this model when set
+
+	Kind   string
+	Text   string                 // text content or error message
+	Tool   string                 // tool name for tool calls
+	Args   map[string]interface{} // tool call arguments
+	Raw    string                 // tool call arguments sent verbatim instead of Args
+	Status int                    // HTTP status for errors
+}
+
+func (s Step) matches(turn int, model string) bool {
+	if s.Model != "" && s.Model != model {
+		return false
+	}
+	return s.From == 0 || (turn >= s.From && turn <= s.To)
+}
+
+// specificity ranks how precisely a step selects its turn
+func (s Step) specificity() int {
+	switch {
+	case s.From == 0:
+		return 0
+	case s.From != s.To:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Scenario is an ordered list of scripted steps
+type Scenario struct {
+	Steps []Step
+}
+
+// NewScenario creates an empty scenario
+func NewScenario() *Scenario {
+	return &Scenario{}
+}
+
+// Text makes the model answer with text on the given turn (0 for any turn)
+func (s *Scenario) Text(turn int, text string) *Scenario {
+	s.Steps = append(s.Steps, Step{From: turn, To: turn, Kind: KindText, Text: text})
+	return s
+}
+
+// ToolCall makes the model request a tool on the given turn (0 for any turn)
+func (s *Scenario) ToolCall(turn int, tool string, args map[string]interface{}) *Scenario {
+	s.Steps = append(s.Steps, Step{From: turn, To: turn, Kind: KindToolCall, Tool: tool, Args: args})
+	return s
+}
+
+// RawToolCall makes the model request a tool with arguments sent as they
+// are, valid JSON or not
+func (s *Scenario) RawToolCall(turn int, tool string, arguments string) *Scenario {
+	s.Steps = append(s.Steps, Step{From: turn, To: turn, Kind: KindToolCall, Tool: tool, Raw: arguments})
+	return s
+}
+
+// Error makes the server fail the given turn (0 for any turn) with an HTTP status
+func (s *Scenario) Error(turn int, status int, message string) *Scenario {
+	s.Steps = append(s.Steps, Step{From: turn, To: turn, Kind: KindError, Status: status, Text: message})
+	return s
+}
+
+// ForModel restricts the most recently added step to requests for model
+func (s *Scenario) ForModel(model string) *Scenario {
+	if len(s.Steps) > 0 {
+		s.Steps[len(s.Steps)-1].Model = model
+	}
+	return s
+}
+
+// stepsFor returns the steps answering a turn, keeping only the most specific selector
+func (s *Scenario) stepsFor(turn int, model string) []Step {
+	best := -1
+	var out []Step
+	for _, st := range s.Steps {
+		if !st.matches(turn, model) {
+			continue
+		}
+		switch sp := st.specificity(); {
+		case sp > best:
+			best = sp
+			out = []Step{st}
+		case sp == best:
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+// Parse reads a scenario written in the mockllm DSL
+func Parse(src string) (*Scenario, error) {
+	sc := NewScenario()
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		step, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("scenario line %d: %w", lineNo, err)
+		}
+		sc.Steps = append(sc.Steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// MustParse is like Parse but panics on error, for use in test setup
+func MustParse(src string) *Scenario {
+	sc, err := Parse(src)
+	if err != nil {
+		panic(err)
+	}
+	return sc
+}
+
+func parseLine(line string) (Step, error) {
+	var step Step
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return step, fmt.Errorf("expected \"turn N: action\", got %q", line)
+	}
+	if err := parseSelector(strings.Fields(line[:colon]), &step); err != nil {
+		return step, err
+	}
+
+	action := strings.TrimSpace(line[colon+1:])
+	kind, rest, _ := strings.Cut(action, " ")
+	rest = strings.TrimSpace(rest)
+	step.Kind = kind
+
+	switch kind {
+	case KindText:
+		text, err := unquote(rest)
+		if err != nil {
+			return step, err
+		}
+		step.Text = text
+	case KindToolCall:
+		if name, raw, ok := strings.Cut(rest, " raw "); ok && !strings.Contains(name, "(") {
+			text, err := unquote(strings.TrimSpace(raw))
+			if err != nil {
+				return step, err
+			}
+			step.Tool, step.Raw = strings.TrimSpace(name), text
+			break
+		}
+		name, args, err := parseCall(rest)
+		if err != nil {
+			return step, err
+		}
+		step.Tool, step.Args = name, args
+	case KindError:
+		code, msg, _ := strings.Cut(rest, " ")
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 400 || status > 599 {
+			return step, fmt.Errorf("error needs an HTTP status between 400 and 599, got %q", code)
+		}
+		if msg, err = unquote(strings.TrimSpace(msg)); err != nil {
+			return step, err
+		}
+		step.Status, step.Text = status, msg
+	default:
+		return step, fmt.Errorf("unknown action %q (want text, tool_call or error)", kind)
+	}
+	return step, nil
+}
+
+func parseSelector(fields []string, step *Step) error {
+	if len(fields) < 2 || fields[0] != "turn" {
+		return fmt.Errorf("selector must start with \"turn\"")
+	}
+	switch spec := fields[1]; {
+	case spec == "*":
+	case strings.Contains(spec, "-"):
+		a, b, _ := strings.Cut(spec, "-")
+		from, err1 := strconv.Atoi(a)
+		to, err2 := strconv.Atoi(b)
+		if err1 != nil || err2 != nil || from < 1 || to < from {
+			return fmt.Errorf("invalid turn range %q", spec)
+		}
+		step.From, step.To = from, to
+	default:
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid turn %q", spec)
+		}
+		step.From, step.To = n, n
+	}
+	for _, f := range fields[2:] {
+		key, val, ok := strings.Cut(f, "=")
+		if !ok || key != "model" || val == "" {
+			return fmt.Errorf("unknown selector option %q (want model=NAME)", f)
+		}
+		step.Model = val
+	}
+	return nil
+}
+
+// parseCall parses name(key=value, ...). Values are JSON literals when they
+// parse as such (numbers, booleans, quoted strings, arrays), otherwise bare strings.
+func parseCall(s string) (string, map[string]interface{}, error) {
+	open := strings.Index(s, "(")
+	if open <= 0 || !strings.HasSuffix(s, ")") {
+		return "", nil, fmt.Errorf("expected tool_call name(key=value, ...), got %q", s)
+	}
+	name := strings.TrimSpace(s[:open])
+	args := make(map[string]interface{})
+	for _, part := range splitArgs(s[open+1 : len(s)-1]) {
+		key, val, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return "", nil, fmt.Errorf("argument %q of %s is not key=value", part, name)
+		}
+		val = strings.TrimSpace(val)
+		var v interface{}
+		if err := json.Unmarshal([]byte(val), &v); err != nil {
+			v = val
+		}
+		args[key] = v
+	}
+	return name, args, nil
+}
+
+// splitArgs splits on commas that are not inside quotes or brackets
+func splitArgs(s string) []string {
+	var parts []string
+	var cur strings.Builder
+	depth := 0
+	inQuote, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && inQuote:
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case !inQuote && (r == '[' || r == '{'):
+			depth++
+		case !inQuote && (r == ']' || r == '}'):
+			depth--
+		case !inQuote && depth == 0 && r == ',':
+			parts = append(parts, cur.String())
+			cur.Reset()
+			continue
+		}
+		cur.WriteRune(r)
+	}
+	if strings.TrimSpace(cur.String()) != "" {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
+
+func unquote(s string) (string, error) {
+	if strings.HasPrefix(s, "\"") {
+		out, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		return out, nil
+	}
+	return s, nil
+}
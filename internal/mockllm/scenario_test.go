@@ -0,0 +1,118 @@
+package mockllm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	sc, err := Parse(`
+# a comment
+turn 1: tool_call read_file(path="main.go")
+turn 1: text "reading it"
+turn 2: text main.go defines the entry point
+turn 3-5 model=llama-3.3-70b-versatile: error 429 "rate limit reached"
+turn *: tool_call grep(pattern="a, b", max=3, glob=[".go", ".md"], recursive=true, dir=.)
+turn 6: tool_call read_file raw "{\"path\": "
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Step{
+		{From: 1, To: 1, Kind: KindToolCall, Tool: "read_file", Args: map[string]interface{}{"path": "main.go"}},
+		{From: 1, To: 1, Kind: KindText, Text: "reading it"},
+		{From: 2, To: 2, Kind: KindText, Text: "main.go defines the entry point"},
+		{From: 3, To: 5, Model: "llama-3.3-70b-versatile", Kind: KindError, Status: 429, Text: "rate limit reached"},
+		{Kind: KindToolCall, Tool: "grep", Args: map[string]interface{}{
+			"pattern":   "a, b",
+			"max":       float64(3),
+			"glob":      []interface{}{".go", ".md"},
+			"recursive": true,
+			"dir":       ".",
+		}},
+		{From: 6, To: 6, Kind: KindToolCall, Tool: "read_file", Raw: `{"path": `},
+	}
+	if !reflect.DeepEqual(sc.Steps, want) {
+		t.Errorf("Parse steps:\n got %#v\nwant %#v", sc.Steps, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`text "no selector"`, `expected "turn N: action"`},
+		{`round 1: text hi`, `selector must start with "turn"`},
+		{`turn 0: text hi`, `invalid turn "0"`},
+		{`turn 5-3: text hi`, `invalid turn range "5-3"`},
+		{`turn 1 temp=1: text hi`, `unknown selector option "temp=1"`},
+		{`turn 1: shout hi`, `unknown action "shout"`},
+		{`turn 1: error 200 "ok"`, `HTTP status between 400 and 599`},
+		{`turn 1: text "unterminated`, `invalid quoted string`},
+		{`turn 1: tool_call read_file`, `expected tool_call name(key=value, ...)`},
+		{`turn 1: tool_call read_file(main.go)`, `is not key=value`},
+		{`turn 1: tool_call read_file raw "{`, `invalid quoted string`},
+	}
+	for _, tt := range tests {
+		_, err := Parse("# header\n" + tt.line)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error containing %q", tt.line, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "scenario line 2: ") {
+			t.Errorf("Parse(%q) error = %q, want line 2 and %q", tt.line, err, tt.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{``, nil},
+		{`a=1`, []string{`a=1`}},
+		{`a=1, b=2`, []string{`a=1`, ` b=2`}},
+		{`s="x, y", n=1`, []string{`s="x, y"`, ` n=1`}},
+		{`s="say \"hi, there\"", n=1`, []string{`s="say \"hi, there\""`, ` n=1`}},
+		{`l=[1, 2], o={"k": [3, 4]}`, []string{`l=[1, 2]`, ` o={"k": [3, 4]}`}},
+		{`a=1,`, []string{`a=1`}},
+	}
+	for _, tt := range tests {
+		if got := splitArgs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStepsForSpecificity(t *testing.T) {
+	sc := MustParse(`
+turn *: text any
+turn 2-4: text range
+turn 3: text exact
+turn 3: text exact again
+turn 5 model=big: text big only
+`)
+	tests := []struct {
+		turn  int
+		model string
+		want  []string
+	}{
+		{1, "small", []string{"any"}},
+		{2, "small", []string{"range"}},
+		{3, "small", []string{"exact", "exact again"}},
+		{5, "small", []string{"any"}},
+		{5, "big", []string{"big only"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, st := range sc.stepsFor(tt.turn, tt.model) {
+			got = append(got, st.Text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("turn %d model %s: steps %q, want %q", tt.turn, tt.model, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package mockllm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Message mirrors the chat message shape of the OpenAI API
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// Request is a chat completion request received by the server
+type Request struct {
+	Turn     int
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Tools    []struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	} `json:"tools"`
+	Stream        bool `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+// ToolNames returns the names of the tools offered in the request
+func (r Request) ToolNames() []string {
+	names := make([]string, 0, len(r.Tools))
+	for _, t := range r.Tools {
+		names = append(names, t.Function.Name)
+	}
+	return names
+}
+
+// Server answers /chat/completions according to a Scenario.
+// Every request counts as one turn, starting at 1. Requests with
+// "stream": true are answered with server-sent chunks: the text a few
+// words at a time, then each tool call, the finish reason and, when
+// stream_options.include_usage is set, the usage.
+type Server struct {
+	*httptest.Server
+
+	scenario *Scenario
+	mu       sync.Mutex
+	turn     int
+	requests []Request
+}
+
+// NewServer starts a mock server; point the client base URL at srv.URL
+func NewServer(sc *Scenario) *Server {
+	s := &Server{scenario: sc}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewHandler serves a scenario without starting a listener,
+// e.g. for httptest.NewRecorder or a custom httptest.Server
+func NewHandler(sc *Scenario) *Server {
+	return &Server{scenario: sc}
+}
+
+// Turns returns how many chat requests have been served
+func (s *Server) Turns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.turn
+}
+
+// Requests returns a copy of every request received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset rewinds the scenario to turn 1 and forgets received requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turn = 0
+	s.requests = nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("mockllm: unsupported endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("mockllm: invalid request JSON: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	s.turn++
+	req.Turn = s.turn
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	steps := s.scenario.stepsFor(req.Turn, req.Model)
+	if len(steps) == 0 {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("mockllm: no scripted response for turn %d", req.Turn))
+		return
+	}
+
+	msg := Message{Role: "assistant"}
+	var texts []string
+	for i, st := range steps {
+		switch st.Kind {
+		case KindError:
+			writeError(w, st.Status, st.Text)
+			return
+		case KindText:
+			texts = append(texts, st.Text)
+		case KindToolCall:
+			args := st.Raw
+			if args == "" {
+				b, err := json.Marshal(st.Args)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+				args = string(b)
+			}
+			tc := ToolCall{ID: fmt.Sprintf("call_%d_%d", req.Turn, i), Type: "function"}
+			tc.Function.Name = st.Tool
+			tc.Function.Arguments = args
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+	}
+	msg.Content = strings.Join(texts, "\n")
+
+	finish := "stop"
+	if len(msg.ToolCalls) > 0 {
+		finish = "tool_calls"
+	}
+	// Rough token counts so usage tracking has something to add up
+	prompt := len(body) / 4
+	completion := (len(msg.Content) + 3) / 4
+	usage := map[string]int{
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+	}
+	if req.Stream {
+		var include map[string]int
+		if req.StreamOptions.IncludeUsage {
+			include = usage
+		}
+		writeStream(w, req, msg, finish, include)
+		return
+	}
+	resp := map[string]interface{}{
+		"id":     fmt.Sprintf("mock-%d", req.Turn),
+		"object": "chat.completion",
+		"model":  req.Model,
+		"choices": []map[string]interface{}{
+			{"index": 0, "message": msg, "finish_reason": finish},
+		},
+		"usage": usage,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeStream sends msg as chat.completion.chunk events, flushing each one
+func writeStream(w http.ResponseWriter, req Request, msg Message, finish string, usage map[string]int) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	send := func(choices []map[string]interface{}, extra map[string]interface{}) {
+		chunk := map[string]interface{}{
+			"id":      fmt.Sprintf("mock-%d", req.Turn),
+			"object":  "chat.completion.chunk",
+			"model":   req.Model,
+			"choices": choices,
+		}
+		for k, v := range extra {
+			chunk[k] = v
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	delta := func(d map[string]interface{}) []map[string]interface{} {
+		return []map[string]interface{}{{"index": 0, "delta": d, "finish_reason": nil}}
+	}
+
+	send(delta(map[string]interface{}{"role": "assistant", "content": ""}), nil)
+	if msg.Content != "" {
+		for _, piece := range strings.SplitAfter(msg.Content, " ") {
+			send(delta(map[string]interface{}{"content": piece}), nil)
+		}
+	}
+	for i, tc := range msg.ToolCalls {
+		send(delta(map[string]interface{}{"tool_calls": []map[string]interface{}{{
+			"index": i, "id": tc.ID, "type": tc.Type,
+			"function": map[string]string{"name": tc.Function.Name, "arguments": ""},
+		}}}), nil)
+		send(delta(map[string]interface{}{"tool_calls": []map[string]interface{}{{
+			"index": i, "function": map[string]string{"arguments": tc.Function.Arguments},
+		}}}), nil)
+	}
+	send([]map[string]interface{}{{"index": 0, "delta": map[string]interface{}{}, "finish_reason": finish}}, nil)
+	if usage != nil {
+		send([]map[string]interface{}{}, map[string]interface{}{"usage": usage})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": message},
+	})
+}
@@ -0,0 +1,176 @@
+package mockllm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type chatReply struct {
+	Choices []struct {
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func chat(t *testing.T, srv *Server, model string, messages ...Message) (int, chatReply) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"model": model, "messages": messages})
+	resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r chatReply
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, r
+}
+
+func TestServerTurns(t *testing.T) {
+	srv := NewServer(MustParse(`
+turn 1: text "let me look"
+turn 1: tool_call read_file(path="a.go")
+turn 1: tool_call list_dir(path=.)
+turn 2 model=main: error 429 "slow down"
+turn 2-3: text "done"
+`))
+	defer srv.Close()
+	user := Message{Role: "user", Content: "hi"}
+
+	status, r := chat(t, srv, "main", user)
+	if status != http.StatusOK {
+		t.Fatalf("turn 1 status %d", status)
+	}
+	msg := r.Choices[0].Message
+	if msg.Content != "let me look" || len(msg.ToolCalls) != 2 || r.Choices[0].FinishReason != "tool_calls" {
+		t.Fatalf("turn 1 = %+v, finish %s", msg, r.Choices[0].FinishReason)
+	}
+	if tc := msg.ToolCalls[0]; tc.Function.Name != "read_file" || tc.Function.Arguments != `{"path":"a.go"}` || tc.ID == msg.ToolCalls[1].ID {
+		t.Errorf("turn 1 tool call = %+v", tc)
+	}
+	if r.Usage.TotalTokens == 0 {
+		t.Error("turn 1 reported no usage")
+	}
+
+	// Turn 2 fails for the main model only; the retry is turn 3
+	status, r = chat(t, srv, "main", user)
+	if status != http.StatusTooManyRequests || r.Error == nil || r.Error.Message != "slow down" {
+		t.Errorf("turn 2 = %d %+v, want 429 slow down", status, r.Error)
+	}
+	status, r = chat(t, srv, "fallback", user)
+	if status != http.StatusOK || r.Choices[0].Message.Content != "done" || r.Choices[0].FinishReason != "stop" {
+		t.Errorf("turn 3 = %d %+v", status, r.Choices)
+	}
+
+	// Nothing is scripted past turn 3
+	if status, r = chat(t, srv, "main", user); status != http.StatusInternalServerError || !strings.Contains(r.Error.Message, "turn 4") {
+		t.Errorf("turn 4 = %d %+v, want an unscripted turn error", status, r.Error)
+	}
+
+	reqs := srv.Requests()
+	if srv.Turns() != 4 || len(reqs) != 4 || reqs[2].Model != "fallback" || reqs[2].Turn != 3 {
+		t.Errorf("recorded %d turns, requests %+v", srv.Turns(), reqs)
+	}
+	srv.Reset()
+	if status, _ := chat(t, srv, "main", user); status != http.StatusOK || srv.Turns() != 1 {
+		t.Errorf("after Reset: status %d, turns %d", status, srv.Turns())
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	srv := NewServer(MustParse(`
+turn 1: text "let me look"
+turn 1: tool_call read_file(path="a.go")
+`))
+	defer srv.Close()
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":          "main",
+		"messages":       []Message{{Role: "user", Content: "hi"}},
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	})
+	resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var text, name, args, finish string
+	var pieces, total int
+	done := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string     `json:"content"`
+					ToolCalls []ToolCall `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *struct {
+				TotalTokens int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("chunk %s: %v", data, err)
+		}
+		if chunk.Usage != nil {
+			total = chunk.Usage.TotalTokens
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				text += c.Delta.Content
+				pieces++
+			}
+			for _, tc := range c.Delta.ToolCalls {
+				name += tc.Function.Name
+				args += tc.Function.Arguments
+			}
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+		}
+	}
+	if !done || text != "let me look" || pieces != 3 || name != "read_file" || args != `{"path":"a.go"}` || finish != "tool_calls" || total == 0 {
+		t.Errorf("stream: done %v, text %q in %d pieces, call %s(%s), finish %q, usage %d", done, text, pieces, name, args, finish, total)
+	}
+	if reqs := srv.Requests(); !reqs[0].Stream || !reqs[0].StreamOptions.IncludeUsage {
+		t.Errorf("request = %+v, want stream with usage recorded", reqs[0])
+	}
+}
+
+func TestServerUnsupportedEndpoint(t *testing.T) {
+	srv := NewServer(NewScenario().Text(0, "hi"))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || srv.Turns() != 0 {
+		t.Errorf("GET /v1/models = %d after %d turns, want 404 and no turn", resp.StatusCode, srv.Turns())
+	}
+}
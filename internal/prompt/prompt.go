@@ -0,0 +1,224 @@
+// Package prompt renders the agent system prompt from a template, the
+// registered tools, environment details and project instruction files.
+//
+// Instruction files named CRAFT.md are collected from the user's home
+// (~/.craft/CRAFT.md or ~/.config/craft/CRAFT.md), then from every parent
+// directory down to the working directory, so the most specific file comes last.
+// A project can replace the built-in template with .craft/prompt.tmpl.
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"craft-cli/internal/config"
+)
+
+// InstructionFile is the name looked up in each directory
+const InstructionFile = "CRAFT.md"
+
+// TemplateFile overrides the built-in template when present in the project,
+// relative to the project root
+var TemplateFile = filepath.Join(".craft", "prompt.tmpl")
+
+// maxInstructionBytes caps each instruction file so one large file cannot eat the context
+const maxInstructionBytes = 16 * 1024
+
+// Tool is the part of a tool definition shown in the prompt
+type Tool struct {
+	Name        string
+	Description string
+}
+
+// Instruction is a discovered instruction file
+type Instruction struct {
+	Path    string
+	Content string
+}
+
+// Git describes the repository the session runs in
+type Git struct {
+	Root    string
+	Branch  string
+	Changes int
+}
+
+// Data is everything available to the template
+type Data struct {
+	Cwd          string
+	Project      string // project root, Cwd or a directory above it
+	OS           string
+	Arch         string
+	Shell        string
+	Date         string
+	Git          *Git
+	Tools        []Tool
+	Instructions []Instruction
+	// Extra holds additional sections appended by callers, e.g. a repo map
+	Extra []string
+}
+
+const defaultTemplate = `You are CRAFT CLI, an AI coding assistant with direct access to the local filesystem.
+You have access to these tools:
+{{- range .Tools}}
+- {{.Name}}: {{.Description}}
+{{- end}}
+
+Environment:
+- Current working directory: {{.Cwd}}
+{{- if and .Project (ne .Project .Cwd)}}
+- Project root: {{.Project}} (the repository map lists paths relative to it; tools take paths relative to the working directory)
+{{- end}}
+- OS: {{.OS}}/{{.Arch}}{{if .Shell}}, shell: {{.Shell}}{{end}}
+- Date: {{.Date}}
+{{- with .Git}}
+- Git repository: {{.Root}} (branch {{.Branch}}, {{.Changes}} uncommitted change(s))
+{{- end}}
+
+When you need to explore or modify files, use the tools directly. Always confirm successful file operations.
+{{- range .Instructions}}
+
+# Instructions from {{.Path}}
+{{.Content}}
+{{- end}}
+{{- range .Extra}}
+
+{{.}}
+{{- end}}
+`
+
+// Collect gathers environment details and instruction files for the
+// current directory; the non-empty extra sections become Extra
+func Collect(tools []Tool, extra ...string) Data {
+	cwd, _ := os.Getwd()
+	d := Data{
+		Cwd:     cwd,
+		Project: config.ProjectRoot(),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Shell:   filepath.Base(os.Getenv("SHELL")),
+		Date:    time.Now().Format("Monday, 2006-01-02"),
+		Git:     gitInfo(),
+		Tools:   tools,
+	}
+	if d.Shell == "." {
+		d.Shell = ""
+	}
+	d.Instructions = Discover(cwd)
+	for _, s := range extra {
+		if s != "" {
+			d.Extra = append(d.Extra, s)
+		}
+	}
+	return d
+}
+
+// Render executes the project template (or the built-in one) with d.
+// If the project template is broken, the built-in template is rendered
+// instead and the template error is returned alongside it.
+func Render(d Data) (string, error) {
+	custom, err := os.ReadFile(filepath.Join(config.ProjectRoot(), TemplateFile))
+	if err != nil {
+		return execute(defaultTemplate, d)
+	}
+	out, err := execute(string(custom), d)
+	if err != nil {
+		fallback, _ := execute(defaultTemplate, d)
+		return fallback, fmt.Errorf("%s: %w", TemplateFile, err)
+	}
+	return out, nil
+}
+
+func execute(text string, d Data) (string, error) {
+	tmpl, err := template.New("system").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("failed to render prompt template: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Build collects and renders the system prompt in one step
+func Build(tools []Tool, extra ...string) (string, error) {
+	return Render(Collect(tools, extra...))
+}
+
+// Discover returns instruction files from the user's home and from cwd and
+// each of its parents, most general first.
+func Discover(cwd string) []Instruction {
+	var out []Instruction
+	seen := make(map[string]bool)
+	add := func(path string) {
+		abs, err := filepath.Abs(path)
+		if err != nil || seen[abs] {
+			return
+		}
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return
+		}
+		seen[abs] = true
+		content := strings.TrimSpace(string(data))
+		if len(content) > maxInstructionBytes {
+			// Cut at a rune boundary so the prompt stays valid UTF-8
+			cut := maxInstructionBytes
+			for cut > 0 && !utf8.RuneStart(content[cut]) {
+				cut--
+			}
+			content = content[:cut] + "\n... (truncated)"
+		}
+		if content != "" {
+			out = append(out, Instruction{Path: abs, Content: content})
+		}
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		add(filepath.Join(home, ".craft", InstructionFile))
+		add(filepath.Join(home, ".config", "craft", InstructionFile))
+	}
+
+	// Walk up from cwd to the filesystem root, then add from the top down
+	var dirs []string
+	for dir := filepath.Clean(cwd); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		add(filepath.Join(dirs[i], InstructionFile))
+	}
+	return out
+}
+
+func gitInfo() *Git {
+	root, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil
+	}
+	g := &Git{Root: root}
+	g.Branch, _ = git("rev-parse", "--abbrev-ref", "HEAD")
+	if status, err := git("status", "--porcelain"); err == nil && status != "" {
+		g.Changes = len(strings.Split(status, "\n"))
+	}
+	return g
+}
+
+func git(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,324 @@
+// Package replay records LLM HTTP exchanges to fixture files and serves them
+// back later, so agent code paths can run offline and deterministically.
+//
+// A Transport wraps an http.RoundTripper. In Record mode every request is
+// forwarded to the real API and the request/response pair is written to
+// <dir>/<hash>.json. In Replay mode nothing touches the network: the request
+// is normalized, hashed and answered from the matching fixture.
+//
+// A request sent again with the same hash in one recording, such as a retry
+// after a rate limit, is saved as <hash>-2.json, <hash>-3.json and so on, and
+// replayed in the same order.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mode selects what the Transport does with a request
+type Mode string
+
+const (
+	Record Mode = "record"
+	Replay Mode = "replay"
+)
+
+// Environment variables read by FromEnv
+const (
+	EnvRecord = "CRAFT_RECORD"
+	EnvReplay = "CRAFT_REPLAY"
+)
+
+// Fixture is the on-disk form of one exchange
+type Fixture struct {
+	Hash     string          `json:"hash"`
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Status   int             `json:"status"`
+	Header   http.Header     `json:"header,omitempty"`
+	Response json.RawMessage `json:"response"`
+	// Text marks a response body that was not JSON and is stored as a string
+	Text bool `json:"text,omitempty"`
+}
+
+// Transport is an http.RoundTripper that records or replays exchanges
+type Transport struct {
+	Dir  string
+	Mode Mode
+	// Base performs real requests in Record mode (http.DefaultTransport if nil)
+	Base http.RoundTripper
+	// IgnoreFields lists top-level or dotted JSON keys dropped before hashing,
+	// e.g. "temperature" or "messages.0.content" for a volatile system prompt.
+	IgnoreFields []string
+
+	mu   sync.Mutex
+	seen map[string]int // requests per hash so far
+}
+
+// New creates a Transport storing fixtures in dir
+func New(dir string, mode Mode) *Transport {
+	return &Transport{Dir: dir, Mode: mode}
+}
+
+// FromEnv wraps base with a recording or replaying Transport when
+// CRAFT_RECORD or CRAFT_REPLAY is set to a fixture directory.
+// Otherwise base is returned unchanged.
+func FromEnv(base http.RoundTripper, ignoreFields ...string) http.RoundTripper {
+	var t *Transport
+	if dir := os.Getenv(EnvReplay); dir != "" {
+		t = New(dir, Replay)
+	} else if dir := os.Getenv(EnvRecord); dir != "" {
+		t = New(dir, Record)
+	} else {
+		return base
+	}
+	t.Base = base
+	t.IgnoreFields = ignoreFields
+	return t
+}
+
+// Enabled reports whether FromEnv would replay instead of calling the API
+func Enabled() bool {
+	return os.Getenv(EnvReplay) != ""
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("replay: failed to read request body: %w", err)
+		}
+	}
+
+	normalized, err := t.Normalize(body)
+	if err != nil {
+		return nil, err
+	}
+	hash := Hash(req.Method, req.URL.Path, normalized)
+	n := t.next(hash)
+
+	if t.Mode == Replay {
+		fx, err := t.load(hash, n)
+		if err != nil {
+			return nil, err
+		}
+		return fx.response(req), nil
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("replay: failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	fx := Fixture{
+		Hash:   hash,
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Status: resp.StatusCode,
+		Header: http.Header{"Content-Type": resp.Header.Values("Content-Type")},
+	}
+	fx.Request, _ = rawJSON(normalized)
+	fx.Response, fx.Text = rawJSON(respBody)
+	if err := t.save(fx, n); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Normalize canonicalizes a JSON request body: keys are sorted and
+// IgnoreFields are removed. Non-JSON bodies are returned as-is.
+func (t *Transport) Normalize(body []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body, nil
+	}
+	for _, field := range t.IgnoreFields {
+		dropField(v, strings.Split(field, "."))
+	}
+	// encoding/json sorts map keys, which gives a canonical form
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("replay: failed to normalize request: %w", err)
+	}
+	return out, nil
+}
+
+// Hash returns the fixture key for a normalized request
+func Hash(method, path string, normalized []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// List returns the names of all fixtures in dir without .json, sorted: a
+// hash, or hash-N for the Nth request with that hash
+func List(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(matches))
+	for _, m := range matches {
+		hashes = append(hashes, strings.TrimSuffix(filepath.Base(m), ".json"))
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// next counts a request with hash and returns its occurrence, from 1
+func (t *Transport) next(hash string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil {
+		t.seen = make(map[string]int)
+	}
+	t.seen[hash]++
+	return t.seen[hash]
+}
+
+// path is the fixture of the nth request with hash
+func (t *Transport) path(hash string, n int) string {
+	if n > 1 {
+		return filepath.Join(t.Dir, fmt.Sprintf("%s-%d.json", hash, n))
+	}
+	return filepath.Join(t.Dir, hash+".json")
+}
+
+// load reads the fixture of the nth request with hash. A request repeated
+// more often than it was recorded gets the last recorded answer.
+func (t *Transport) load(hash string, n int) (*Fixture, error) {
+	data, err := os.ReadFile(t.path(hash, n))
+	for n > 1 && os.IsNotExist(err) {
+		n--
+		data, err = os.ReadFile(t.path(hash, n))
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("replay: no fixture for request %s in %s (record it with %s=%s)", hash, t.Dir, EnvRecord, t.Dir)
+		}
+		return nil, fmt.Errorf("replay: failed to read fixture: %w", err)
+	}
+	var fx Fixture
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return nil, fmt.Errorf("replay: corrupt fixture %s: %w", hash, err)
+	}
+	return &fx, nil
+}
+
+// save writes the fixture of the nth request with fx.Hash. The first one
+// also removes the repeats left by an earlier recording.
+func (t *Transport) save(fx Fixture, n int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return fmt.Errorf("replay: failed to create fixture dir: %w", err)
+	}
+	if n == 1 {
+		stale, _ := filepath.Glob(filepath.Join(t.Dir, fx.Hash+"-*.json"))
+		for _, p := range stale {
+			os.Remove(p)
+		}
+	}
+	data, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("replay: failed to encode fixture: %w", err)
+	}
+	return os.WriteFile(t.path(fx.Hash, n), append(data, '\n'), 0644)
+}
+
+func (fx *Fixture) response(req *http.Request) *http.Response {
+	header := fx.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	body := []byte(fx.Response)
+	if fx.Text {
+		var s string
+		if json.Unmarshal(body, &s) == nil {
+			body = []byte(s)
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fx.Status, http.StatusText(fx.Status)),
+		StatusCode:    fx.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// rawJSON keeps valid JSON as-is so fixtures stay readable and editable,
+// and stores anything else as a JSON string, reporting that it did.
+func rawJSON(b []byte) (json.RawMessage, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	if json.Valid(b) {
+		return json.RawMessage(b), false
+	}
+	s, _ := json.Marshal(string(b))
+	return s, true
+}
+
+func dropField(v interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(node, path[0])
+			return
+		}
+		dropField(node[path[0]], path[1:])
+	case []interface{}:
+		var idx int
+		if _, err := fmt.Sscanf(path[0], "%d", &idx); err != nil || idx < 0 || idx >= len(node) {
+			return
+		}
+		if len(path) == 1 {
+			node[idx] = nil
+			return
+		}
+		dropField(node[idx], path[1:])
+	}
+}
@@ -0,0 +1,170 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// upstream answers every request with the body returned by reply for the
+// nth request, from 1
+func upstream(t *testing.T, reply func(n int) string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, reply(int(n)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func post(t *testing.T, rt http.RoundTripper, url, body string) (string, error) {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), nil
+}
+
+// compact strips the indentation fixtures add to JSON bodies
+func compact(t *testing.T, s string) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(s)); err != nil {
+		return s
+	}
+	return b.String()
+}
+
+func TestRecordReplayRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	srv, calls := upstream(t, func(int) string { return `{"choices":[{"message":{"content":"hi"}}]}` })
+	url := srv.URL + "/v1/chat/completions"
+
+	rec := New(dir, Record)
+	got, err := post(t, rec, url, `{"model":"m","messages":[{"role":"user","content":"hello"}]}`)
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if names, _ := List(dir); len(names) != 1 {
+		t.Fatalf("fixtures after recording = %v, want one", names)
+	}
+
+	// Key order does not matter once the request is normalized
+	rep := New(dir, Replay)
+	replayed, err := post(t, rep, url, `{"messages":[{"content":"hello","role":"user"}],"model":"m"}`)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if compact(t, replayed) != got {
+		t.Errorf("replayed body = %s, want %s", replayed, got)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("upstream called %d times, want 1 (replay must not touch the network)", calls.Load())
+	}
+}
+
+func TestNormalizeIgnoreFields(t *testing.T) {
+	tr := &Transport{IgnoreFields: []string{"temperature", "messages.0.content"}}
+	a, err := tr.Normalize([]byte(`{"model":"m","temperature":0.2,"messages":[{"role":"system","content":"today is monday"},{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := tr.Normalize([]byte(`{"messages":[{"content":"today is tuesday","role":"system"},{"role":"user","content":"hi"}],"temperature":0.9,"model":"m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Hash("POST", "/x", a) != Hash("POST", "/x", b) {
+		t.Errorf("hashes differ for requests that differ only in ignored fields:\n%s\n%s", a, b)
+	}
+
+	c, _ := tr.Normalize([]byte(`{"model":"m","messages":[{"role":"system","content":"x"},{"role":"user","content":"bye"}]}`))
+	if Hash("POST", "/x", a) == Hash("POST", "/x", c) {
+		t.Error("hash ignores a field that is not in IgnoreFields")
+	}
+}
+
+func TestReplayMiss(t *testing.T) {
+	dir := t.TempDir()
+	_, err := post(t, New(dir, Replay), "http://example.invalid/v1/chat/completions", `{"model":"m"}`)
+	if err == nil {
+		t.Fatal("replay without a fixture succeeded")
+	}
+	if !strings.Contains(err.Error(), "no fixture") || !strings.Contains(err.Error(), dir) {
+		t.Errorf("error = %q, want it to name the missing fixture and %s", err, dir)
+	}
+}
+
+func TestReplayKeepsJSONStringBody(t *testing.T) {
+	dir := t.TempDir()
+	for _, body := range []string{`"just a string"`, "plain text"} {
+		srv, _ := upstream(t, func(int) string { return body })
+		req := `{"body":` + `"` + strings.ReplaceAll(body, `"`, `\"`) + `"}`
+		if _, err := post(t, New(dir, Record), srv.URL, req); err != nil {
+			t.Fatalf("record %q: %v", body, err)
+		}
+		got, err := post(t, New(dir, Replay), srv.URL, req)
+		if err != nil {
+			t.Fatalf("replay %q: %v", body, err)
+		}
+		if got != body {
+			t.Errorf("replayed %q, want %q", got, body)
+		}
+	}
+}
+
+func TestRepeatedRequests(t *testing.T) {
+	dir := t.TempDir()
+	srv, _ := upstream(t, func(n int) string { return fmt.Sprintf(`{"n":%d}`, n) })
+	const req = `{"model":"m"}`
+
+	rec := New(dir, Record)
+	for range 2 {
+		if _, err := post(t, rec, srv.URL, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, _ := List(dir)
+	if len(names) != 2 {
+		t.Fatalf("fixtures = %v, want one per request", names)
+	}
+
+	rep := New(dir, Replay)
+	for i, want := range []string{`{"n":1}`, `{"n":2}`, `{"n":2}`} {
+		got, err := post(t, rep, srv.URL, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compact(t, got) != want {
+			t.Errorf("replay %d = %s, want %s", i+1, got, want)
+		}
+	}
+
+	// Recording again starts over instead of leaving the old repeats behind
+	if _, err := post(t, New(dir, Record), srv.URL, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, names[1]+".json")); !os.IsNotExist(err) {
+		t.Errorf("repeat %s from the earlier recording was kept", names[1])
+	}
+}
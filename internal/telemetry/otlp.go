@@ -0,0 +1,191 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// The OTLP/JSON encoding of an ExportTraceServiceRequest. IDs are hex,
+// 64-bit integers are decimal strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string    `json:"stringValue,omitempty"`
+		BoolValue   *bool      `json:"boolValue,omitempty"`
+		IntValue    *string    `json:"intValue,omitempty"`
+		DoubleValue *float64   `json:"doubleValue,omitempty"`
+		ArrayValue  *otlpArray `json:"arrayValue,omitempty"`
+	}
+	otlpArray struct {
+		Values []otlpValue `json:"values"`
+	}
+)
+
+// scopeName identifies craft's instrumentation in the export
+const scopeName = "craft-cli"
+
+// encode builds the OTLP/JSON export request for spans
+func encode(resource []Attr, spans []*Span) ([]byte, error) {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        keyValues(s.attrs),
+			Status:            otlpStatus{Code: s.status, Message: s.message},
+		}
+		if s.parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		out = append(out, span)
+	}
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues(resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: out}},
+	}}})
+}
+
+func keyValues(attrs []Attr) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: anyValue(a.Value)})
+	}
+	return kvs
+}
+
+func anyValue(value any) otlpValue {
+	var v otlpValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int:
+		s := strconv.Itoa(x)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &x
+	case []string:
+		v.ArrayValue = &otlpArray{Values: make([]otlpValue, 0, len(x))}
+		for _, s := range x {
+			v.ArrayValue.Values = append(v.ArrayValue.Values, anyValue(s))
+		}
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return v
+}
+
+// otlpExporter posts export requests to an OTLP/HTTP collector
+type otlpExporter struct {
+	url     string
+	headers map[string]string
+	client  http.Client
+}
+
+func (e *otlpExporter) export(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector %s returned %d: %s", e.url, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (e *otlpExporter) close() error { return nil }
+
+// fileExporter appends one export request per line, like the collector's
+// file exporter, so the output can be replayed into a collector or read
+// with jq
+type fileExporter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openFileExporter(path string) (*fileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create span file directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open span file: %w", err)
+	}
+	return &fileExporter{f: f}, nil
+}
+
+func (e *fileExporter) export(_ context.Context, body []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.f.Write(append(body, '\n'))
+	return err
+}
+
+func (e *fileExporter) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
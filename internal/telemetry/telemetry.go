@@ -0,0 +1,319 @@
+// Package telemetry records OpenTelemetry-compatible spans for the agent:
+// one per user turn, with a child for every model call and tool execution.
+// Spans are batched and exported in the OTLP/HTTP JSON encoding, either to
+// a collector (<endpoint>/v1/traces) or to a local file with one export
+// request per line, the format of the collector's file exporter.
+//
+// Spans travel in a context.Context: Start makes the new span the parent of
+// spans started from the returned context. Until Init enables telemetry,
+// Start returns a nil *Span, and every Span method is a no-op on nil, so
+// call sites need no checks.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"craft-cli/internal/config"
+	"craft-cli/internal/logger"
+)
+
+// Attribute keys, following the OpenTelemetry semantic conventions where
+// one exists
+const (
+	KeyOperation    = "gen_ai.operation.name"
+	KeySystem       = "gen_ai.system"
+	KeyModel        = "gen_ai.request.model"
+	KeyFinishReason = "gen_ai.response.finish_reasons" // one per choice
+	KeyInputTokens  = "gen_ai.usage.input_tokens"
+	KeyOutputTokens = "gen_ai.usage.output_tokens"
+	KeyTool         = "gen_ai.tool.name"
+	KeyStatusCode   = "http.response.status_code"
+	KeyErrorType    = "error.type"
+
+	KeyTurn       = "craft.turn"        // model call within the user turn
+	KeyModelCalls = "craft.model_calls" // model calls made by a user turn
+	KeyToolCalls  = "craft.tool_calls"  // tool calls made by a user turn
+	KeyExitCode   = "craft.tool.exit_code"
+	KeyBytes      = "craft.tool.bytes" // size of the result given back to the model
+)
+
+// Span kinds used by craft
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+// Status codes
+const (
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+)
+
+const (
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// Attr is a span attribute; Value is a string, bool, int, int64, float64
+// or []string
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String, Int, Bool and Strings build attributes
+func String(key, value string) Attr             { return Attr{key, value} }
+func Int(key string, value int) Attr            { return Attr{key, value} }
+func Bool(key string, value bool) Attr          { return Attr{key, value} }
+func Strings(key string, values ...string) Attr { return Attr{key, values} }
+
+// Span is an operation being timed. Its fields are written only by the
+// goroutine that started it until End.
+type Span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   []Attr
+	status  int
+	message string
+	ended   bool
+}
+
+// exporter sends an encoded OTLP export request somewhere
+type exporter interface {
+	export(ctx context.Context, body []byte) error
+	close() error
+}
+
+var (
+	mu       sync.Mutex
+	exp      exporter
+	resource []Attr
+	queue    []*Span
+	stop     chan struct{}
+	done     chan struct{}
+	// shutting is set while Shutdown drains the queue, so that End starts
+	// no flush of its own and a concurrent Shutdown returns
+	shutting bool
+)
+
+// Init enables telemetry when the [telemetry] section of the loaded
+// configuration asks for it. Spans carry the logger session ID, so call it
+// after logger.Init.
+func Init() error {
+	c := config.Get().Telemetry
+	if !c.Enabled {
+		return nil
+	}
+	var e exporter
+	switch c.Exporter {
+	case "file":
+		path := c.File
+		if path == "" {
+			path = DefaultFile()
+		}
+		f, err := openFileExporter(path)
+		if err != nil {
+			return err
+		}
+		e = f
+	default:
+		headers := make(map[string]string)
+		for _, h := range c.Headers {
+			k, v, _ := strings.Cut(h, "=")
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		e = &otlpExporter{url: strings.TrimSuffix(c.Endpoint, "/") + "/v1/traces", headers: headers}
+	}
+	host, _ := os.Hostname()
+	attrs := []Attr{
+		String("service.name", c.ServiceName),
+		String("telemetry.sdk.name", "craft"),
+		String("telemetry.sdk.language", "go"),
+		String("host.name", host),
+	}
+	if id := logger.SessionID(); id != "" {
+		attrs = append(attrs, String("session.id", id))
+	}
+	start(e, attrs)
+	return nil
+}
+
+// start installs an exporter and the background flusher
+func start(e exporter, attrs []Attr) {
+	Shutdown()
+	mu.Lock()
+	exp, resource, stop, done = e, attrs, make(chan struct{}), make(chan struct{})
+	s, d := stop, done
+	mu.Unlock()
+	go func() {
+		defer close(d)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush()
+			case <-s:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown exports the spans still queued and disables telemetry
+func Shutdown() {
+	mu.Lock()
+	if exp == nil || shutting {
+		mu.Unlock()
+		return
+	}
+	shutting = true
+	close(stop)
+	d := done
+	mu.Unlock()
+	<-d
+	// Holding flushMu keeps a flush started by End from exporting to the
+	// closed exporter; once exp is nil it finds nothing to do
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	flushLocked()
+	mu.Lock()
+	exp.close()
+	exp, queue, shutting = nil, nil, false
+	mu.Unlock()
+}
+
+// Enabled reports whether spans are being recorded
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return exp != nil
+}
+
+// DefaultFile is craft/spans.jsonl next to the default log file
+func DefaultFile() string {
+	return filepath.Join(filepath.Dir(logger.DefaultPath()), "spans.jsonl")
+}
+
+type spanKey struct{}
+
+// Start begins a span as a child of the span in ctx, if any, and returns a
+// context carrying the new span. It returns a nil span when telemetry is
+// off.
+func Start(ctx context.Context, name string, kind int, attrs ...Attr) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if parent := FromContext(ctx); parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span carried by ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttributes adds or replaces attributes
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	for _, a := range attrs {
+		replaced := false
+		for i := range s.attrs {
+			if s.attrs[i].Key == a.Key {
+				s.attrs[i], replaced = a, true
+				break
+			}
+		}
+		if !replaced {
+			s.attrs = append(s.attrs, a)
+		}
+	}
+}
+
+// SetError marks the span failed with err, doing nothing for a nil err
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.status, s.message = StatusError, strings.TrimSpace(err.Error())
+}
+
+// SetStatus sets the status code and message
+func (s *Span) SetStatus(code int, message string) {
+	if s == nil {
+		return
+	}
+	s.status, s.message = code, message
+}
+
+// End finishes the span and queues it for export; later calls do nothing
+func (s *Span) End() {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	mu.Lock()
+	if exp == nil {
+		mu.Unlock()
+		return
+	}
+	queue = append(queue, s)
+	full := len(queue) >= batchSize && !shutting
+	mu.Unlock()
+	if full {
+		go flush()
+	}
+}
+
+// flushMu keeps batches in order when the ticker and a full queue flush at
+// the same time
+var flushMu sync.Mutex
+
+// flush exports the queued spans; a failed export is logged and dropped
+func flush() {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	flushLocked()
+}
+
+// flushLocked is flush with flushMu held
+func flushLocked() {
+	mu.Lock()
+	spans, e, res := queue, exp, resource
+	queue = nil
+	mu.Unlock()
+	if len(spans) == 0 || e == nil {
+		return
+	}
+	body, err := encode(res, spans)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		err = e.export(ctx, body)
+	}
+	if err != nil {
+		logger.Warn("span export failed", "spans", len(spans), logger.Err(err))
+	}
+}
@@ -0,0 +1,233 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recordingExporter counts exported spans and fails an export after close
+type recordingExporter struct {
+	mu     sync.Mutex
+	spans  int
+	closed int
+	late   error
+}
+
+func (e *recordingExporter) export(_ context.Context, body []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed > 0 {
+		e.late = errors.New("export after close")
+		return e.late
+	}
+	e.spans++
+	return nil
+}
+
+func (e *recordingExporter) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed++
+	return nil
+}
+
+func TestShutdownConcurrent(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		e := &recordingExporter{}
+		start(e, nil)
+		var wg sync.WaitGroup
+		// Enough spans to fill batches and start flushes from End
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < batchSize; j++ {
+					_, s := Start(context.Background(), "span", KindInternal)
+					s.End()
+				}
+			}()
+		}
+		for g := 0; g < 3; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				Shutdown()
+			}()
+		}
+		wg.Wait()
+		Shutdown()
+
+		e.mu.Lock()
+		if e.closed != 1 || e.late != nil {
+			t.Fatalf("exporter closed %d times, late export: %v", e.closed, e.late)
+		}
+		e.mu.Unlock()
+		if Enabled() {
+			t.Fatal("telemetry still enabled after Shutdown")
+		}
+	}
+}
+
+func TestEndAfterShutdown(t *testing.T) {
+	e := &recordingExporter{}
+	start(e, nil)
+	_, s := Start(context.Background(), "span", KindInternal)
+	Shutdown()
+	s.End()
+	if e.late != nil {
+		t.Error(e.late)
+	}
+}
+
+// exported is a span as decoded from the OTLP/JSON of the file exporter
+type exported struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Start        string `json:"startTimeUnixNano"`
+	End          string `json:"endTimeUnixNano"`
+	Attributes   []struct {
+		Key   string                     `json:"key"`
+		Value map[string]json.RawMessage `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+// attr returns the JSON of the value of key, e.g. {"intValue":"3"}
+func (s exported) attr(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			out, _ := json.Marshal(a.Value)
+			return string(out)
+		}
+	}
+	return ""
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	e, err := openFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(e, []Attr{String("service.name", "craft-test")})
+
+	// A turn with one model call and one tool call, as the agent loop makes
+	ctx, turn := Start(context.Background(), "turn", KindInternal)
+	_, chat := Start(ctx, "chat test-model", KindClient, String(KeyModel, "test-model"), Int(KeyTurn, 1))
+	chat.SetAttributes(Strings(KeyFinishReason, "tool_calls"), Int(KeyInputTokens, 12))
+	chat.End()
+	_, tool := Start(ctx, "execute_tool bash", KindInternal, String(KeyTool, "bash"))
+	tool.SetAttributes(Int(KeyExitCode, 2), Bool("craft.test", true))
+	tool.SetError(errors.New("exit status 2\n"))
+	tool.End()
+	turn.SetAttributes(Int(KeyModelCalls, 1))
+	turn.End()
+	Shutdown()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						StringValue string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []exported `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	scanner := bufio.NewScanner(f)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+	}
+	if lines != 1 || len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("%d export requests, want one with one resource and scope", lines)
+	}
+	rs := req.ResourceSpans[0]
+	if a := rs.Resource.Attributes; len(a) != 1 || a[0].Key != "service.name" || a[0].Value.StringValue != "craft-test" {
+		t.Errorf("resource attributes = %+v", a)
+	}
+	if name := rs.ScopeSpans[0].Scope.Name; name != scopeName {
+		t.Errorf("scope = %q, want %q", name, scopeName)
+	}
+
+	spans := make(map[string]exported)
+	for _, s := range rs.ScopeSpans[0].Spans {
+		spans[s.Name] = s
+		if len(s.TraceID) != 32 || len(s.SpanID) != 16 || s.Start == "" || s.End < s.Start {
+			t.Errorf("span %s has IDs %q/%q and times %s-%s", s.Name, s.TraceID, s.SpanID, s.Start, s.End)
+		}
+	}
+	root, c, x := spans["turn"], spans["chat test-model"], spans["execute_tool bash"]
+	if root.ParentSpanID != "" {
+		t.Errorf("turn has parent %s", root.ParentSpanID)
+	}
+	for _, child := range []exported{c, x} {
+		if child.TraceID != root.TraceID || child.ParentSpanID != root.SpanID {
+			t.Errorf("span %q is in trace %s under %s, want trace %s under the turn %s",
+				child.Name, child.TraceID, child.ParentSpanID, root.TraceID, root.SpanID)
+		}
+	}
+	if c.Kind != KindClient || x.Kind != KindInternal {
+		t.Errorf("kinds = %d, %d, want client and internal", c.Kind, x.Kind)
+	}
+
+	tests := []struct {
+		span      exported
+		key, want string
+	}{
+		{c, KeyModel, `{"stringValue":"test-model"}`},
+		{c, KeyTurn, `{"intValue":"1"}`},
+		{c, KeyInputTokens, `{"intValue":"12"}`},
+		{c, KeyFinishReason, `{"arrayValue":{"values":[{"stringValue":"tool_calls"}]}}`},
+		{x, KeyExitCode, `{"intValue":"2"}`},
+		{x, "craft.test", `{"boolValue":true}`},
+		{root, KeyModelCalls, `{"intValue":"1"}`},
+	}
+	for _, tt := range tests {
+		if got := tt.span.attr(tt.key); got != tt.want {
+			t.Errorf("%s %s = %s, want %s", tt.span.Name, tt.key, got, tt.want)
+		}
+	}
+	if x.Status.Code != StatusError || x.Status.Message != "exit status 2" || c.Status.Code != StatusUnset {
+		t.Errorf("statuses = %+v, %+v", x.Status, c.Status)
+	}
+}
+
+func TestStartDisabled(t *testing.T) {
+	ctx, s := Start(context.Background(), "turn", KindInternal)
+	if s != nil || FromContext(ctx) != nil {
+		t.Fatal("span started with telemetry off")
+	}
+	// Every method is a no-op on the nil span
+	s.SetAttributes(Int(KeyTurn, 1))
+	s.SetError(errors.New("x"))
+	s.End()
+}
@@ -0,0 +1,313 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Session summarizes a trace file for listing
+type Session struct {
+	ID       string
+	Path     string
+	Started  time.Time
+	Modified time.Time
+	Size     int64
+	Prompts  int
+	First    string // first prompt, for recognizing the session
+}
+
+// List returns the traces in dir, newest first. A missing directory holds
+// no traces.
+func List(dir string) ([]Session, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		s := Session{
+			ID:       strings.TrimSuffix(filepath.Base(path), ".jsonl"),
+			Path:     path,
+			Started:  info.ModTime(),
+			Modified: info.ModTime(),
+			Size:     info.Size(),
+		}
+		scan(path, func(line []byte) bool {
+			// Only session and prompt events are decoded; requests can be large
+			head := line[:min(len(line), 80)]
+			if !bytes.Contains(head, []byte(`"type":"`+TypeSession+`"`)) && !bytes.Contains(head, []byte(`"type":"`+TypePrompt+`"`)) {
+				return true
+			}
+			var e Event
+			if json.Unmarshal(line, &e) != nil {
+				return true
+			}
+			switch e.Type {
+			case TypeSession:
+				s.Started = e.Time
+			case TypePrompt:
+				s.Prompts++
+				if s.First == "" {
+					s.First = e.Text
+				}
+			}
+			return true
+		})
+		sessions = append(sessions, s)
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+// sortSessions orders sessions newest first
+func sortSessions(sessions []Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].Modified.Equal(sessions[j].Modified) {
+			return sessions[i].Modified.After(sessions[j].Modified)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+}
+
+// Find returns the trace in dir named by ref: a session ID, a unique
+// prefix of one, or "last" for the newest
+func Find(dir, ref string) (Session, error) {
+	sessions, err := List(dir)
+	if err != nil {
+		return Session{}, err
+	}
+	if len(sessions) == 0 {
+		return Session{}, fmt.Errorf("no traces in %s", dir)
+	}
+	if ref == "last" {
+		return sessions[0], nil
+	}
+	var found []Session
+	for _, s := range sessions {
+		if s.ID == ref {
+			return s, nil
+		}
+		if ref != "" && strings.HasPrefix(s.ID, ref) {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Session{}, fmt.Errorf("no trace for session %q in %s", ref, dir)
+	case 1:
+		return found[0], nil
+	}
+	return Session{}, fmt.Errorf("%q matches %d sessions", ref, len(found))
+}
+
+// Load reads the events of a trace file. A line that is not an event, such
+// as one cut short by a crash, is skipped.
+func Load(path string) ([]Event, error) {
+	var events []Event
+	err := scan(path, func(line []byte) bool {
+		var e Event
+		if json.Unmarshal(line, &e) == nil {
+			events = append(events, e)
+		}
+		return true
+	})
+	return events, err
+}
+
+// scan calls fn with each non-empty line of path until it returns false
+func scan(path string, fn func(line []byte) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 && !fn(line) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// RenderOptions selects what Render prints
+type RenderOptions struct {
+	Prompt int  // only this prompt (0 = all)
+	Full   bool // print texts whole, and the messages each request adds
+}
+
+// chatBody decodes the parts of a ChatRequest or ChatResponse shown in a
+// timeline
+type chatBody struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Tools    []struct{}    `json:"tools"`
+	Choices  []struct {
+		Message      chatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+type chatMessage struct {
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	ToolCalls []struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+// Render prints a readable timeline of a trace: for each prompt, the model
+// calls with their timing, tokens and replies, and the tool calls with their
+// arguments and results. Times are relative to the start of the prompt.
+func Render(w io.Writer, events []Event, o RenderOptions) error {
+	if o.Prompt > 0 && !slices.ContainsFunc(events, func(e Event) bool { return e.Prompt == o.Prompt }) {
+		return fmt.Errorf("no prompt %d in this trace", o.Prompt)
+	}
+	var start time.Time
+	sent := 0 // messages in the previous request of the prompt
+	shown := false
+	for _, e := range events {
+		if e.Type == TypeSession {
+			fmt.Fprintf(w, "Session %s, started %s in %s\n", e.Session, e.Time.Format("2006-01-02 15:04:05"), e.Text)
+			continue
+		}
+		if o.Prompt > 0 && e.Prompt != o.Prompt {
+			continue
+		}
+		if e.Type == TypePrompt {
+			start, sent, shown = e.Time, 0, true
+			fmt.Fprintf(w, "\n── Prompt %d at %s\n", e.Prompt, e.Time.Format("15:04:05"))
+			writeText(w, "user", e.Text, o.Full)
+			continue
+		}
+		if start.IsZero() {
+			start = e.Time
+		}
+		shown = true
+		at := fmt.Sprintf("  %+7.1fs  turn %-2d ", e.Time.Sub(start).Seconds(), e.Turn)
+
+		switch e.Type {
+		case TypeRequest:
+			var body chatBody
+			json.Unmarshal(e.Body, &body)
+			fmt.Fprintf(w, "%s→ %s: %d messages, %d tools\n", at, e.Model, len(body.Messages), len(body.Tools))
+			if o.Full && sent < len(body.Messages) {
+				for _, m := range body.Messages[sent:] {
+					writeText(w, m.Role, m.Content, true)
+				}
+			}
+			sent = len(body.Messages)
+		case TypeResponse:
+			var body chatBody
+			json.Unmarshal(e.Body, &body)
+			line := fmt.Sprintf("%s← %s in %s", at, e.Model, ms(e.DurationMS))
+			if u := body.Usage; u != nil {
+				line += fmt.Sprintf(", %d tokens (%d prompt + %d completion)", u.TotalTokens, u.PromptTokens, u.CompletionTokens)
+			}
+			if len(body.Choices) > 0 {
+				c := body.Choices[0]
+				if c.FinishReason != "" {
+					line += ", finish " + c.FinishReason
+				}
+				fmt.Fprintln(w, line)
+				writeText(w, "assistant", c.Message.Content, o.Full)
+				// The reply is added to the next request; tool results are
+				// shown by their own events
+				sent++
+			} else {
+				fmt.Fprintln(w, line+", no choices")
+			}
+		case TypeError:
+			line := at + "✗ "
+			if e.Model != "" {
+				line += e.Model + " "
+			}
+			if e.Status != 0 {
+				line += fmt.Sprintf("status %d ", e.Status)
+			}
+			if e.DurationMS > 0 {
+				line += "after " + ms(e.DurationMS) + " "
+			}
+			fmt.Fprintln(w, strings.TrimRight(line, " "))
+			writeText(w, "error", e.Error, o.Full)
+		case TypeToolCall:
+			fmt.Fprintf(w, "%s⚙ %s %s\n", at, e.Tool, clip(e.Arguments, 300, o.Full))
+		case TypeToolResult:
+			status := "ok"
+			if e.Failed {
+				status = "failed"
+			}
+			lines := strings.Count(strings.TrimRight(e.Text, "\n"), "\n") + 1
+			fmt.Fprintf(w, "%s  %s %s in %s, %d line(s)\n", at, e.Tool, status, ms(e.DurationMS), lines)
+			writeText(w, "result", e.Text, o.Full)
+			sent++
+		}
+	}
+	if !shown {
+		fmt.Fprintln(w, "\n(no prompts recorded)")
+	}
+	return nil
+}
+
+// writeText prints text indented under a label, cut to a few lines unless
+// full
+func writeText(w io.Writer, label, text string, full bool) {
+	text = strings.TrimRight(text, "\n")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	lines := strings.Split(text, "\n")
+	const maxLines = 6
+	if !full && len(lines) > maxLines {
+		lines = append(lines[:maxLines:maxLines], fmt.Sprintf("… (%d more lines)", len(lines)-maxLines))
+	}
+	pad := strings.Repeat(" ", 22)
+	fmt.Fprintf(w, "%s%s:\n", pad, label)
+	for _, l := range lines {
+		fmt.Fprintf(w, "%s  %s\n", pad, clip(l, 200, full))
+	}
+}
+
+// clip cuts s to n characters unless full
+func clip(s string, n int, full bool) string {
+	if full {
+		return s
+	}
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return s
+}
+
+func ms(d int64) string {
+	if d < 1000 {
+		return fmt.Sprintf("%d ms", d)
+	}
+	return fmt.Sprintf("%.1f s", float64(d)/1000)
+}
@@ -0,0 +1,236 @@
+// Package trace records everything that happens in the agent turns of a
+// session: each chat request sent to the model and the response or error
+// that came back, and each tool call with its arguments, result and
+// timing. Events are appended as JSON lines to <dir>/<session>.jsonl, named
+// after the logger session so a trace and its log lines can be matched.
+//
+// Tracing is off unless [trace] enabled is set (or -trace is passed); while
+// it is off Record does nothing. The file is created on the first event, so
+// a session without prompts leaves no trace behind.
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"craft-cli/internal/config"
+	"craft-cli/internal/logger"
+)
+
+// Event types
+const (
+	TypeSession    = "session"     // first event of a trace; Text is the working directory
+	TypePrompt     = "prompt"      // a user prompt starts an agent turn; Text is the message sent
+	TypeRequest    = "request"     // Body is the ChatRequest
+	TypeResponse   = "response"    // Body is the ChatResponse
+	TypeError      = "error"       // a model call or the agent loop failed
+	TypeToolCall   = "tool_call"   // Arguments are the raw JSON arguments
+	TypeToolResult = "tool_result" // Text is the result given back to the model
+)
+
+// Event is one line of a trace file
+type Event struct {
+	Time       time.Time       `json:"time"`
+	Type       string          `json:"type"`
+	Session    string          `json:"session,omitempty"`
+	Prompt     int             `json:"prompt,omitempty"` // user prompt of the session, from 1
+	Turn       int             `json:"turn,omitempty"`   // model call within the prompt, from 1
+	Model      string          `json:"model,omitempty"`
+	Status     int             `json:"status,omitempty"`
+	Tool       string          `json:"tool,omitempty"`
+	CallID     string          `json:"call_id,omitempty"`
+	Arguments  string          `json:"arguments,omitempty"`
+	Text       string          `json:"text,omitempty"`
+	Failed     bool            `json:"failed,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+// Options configures InitWith
+type Options struct {
+	Dir     string // DefaultDir when empty
+	Session string // file name; the current time when empty
+	Keep    int    // newest trace files kept when a new one is created (0 = all)
+}
+
+var (
+	mu      sync.Mutex
+	enabled bool
+	opts    Options
+	file    *os.File
+	prompt  int
+)
+
+// Init starts tracing when the [trace] section of the loaded configuration
+// enables it
+func Init() error {
+	if !config.Get().Trace.Enabled {
+		return nil
+	}
+	return Start()
+}
+
+// Start begins tracing the logger session with the [trace] settings,
+// whether or not they enable it
+func Start() error {
+	c := config.Get().Trace
+	return InitWith(Options{Dir: c.Dir, Session: logger.SessionID(), Keep: c.Keep})
+}
+
+// InitWith starts tracing, closing any trace started before. Restarting
+// the same session appends to its file and keeps numbering its prompts.
+func InitWith(o Options) error {
+	if o.Dir == "" {
+		o.Dir = DefaultDir()
+	}
+	if o.Session == "" {
+		o.Session = time.Now().Format("20060102-150405")
+	}
+	if strings.ContainsAny(o.Session, `/\`) {
+		return fmt.Errorf("invalid trace session name %q", o.Session)
+	}
+	Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if o.Session != opts.Session || o.Dir != opts.Dir {
+		prompt = 0
+	}
+	opts, enabled = o, true
+	return nil
+}
+
+// Close stops tracing
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	enabled = false
+}
+
+// Enabled reports whether events are being recorded
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Path returns the trace file of the session, or "" when tracing is off
+func Path() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if !enabled {
+		return ""
+	}
+	return filepath.Join(opts.Dir, opts.Session+".jsonl")
+}
+
+// DefaultDir is craft/traces next to the default log file
+func DefaultDir() string {
+	return filepath.Join(filepath.Dir(logger.DefaultPath()), "traces")
+}
+
+// Prompt starts the next user prompt of the session; the events recorded
+// after it belong to that prompt
+func Prompt(text string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !enabled {
+		return
+	}
+	prompt++
+	writeLocked(Event{Type: TypePrompt, Text: text})
+}
+
+// Record appends an event to the trace, stamped with the time and the
+// current prompt
+func Record(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !enabled {
+		return
+	}
+	writeLocked(e)
+}
+
+func writeLocked(e Event) {
+	if file == nil {
+		if err := openLocked(); err != nil {
+			logger.Warn("tracing disabled", logger.Err(err))
+			enabled = false
+			return
+		}
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Prompt == 0 && e.Type != TypeSession {
+		e.Prompt = prompt
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		// A body that is not valid JSON; keep the event without it
+		e.Error, e.Body = fmt.Sprintf("unrecordable body: %v", err), nil
+		line, _ = json.Marshal(e)
+	}
+	file.Write(append(line, '\n'))
+}
+
+// openLocked opens the trace file, writing the session event when it is
+// new and pruning old traces; mu must be held
+func openLocked() error {
+	// Traces hold prompts, file contents and command output: keep them private
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create trace directory: %w", err)
+	}
+	path := filepath.Join(opts.Dir, opts.Session+".jsonl")
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	file = f
+	if os.IsNotExist(statErr) {
+		cwd, _ := os.Getwd()
+		writeLocked(Event{Type: TypeSession, Session: opts.Session, Text: cwd})
+		prune(opts.Dir, opts.Keep)
+		logger.Info("trace started", "path", path)
+	}
+	return nil
+}
+
+// prune deletes the oldest traces in dir beyond the newest keep
+func prune(dir string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	sessions, err := List(dir)
+	if err != nil || len(sessions) <= keep {
+		return
+	}
+	for _, s := range sessions[keep:] {
+		os.Remove(s.Path)
+	}
+}
+
+type turnKey struct{}
+
+// WithTurn tells the model call made with ctx which turn it belongs to
+func WithTurn(ctx context.Context, turn int) context.Context {
+	return context.WithValue(ctx, turnKey{}, turn)
+}
+
+// TurnFrom returns the turn set by WithTurn, or 0
+func TurnFrom(ctx context.Context) int {
+	turn, _ := ctx.Value(turnKey{}).(int)
+	return turn
+}
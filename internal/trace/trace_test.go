@@ -0,0 +1,193 @@
+package trace
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordTurn traces one prompt in which the model calls a tool, then
+// answers
+func recordTurn(t *testing.T, dir, session string) {
+	t.Helper()
+	if err := InitWith(Options{Dir: dir, Session: session}); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	Prompt("list the files")
+	Record(Event{Type: TypeRequest, Turn: 1, Model: "m", Body: json.RawMessage(
+		`{"model":"m","messages":[{"role":"system","content":"sys"},{"role":"user","content":"list the files"}],"tools":[{},{}]}`)})
+	Record(Event{Type: TypeResponse, Turn: 1, Model: "m", Status: 200, DurationMS: 420, Body: json.RawMessage(
+		`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"list_dir"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":30,"completion_tokens":5,"total_tokens":35}}`)})
+	Record(Event{Type: TypeToolCall, Turn: 1, Tool: "list_dir", CallID: "c1", Arguments: `{"path":"."}`})
+	Record(Event{Type: TypeToolResult, Turn: 1, Tool: "list_dir", CallID: "c1", Text: "a.go\nb.go\n", DurationMS: 3})
+	Record(Event{Type: TypeRequest, Turn: 2, Model: "m", Body: json.RawMessage(
+		`{"model":"m","messages":[{},{},{},{}],"tools":[{},{}]}`)})
+	Record(Event{Type: TypeError, Turn: 2, Model: "m", Status: 500, DurationMS: 1500, Error: "API error 500"})
+}
+
+func TestRecordAndRender(t *testing.T) {
+	dir := t.TempDir()
+	recordTurn(t, dir, "s1")
+
+	events, err := Load(filepath.Join(dir, "s1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make([]string, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+		if e.Type != TypeSession && e.Prompt != 1 {
+			t.Errorf("event %d (%s) is in prompt %d, want 1", i, e.Type, e.Prompt)
+		}
+		// Fix the times so the timeline is the same on every run
+		events[i].Time = time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC).Add(time.Duration(i) * 500 * time.Millisecond)
+	}
+	if got := strings.Join(types, " "); got != "session prompt request response tool_call tool_result request error" {
+		t.Fatalf("events = %s", got)
+	}
+	events[0].Text = "/work"
+
+	var b strings.Builder
+	if err := Render(&b, events, RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `Session s1, started 2026-01-02 10:00:00 in /work
+
+── Prompt 1 at 10:00:00
+                      user:
+                        list the files
+     +0.5s  turn 1  → m: 2 messages, 2 tools
+     +1.0s  turn 1  ← m in 420 ms, 35 tokens (30 prompt + 5 completion), finish tool_calls
+     +1.5s  turn 1  ⚙ list_dir {"path":"."}
+     +2.0s  turn 1    list_dir ok in 3 ms, 2 line(s)
+                      result:
+                        a.go
+                        b.go
+     +2.5s  turn 2  → m: 4 messages, 2 tools
+     +3.0s  turn 2  ✗ m status 500 after 1.5 s
+                      error:
+                        API error 500
+`
+	if got := b.String(); got != want {
+		t.Errorf("Render =\n%s\nwant\n%s", got, want)
+	}
+
+	if err := Render(&b, events, RenderOptions{Prompt: 2}); err == nil {
+		t.Error("Render of a missing prompt succeeded")
+	}
+}
+
+func TestRenderFull(t *testing.T) {
+	dir := t.TempDir()
+	recordTurn(t, dir, "s1")
+	events, err := Load(filepath.Join(dir, "s1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := Render(&b, events, RenderOptions{Prompt: 1, Full: true}); err != nil {
+		t.Fatal(err)
+	}
+	// The first request shows every message it sends
+	for _, want := range []string{"system:\n", "  sys\n"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("full timeline lacks %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestRestartKeepsPromptNumbers(t *testing.T) {
+	dir := t.TempDir()
+	recordTurn(t, dir, "s1")
+	recordTurn(t, dir, "s1")
+	events, err := Load(filepath.Join(dir, "s1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, prompts := 0, 0
+	for _, e := range events {
+		switch e.Type {
+		case TypeSession:
+			sessions++
+		case TypePrompt:
+			prompts++
+			if e.Prompt != prompts {
+				t.Errorf("prompt %d numbered %d", prompts, e.Prompt)
+			}
+		}
+	}
+	if sessions != 1 || prompts != 2 {
+		t.Errorf("%d session and %d prompt events, want 1 and 2", sessions, prompts)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	dir := t.TempDir()
+	if err := InitWith(Options{Dir: dir, Session: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	Close()
+	Prompt("ignored")
+	Record(Event{Type: TypeToolCall, Tool: "bash"})
+	if Enabled() || Path() != "" {
+		t.Error("tracing still enabled after Close")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "s1.jsonl")); !os.IsNotExist(err) {
+		t.Error("a session without events left a trace file")
+	}
+	if err := InitWith(Options{Dir: dir, Session: "../s1"}); err == nil {
+		Close()
+		t.Error("session name with a path separator accepted")
+	}
+}
+
+func TestListAndFind(t *testing.T) {
+	dir := t.TempDir()
+	recordTurn(t, dir, "20260101-aaa")
+	recordTurn(t, dir, "20260102-bbb")
+	recordTurn(t, dir, "20260102-bbc")
+	// The newest by modification time comes first
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "20260101-aaa.jsonl"), old, old)
+	newest := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "20260102-bbb.jsonl"), newest, newest)
+
+	sessions, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sessions {
+		got = append(got, s.ID)
+		if s.Prompts != 1 || s.First != "list the files" {
+			t.Errorf("session %s has %d prompts, first %q", s.ID, s.Prompts, s.First)
+		}
+	}
+	if strings.Join(got, " ") != "20260102-bbb 20260102-bbc 20260101-aaa" {
+		t.Errorf("List = %v, want newest first", got)
+	}
+
+	tests := []struct {
+		ref, want string
+		fails     bool
+	}{
+		{"last", "20260102-bbb", false},
+		{"20260101", "20260101-aaa", false},
+		{"20260102-bbc", "20260102-bbc", false},
+		{"20260102-bb", "", true}, // ambiguous
+		{"nope", "", true},
+	}
+	for _, tt := range tests {
+		s, err := Find(dir, tt.ref)
+		if tt.fails != (err != nil) || s.ID != tt.want {
+			t.Errorf("Find(%q) = %q, %v, want %q", tt.ref, s.ID, err, tt.want)
+		}
+	}
+	if sessions, err := List(filepath.Join(dir, "missing")); err != nil || len(sessions) != 0 {
+		t.Errorf("List of a missing directory = %v, %v", sessions, err)
+	}
+}
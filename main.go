@@ -1,15 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"craft-cli/internal/agent"
+	"craft-cli/internal/audit"
+	"craft-cli/internal/config"
 	ctxmgr "craft-cli/internal/context"
 	"craft-cli/internal/groq"
 	"craft-cli/internal/logger"
+	"craft-cli/internal/telemetry"
+	"craft-cli/internal/trace"
 	"craft-cli/internal/tui"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -19,37 +27,150 @@ import (
 )
 
 func main() {
-	godotenv.Load()
+	// The config, .env, index and memories are the project's, wherever in
+	// it craft is started; tools work in the current directory
+	godotenv.Load(filepath.Join(config.ProjectRoot(), ".env"))
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println(tui.ErrorStyle.Render(" [!] " + err.Error()))
+		os.Exit(1)
+	}
+
+	// Subcommands run without the TUI
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			fmt.Printf("# user config:    %s\n# project config: %s\n\n", config.UserPath(), config.ProjectPath())
+			if err := cfg.Write(os.Stdout); err != nil {
+				os.Exit(1)
+			}
+			return
+		case "bench":
+			// ANN vs exact search on a synthetic corpus, for tuning [index] ann_*
+			fs := flag.NewFlagSet("bench", flag.ExitOnError)
+			points := fs.Int("n", 50000, "corpus size")
+			dims := fs.Int("dims", 256, "vector dimensions")
+			queries := fs.Int("queries", 200, "number of queries")
+			k := fs.Int("k", 10, "results per query")
+			m := fs.Int("m", cfg.Index.ANNM, "ANN links per node")
+			efc := fs.Int("ef-construction", cfg.Index.ANNEfConstruction, "ANN build candidate list")
+			efs := fs.Int("ef-search", cfg.Index.ANNEfSearch, "ANN query candidate list")
+			fs.Parse(os.Args[2:])
+			fmt.Println(ctxmgr.BenchmarkANN(*points, *dims, *queries, *k, ctxmgr.ANNParams{M: *m, EfConstruction: *efc, EfSearch: *efs}))
+			return
+		case "index":
+			os.Exit(runIndex(cfg, os.Args[2:]))
+		case "search":
+			os.Exit(runSearch(cfg, os.Args[2:]))
+		case "trace":
+			os.Exit(runTrace(cfg, os.Args[2:]))
+		case "audit":
+			os.Exit(runAudit(os.Args[2:]))
+		}
+	}
+
 	lipgloss.SetColorProfile(termenv.TrueColor)
 	
 	if err := logger.Init(); err != nil {
 		fmt.Printf("Warning: could not initialize logger: %v\n", err)
 	}
 	defer logger.Close()
+	if err := telemetry.Init(); err != nil {
+		fmt.Printf("Warning: could not initialize telemetry: %v\n", err)
+	}
+	defer telemetry.Shutdown()
 
-	themeArg := flag.String("theme", "sunset", "UI theme: sunset or moonlit")
+	themeArg := flag.String("theme", cfg.UI.Theme, "UI theme: sunset or moonlit")
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "theme" {
+			if err := cfg.Set("ui.theme", strings.ToLower(*themeArg), config.SourceFlag); err != nil {
+				fmt.Println(tui.ErrorStyle.Render(" [!] " + err.Error()))
+				os.Exit(1)
+			}
+		}
+	})
 
-	if strings.ToLower(*themeArg) == "moonlit" {
+	if cfg.UI.Theme == "moonlit" {
 		tui.ApplyTheme(tui.MoonlitTheme)
 	} else {
 		tui.ApplyTheme(tui.SunsetTheme)
 	}
 
-	if os.Getenv("GROQ_API_KEY") == "" {
-		fmt.Println(tui.ErrorStyle.Render(" [!] Set GROQ_API_KEY"))
+	if cfg.MissingAPIKey() {
+		fmt.Println(tui.ErrorStyle.Render(" [!] Set " + cfg.Provider.APIKeyEnv))
 		os.Exit(1)
 	}
+	if err := trace.Init(); err != nil {
+		fmt.Printf("Warning: could not start tracing: %v\n", err)
+	}
+	defer trace.Close()
 
 	// Initialize components
 	client := groq.NewClient()
 	toolMgr := agent.NewToolManager(client)
-	ctxGraph := ctxmgr.NewGraph()
-	indexPath := ".craft-index.json"
-	
-	// Load existing context index
-	if err := ctxGraph.Load(indexPath); err == nil {
-		logger.Infof("Loaded existing context index with %d nodes", len(ctxGraph.Nodes))
+	ws := ctxmgr.NewWorkspace()
+	ctxGraph := ws.Graph
+
+	// Load the index of every root; a corrupt or unreadable one is rebuilt
+	migrated, loadErrs := ws.Load()
+	for _, root := range migrated {
+		logger.Info("context index migrated", "from", filepath.Join(root, ctxmgr.LegacyIndexPath), "to", ws.IndexPath(root))
+	}
+	if len(loadErrs) == 0 && ctxGraph.Len() > 0 {
+		logger.Info("context index loaded", "nodes", ctxGraph.Len())
+	}
+	for root, err := range loadErrs {
+		if !errors.Is(err, ctxmgr.ErrIndexCorrupt) && !errors.Is(err, ctxmgr.ErrIndexVersion) {
+			logger.Warn("context index not loaded", "root", root, logger.Err(err))
+			continue
+		}
+		logger.Warn("context index rebuilding", "root", root, logger.Err(err))
+		if summary, err := ctxGraph.Update(root); err != nil {
+			logger.Error("context index rebuild failed", "root", root, logger.Err(err))
+		} else if err := ws.Save(); err != nil {
+			logger.Error("context index save failed", "root", root, logger.Err(err))
+		} else {
+			logger.Info("context index rebuilt", "root", root, "added", len(summary.Added), "changed", len(summary.Changed), "removed", len(summary.Removed), logger.Duration(summary.Duration))
+		}
+	}
+
+	// Keep the index live while files change during the session
+	if ctxGraph.Len() > 0 {
+		watchers := make(map[string]*ctxmgr.Watcher)
+		for _, root := range ws.Roots {
+			watcher, err := ctxGraph.Watch(root, ctxmgr.DefaultDebounce)
+			if err != nil {
+				logger.Warn("context watcher disabled", "root", root, logger.Err(err))
+				continue
+			}
+			watcher.OnUpdate = func(changed []string, err error) {
+				if err != nil {
+					logger.Warn("context watcher update failed", "root", root, logger.Err(err))
+				}
+				if len(changed) > 0 {
+					logger.Info("context watcher re-indexed", "root", root, "files", len(changed))
+					if err := ws.Save(); err != nil {
+						logger.Error("context index save failed", "root", root, logger.Err(err))
+					}
+				}
+			}
+			defer watcher.Close()
+			watchers[root] = watcher
+		}
+		// One hook for every root, so a write is invalidated once. Tools
+		// take paths relative to the working directory, the index relative
+		// to the project root.
+		toolMgr.OnFileWrite(func(path string) {
+			rel, err := ctxmgr.ProjectRel(path)
+			if err != nil {
+				return
+			}
+			if watcher := watchers[ws.RootOf(rel)]; watcher != nil {
+				watcher.Invalidate(rel)
+			}
+		})
 	}
 
 	// Create the Lite UI Model
@@ -66,3 +187,494 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// runIndex implements "craft index [path]": it brings the context index up
+// to date with the files under path, or under every index root when no
+// path is given, and prints what changed
+func runIndex(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	workers := fs.Int("workers", cfg.Index.Workers, "files indexed concurrently")
+	quiet := fs.Bool("q", false, "do not show progress")
+	fs.Parse(args)
+
+	ws := ctxmgr.NewWorkspace()
+	dirs := ws.Roots
+	if fs.NArg() > 0 {
+		dir, err := ctxmgr.ProjectRel(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "craft index: %v\n", err)
+			return 2
+		}
+		if info, err := os.Stat(fs.Arg(0)); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "craft index: %s is not a directory\n", fs.Arg(0))
+			return 2
+		}
+		if ws.RootOf(dir) == "" {
+			fmt.Fprintf(os.Stderr, "craft index: %s is not inside an index root (index.roots: %s)\n", dir, strings.Join(ws.Roots, ", "))
+			return 2
+		}
+		dirs = []string{dir}
+	}
+
+	migrated, loadErrs := ws.Load()
+	for _, root := range migrated {
+		fmt.Printf("📦 Migrated %s to %s\n", filepath.Join(root, ctxmgr.LegacyIndexPath), ws.IndexPath(root))
+	}
+	for root, err := range loadErrs {
+		fmt.Printf(" [!] %s: %v, rebuilding\n", root, err)
+	}
+	graph := ws.Graph
+	if embedder := graph.Embedder(); embedder != nil {
+		fmt.Printf("🧠 Indexing %s with %s\n", strings.Join(dirs, ", "), embedder.Model())
+	} else {
+		fmt.Printf("🧠 Indexing %s for keyword search only (no embedding provider configured)\n", strings.Join(dirs, ", "))
+	}
+
+	opts := ctxmgr.UpdateOptions{Workers: *workers}
+	interactive := false
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		interactive = !*quiet
+	}
+	var lastDraw time.Time
+	if interactive {
+		opts.Progress = func(p ctxmgr.Progress) {
+			if p.Files < p.TotalFiles && time.Since(lastDraw) < 100*time.Millisecond {
+				return
+			}
+			lastDraw = time.Now()
+			fmt.Fprint(os.Stderr, progressLine(p))
+		}
+	}
+
+	changed := len(migrated) > 0 || len(loadErrs) > 0
+	var bytesRead int64
+	failed := 0
+	for _, dir := range dirs {
+		summary, err := graph.UpdateWith(dir, opts)
+		if interactive {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "craft index: %v\n", err)
+			return 1
+		}
+		for path, err := range summary.Failed {
+			fmt.Printf("  [!] %s: %v\n", path, err)
+		}
+		fmt.Printf("✅ %s: %s\n", dir, summary)
+		changed = changed || !summary.Empty()
+		bytesRead += summary.Bytes
+		failed += len(summary.Failed)
+	}
+
+	var indexBytes int64
+	for _, root := range ws.Roots {
+		info, err := os.Stat(ws.IndexPath(root))
+		if err != nil {
+			changed = true
+			continue
+		}
+		indexBytes += info.Size()
+	}
+	if changed {
+		if err := ws.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "craft index: %v\n", err)
+			return 1
+		}
+		indexBytes = 0
+		for _, root := range ws.Roots {
+			if info, err := os.Stat(ws.IndexPath(root)); err == nil {
+				indexBytes += info.Size()
+			}
+		}
+	}
+	if cfg.Retrieval.RepoMap {
+		// Refresh the cached repo map now rather than at the next session start
+		ws.RepoMap(cfg.Retrieval.RepoMapTokens)
+	}
+
+	files := make(map[string]bool)
+	for _, n := range graph.Snapshot() {
+		files[n.Path] = true
+	}
+	fmt.Printf("   Files:  %d (%s read)\n", len(files), formatBytes(bytesRead))
+	fmt.Printf("   Chunks: %d, %d edges\n", graph.Len(), graph.EdgeCount())
+	if len(ws.Roots) == 1 {
+		fmt.Printf("   Index:  %s (%s)\n", ws.IndexPath(ws.Roots[0]), formatBytes(indexBytes))
+	} else {
+		fmt.Printf("   Index:  %d roots (%s)\n", len(ws.Roots), formatBytes(indexBytes))
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runSearch implements `craft search "query" [-k N] [--path glob] [--json]`:
+// a hybrid search of the index printed as ranked, highlighted snippets or
+// as JSON. Like grep it exits 1 when nothing matches.
+func runSearch(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	k := fs.Int("k", 10, "number of results")
+	pathGlob := fs.String("path", "", "only search paths matching this glob or directory, e.g. 'internal/**/*.go'")
+	lang := fs.String("lang", "", "only search files in this language, e.g. go or python")
+	root := fs.String("root", "", "only search this index root (see index.roots)")
+	pkg := fs.String("package", "", "only search this package, by directory or name from its go.mod, package.json, Cargo.toml or pyproject.toml")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	maxLines := fs.Int("lines", 8, "snippet lines per result (0 = the whole chunk)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: craft search "query" [-k N] [--path glob] [--lang L] [--root dir] [--package name] [--json]`)
+		fs.PrintDefaults()
+	}
+	// Flags may come before or after the query words
+	var words []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		words = append(words, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	query := strings.TrimSpace(strings.Join(words, " "))
+	if query == "" {
+		fs.Usage()
+		return 2
+	}
+
+	ws := ctxmgr.NewWorkspace()
+	_, loadErrs := ws.Load()
+	for _, err := range loadErrs {
+		fmt.Fprintf(os.Stderr, "craft search: %v\n", err)
+	}
+	graph := ws.Graph
+	if graph.Len() == 0 {
+		if len(loadErrs) == 0 {
+			fmt.Fprintf(os.Stderr, "craft search: no index at %s; run 'craft index' first\n", cfg.Index.Path)
+		}
+		return 2
+	}
+	filter := ctxmgr.SearchFilter{Path: ctxmgr.ProjectPattern(*pathGlob), Language: *lang, Root: *root, Package: *pkg}
+	results, err := graph.SearchFiltered(query, *k, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "craft search: %v; showing keyword matches only\n", err)
+		results = graph.SearchLexicalFiltered(query, *k, filter)
+	}
+
+	if *asJSON {
+		type hit struct {
+			Path       string  `json:"path"`
+			StartLine  int     `json:"start_line"`
+			EndLine    int     `json:"end_line"`
+			Symbol     string  `json:"symbol,omitempty"`
+			Kind       string  `json:"kind,omitempty"`
+			Score      float64 `json:"score"`
+			Similarity float64 `json:"similarity,omitempty"`
+			Via        string  `json:"via,omitempty"`
+			Content    string  `json:"content"`
+		}
+		hits := make([]hit, 0, len(results))
+		for _, r := range results {
+			n := r.Node
+			hits = append(hits, hit{ctxmgr.DisplayPath(n.Path), n.StartLine, n.EndLine, n.Symbol, n.Kind, r.Score, r.Similarity, r.Via, n.Content})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(hits)
+	} else {
+		printResults(query, results, *maxLines)
+	}
+	if len(results) == 0 {
+		return 1
+	}
+	return 0
+}
+
+// printResults lists search results with their location and a snippet,
+// highlighting the query terms when stdout is a terminal
+func printResults(query string, results []ctxmgr.SearchResult, maxLines int) {
+	if len(results) == 0 {
+		fmt.Println("No matches found")
+		return
+	}
+	color := os.Getenv("NO_COLOR") == ""
+	if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		color = false
+	}
+	style := func(code, text string) string {
+		if !color {
+			return text
+		}
+		return "\033[" + code + "m" + text + "\033[0m"
+	}
+
+	terms := ctxmgr.QueryTerms(query)
+	for i, r := range results {
+		n := r.Node
+		title := style("1;36", n.Location())
+		if n.Symbol != "" {
+			title += " " + strings.TrimSpace(n.Kind+" "+n.Symbol)
+		}
+		fmt.Printf("%d. %s  %s", i+1, title, style("2", fmt.Sprintf("score %.4f", r.Score)))
+		if r.Via != "" {
+			fmt.Print(style("2", "  ← related to "+r.Via))
+		}
+		fmt.Println()
+
+		start, lines := ctxmgr.Excerpt(n, query, maxLines)
+		for j, line := range lines {
+			var b strings.Builder
+			ctxmgr.Highlight(line, terms, func(text string, match bool) {
+				if match {
+					text = style("1;33", text)
+				}
+				b.WriteString(text)
+			})
+			fmt.Printf("%s %s\n", style("2", fmt.Sprintf("%6d │", start+j)), b.String())
+		}
+		fmt.Println()
+	}
+}
+
+// runTrace implements "craft trace list" and
+// "craft trace show <session|last> [-prompt N] [-full]": the traces kept in
+// trace.dir, and the timeline of one of them
+func runTrace(cfg *config.Config, args []string) int {
+	dir := cfg.Trace.Dir
+	if dir == "" {
+		dir = trace.DefaultDir()
+	}
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: craft trace list\n       craft trace show <session|last> [-prompt N] [-full]")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	switch args[0] {
+	case "list":
+		sessions, err := trace.List(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "craft trace: %v\n", err)
+			return 1
+		}
+		if len(sessions) == 0 {
+			fmt.Printf("No traces in %s; run craft with -trace or set [trace] enabled = true\n", dir)
+			return 0
+		}
+		for _, s := range sessions {
+			first := strings.Join(strings.Fields(s.First), " ")
+			if len([]rune(first)) > 60 {
+				first = string([]rune(first)[:60]) + "…"
+			}
+			fmt.Printf("%-16s %s  %3d prompt(s) %9s  %s\n", s.ID, s.Started.Format("2006-01-02 15:04"), s.Prompts, formatBytes(s.Size), first)
+		}
+		return 0
+	case "show":
+		fs := flag.NewFlagSet("trace show", flag.ExitOnError)
+		prompt := fs.Int("prompt", 0, "only show this prompt of the session (0 = all)")
+		full := fs.Bool("full", false, "print texts whole, including the messages sent with each request")
+		// Flags may come before or after the session
+		var ref string
+		rest := args[1:]
+		for {
+			fs.Parse(rest)
+			if fs.NArg() == 0 {
+				break
+			}
+			if ref != "" {
+				return usage()
+			}
+			ref, rest = fs.Arg(0), fs.Args()[1:]
+		}
+		if ref == "" {
+			return usage()
+		}
+		s, err := trace.Find(dir, ref)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "craft trace: %v\n", err)
+			return 1
+		}
+		events, err := trace.Load(s.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "craft trace: %v\n", err)
+			return 1
+		}
+		if err := trace.Render(os.Stdout, events, trace.RenderOptions{Prompt: *prompt, Full: *full}); err != nil {
+			fmt.Fprintf(os.Stderr, "craft trace: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	return usage()
+}
+
+// runAudit implements "craft audit list [-n N] [-type T] [-session S] [-diff] [-json]"
+// and "craft audit verify [-head HASH]" on the audit log
+func runAudit(args []string) int {
+	path := audit.Path()
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: craft audit list [-n N] [-type file_write|command|approval|recovered] [-session ID] [-diff] [-json]\n       craft audit verify [-head HASH]")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("audit list", flag.ExitOnError)
+		n := fs.Int("n", 50, "show the last N matching entries (0 = all)")
+		kind := fs.String("type", "", "only entries of this type: file_write, command, approval or recovered")
+		session := fs.String("session", "", "only entries of this session (an ID prefix is enough)")
+		showDiff := fs.Bool("diff", false, "print the diff of each file write")
+		asJSON := fs.Bool("json", false, "print entries as JSON lines")
+		fs.Parse(args[1:])
+
+		entries, err := audit.Load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "craft audit: %v\n", err)
+			return 1
+		}
+		var shown []audit.Entry
+		for _, e := range entries {
+			if (*kind == "" || e.Type == *kind) && strings.HasPrefix(e.Session, *session) {
+				shown = append(shown, e)
+			}
+		}
+		if *n > 0 && len(shown) > *n {
+			shown = shown[len(shown)-*n:]
+		}
+		if len(shown) == 0 && !*asJSON {
+			fmt.Printf("No audit entries in %s\n", path)
+			return 0
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range shown {
+			if *asJSON {
+				enc.Encode(e)
+				continue
+			}
+			fmt.Printf("#%-5d %s  %-8s %s\n", e.Seq, e.Time.Local().Format("2006-01-02 15:04:05"), e.Session, auditSummary(e))
+			if *showDiff && e.Diff != "" {
+				for _, line := range strings.Split(strings.TrimRight(e.Diff, "\n"), "\n") {
+					fmt.Printf("        %s\n", line)
+				}
+			}
+		}
+		return 0
+	case "verify":
+		fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+		head := fs.String("head", "", "a head hash printed by an earlier verify; fails if the log no longer contains it")
+		fs.Parse(args[1:])
+
+		r, err := audit.Verify(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "craft audit: %v\n", err)
+			return 1
+		}
+		if !r.OK() {
+			fmt.Printf("❌ %s line %d: %s\n", path, r.Line, r.Problem)
+			fmt.Printf("   %d entries before it are intact\n", r.Entries)
+			return 1
+		}
+		if *head != "" && *head != r.Head {
+			entries, _ := audit.Load(path)
+			found := -1
+			for i, e := range entries {
+				if e.Hash == *head {
+					found = i
+				}
+			}
+			if found < 0 {
+				fmt.Printf("❌ %s: head %s is not in the log; it was truncated or replaced\n", path, *head)
+				return 1
+			}
+			fmt.Printf("   %d entries were added after head %s\n", len(entries)-1-found, *head)
+		}
+		fmt.Printf("✅ %s: %d entries, chain intact\n   head %s\n", path, r.Entries, r.Head)
+		return 0
+	}
+	return usage()
+}
+
+// auditSummary describes an audit entry on one line
+func auditSummary(e audit.Entry) string {
+	short := func(hash string) string {
+		if hash == "" {
+			return "(none)"
+		}
+		return hash[:min(len(hash), 12)]
+	}
+	var s string
+	switch e.Type {
+	case audit.TypeFileWrite:
+		added, removed := 0, 0
+		inHunk := false // past the ---/+++ header
+		for _, line := range strings.Split(e.Diff, "\n") {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				inHunk = true
+			case inHunk && strings.HasPrefix(line, "+"):
+				added++
+			case inHunk && strings.HasPrefix(line, "-"):
+				removed++
+			}
+		}
+		s = fmt.Sprintf("write    %s %s  %s -> %s  +%d -%d", e.Tool, e.Path, short(e.Before), short(e.After), added, removed)
+	case audit.TypeCommand:
+		code := "?"
+		if e.ExitCode != nil {
+			code = fmt.Sprint(*e.ExitCode)
+		}
+		s = fmt.Sprintf("command  %s exit %s in %s  %q  (in %s)", e.Tool, code, time.Duration(e.DurationMS)*time.Millisecond, e.Command, e.Cwd)
+	case audit.TypeApproval:
+		subject := e.Command
+		if subject == "" {
+			subject = e.Path
+		}
+		s = fmt.Sprintf("%-8s %s %q by %s", e.Decision, e.Tool, subject, e.By)
+		if e.Reason != "" {
+			s += ": " + e.Reason
+		}
+	case audit.TypeRecovered:
+		s = "recovered " + e.Reason
+	default:
+		s = e.Type
+	}
+	// A command's error is its exit status unless it did not run to completion
+	if e.Error != "" && (e.Type != audit.TypeCommand || e.ExitCode == nil || *e.ExitCode < 0) {
+		s += "  error: " + e.Error
+	}
+	return s
+}
+
+// progressLine draws an indexing progress bar with an ETA
+func progressLine(p ctxmgr.Progress) string {
+	const width = 30
+	done := 1.0
+	if p.TotalBytes > 0 {
+		done = float64(p.Bytes) / float64(p.TotalBytes)
+	}
+	filled := int(done * width)
+	eta := "--"
+	if d := p.ETA(); d > 0 {
+		eta = d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("\r[%s%s] %3.0f%%  %d/%d files  %s/%s  ETA %s\033[K",
+		strings.Repeat("█", filled), strings.Repeat("░", width-filled), done*100,
+		p.Files, p.TotalFiles, formatBytes(p.Bytes), formatBytes(p.TotalBytes), eta)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 
+	"craft-cli/internal/config"
 	ctxmgr "craft-cli/internal/context"
 	"craft-cli/internal/logger"
 	"github.com/joho/godotenv"
@@ -23,53 +23,53 @@ func main() {
 	}
 	defer logger.Close()
 
-	if os.Getenv("GEMINI_API_KEY") == "" {
-		log.Fatalf("GEMINI_API_KEY is not set in environment or .env")
+	if _, err := config.Load(); err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	graph := ctxmgr.NewGraph()
+	if embedder := graph.Embedder(); embedder != nil {
+		fmt.Printf("🧠 Embedding with %s\n", embedder.Model())
+	} else {
+		log.Printf("Warning: no embedding provider configured, using lexical search only")
+	}
 	targetDir := "examples/vite-hello"
+	indexPath := config.Get().Index.Path
 
-	fmt.Printf("🚀 Starting test index of: %s\n", targetDir)
-
-	count := 0
-	err = filepath.Walk(targetDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-
-		// Basic filter
-		if strings.Contains(path, "node_modules") || strings.Contains(path, "dist") {
-			return nil
+	// Start from the saved index so only added or changed files are re-embedded
+	if migrated, err := graph.LoadIndex(indexPath); err == nil {
+		if migrated {
+			fmt.Printf("📦 Migrated %s to %s\n", ctxmgr.LegacyIndexPath, indexPath)
 		}
+		fmt.Printf("📂 Loaded %d nodes from %s\n", len(graph.Nodes), indexPath)
+	} else if !os.IsNotExist(err) {
+		fmt.Printf(" [!] %v, rebuilding\n", err)
+	}
 
-		content, err := os.ReadFile(path)
-		if err != nil {
-			fmt.Printf("  [!] Could not read %s: %v\n", path, err)
-			return nil
-		}
-
-		fmt.Printf("  Indexing [%d] %s...\n", count+1, path)
-		err = graph.AddFile(path, string(content))
-		if err != nil {
-			fmt.Printf("  [!] Error indexing %s: %v\n", path, err)
-		} else {
-			count++
-		}
-		return nil
-	})
+	fmt.Printf("🚀 Updating index of: %s\n", targetDir)
 
+	summary, err := graph.Update(targetDir)
 	if err != nil {
-		log.Fatalf("Walk failed: %v", err)
+		log.Fatalf("Update failed: %v", err)
+	}
+	for _, path := range summary.Added {
+		fmt.Printf("  + %s\n", path)
+	}
+	for _, path := range summary.Changed {
+		fmt.Printf("  ~ %s\n", path)
+	}
+	for _, path := range summary.Removed {
+		fmt.Printf("  - %s\n", path)
+	}
+	for path, err := range summary.Failed {
+		fmt.Printf("  [!] Error indexing %s: %v\n", path, err)
 	}
 
-	fmt.Printf("\n✅ Successfully indexed %d nodes.\n", len(graph.Nodes))
+	fmt.Printf("\n✅ Index has %d nodes (%s).\n", len(graph.Nodes), summary)
 	
-	indexPath := ".craft-index.json"
-	if err := graph.Save(indexPath); err != nil {
+	if summary.Empty() {
+		fmt.Println(" ✨ Index already up to date")
+	} else if err := graph.Save(indexPath); err != nil {
 		fmt.Printf(" [!] Failed to save index: %v\n", err)
 	} else {
 		fmt.Printf(" ✨ Index persisted to %s\n", indexPath)
@@ -102,6 +102,13 @@ func main() {
 		} else {
 			contentPreview = "[Path Node]"
 		}
-		fmt.Printf("%d. [%.4f] %s\n   Snippet: %s\n\n", i+1, res.Similarity, res.Node.Path, strings.ReplaceAll(contentPreview, "\n", " "))
+		location := res.Node.Location()
+		if res.Node.Symbol != "" {
+			location += " (" + res.Node.Symbol + ")"
+		}
+		if res.Via != "" {
+			location += " ← related to " + res.Via
+		}
+		fmt.Printf("%d. [%.4f] %s\n   Snippet: %s\n\n", i+1, res.Score, location, strings.ReplaceAll(contentPreview, "\n", " "))
 	}
 }
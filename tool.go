@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"craft-cli/internal/audit"
+	"craft-cli/internal/config"
+	"craft-cli/internal/telemetry"
+	"craft-cli/internal/trace"
 )
 
 // Tool represents a callable function with metadata and execution logic
@@ -17,11 +24,13 @@ type Tool struct {
 	Execute     func(args map[string]interface{}) (string, error)
 	Category    string                 `json:"category,omitempty"`
 	Timeout     time.Duration          `json:"timeout,omitempty"`
+	Writes      bool                   `json:"-"` // true if a successful call modifies the file at args["path"]
 }
 
 // ToolManager manages tool registration, validation, and execution
 type ToolManager struct {
-	tools map[string]Tool
+	tools      map[string]Tool
+	writeHooks []func(path string)
 }
 
 // NewToolManager creates a new ToolManager instance
@@ -43,13 +52,19 @@ func (tm *ToolManager) Register(tool Tool) error {
 		tool.Parameters = make(map[string]interface{})
 	}
 	if tool.Timeout == 0 {
-		tool.Timeout = 30 * time.Second // Default timeout
+		tool.Timeout = config.Get().Limits.ToolTimeout // Default timeout
 	}
 	
 	tm.tools[tool.Name] = tool
 	return nil
 }
 
+// OnFileWrite registers a hook called with the path of every file a tool
+// marked Writes (such as write_file) changes, e.g. to invalidate index nodes
+func (tm *ToolManager) OnFileWrite(hook func(path string)) {
+	tm.writeHooks = append(tm.writeHooks, hook)
+}
+
 // Get retrieves a tool by name
 func (tm *ToolManager) Get(name string) (Tool, bool) {
 	tool, exists := tm.tools[name]
@@ -65,12 +80,69 @@ func (tm *ToolManager) List() []string {
 	return names
 }
 
-// Execute runs a tool with the given arguments
+// Execute runs a tool with the given arguments, outside of any agent turn
 func (tm *ToolManager) Execute(name string, argsJSON string) (string, error) {
+	return tm.ExecuteContext(context.Background(), name, argsJSON)
+}
+
+// ExecuteContext is Execute recorded as a telemetry span, a child of the
+// span in ctx. Callers with an agent turn pass its context: its span,
+// trace turn and cancellation all apply to the call.
+func (tm *ToolManager) ExecuteContext(ctx context.Context, name string, argsJSON string) (string, error) {
+	_, span := telemetry.Start(ctx, "execute_tool "+name, telemetry.KindInternal,
+		telemetry.String(telemetry.KeyOperation, "execute_tool"),
+		telemetry.String(telemetry.KeyTool, name))
+	defer span.End()
+	result, err := tm.execute(ctx, name, argsJSON)
+	span.SetAttributes(telemetry.Int(telemetry.KeyBytes, len(result)))
+	if name == "bash" && err == nil {
+		span.SetAttributes(telemetry.Int(telemetry.KeyExitCode, bashExitCode(result)))
+	}
+	if err != nil {
+		span.SetError(err)
+	} else if strings.HasPrefix(result, "Error") {
+		line, _, _ := strings.Cut(result, "\n")
+		span.SetStatus(telemetry.StatusError, line)
+	}
+	return result, err
+}
+
+// bashExitCode reads the exit status from a bash tool result, which reports
+// failures as "Error: exit status N"; -1 means the command did not run to
+// completion
+func bashExitCode(result string) int {
+	if !strings.HasPrefix(result, "Error") {
+		return 0
+	}
+	var code int
+	if _, err := fmt.Sscanf(result, "Error: exit status %d", &code); err == nil {
+		return code
+	}
+	return -1
+}
+
+// execute runs the tool and records the call and its result in the trace,
+// under the turn set in ctx
+func (tm *ToolManager) execute(ctx context.Context, name string, argsJSON string) (result string, err error) {
+	turn, start := trace.TurnFrom(ctx), time.Now()
+	trace.Record(trace.Event{Type: trace.TypeToolCall, Turn: turn, Tool: name, Arguments: argsJSON})
+	defer func() {
+		e := trace.Event{Type: trace.TypeToolResult, Turn: turn, Tool: name, Text: result, DurationMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			e.Failed, e.Error = true, err.Error()
+		} else {
+			e.Failed = strings.HasPrefix(result, "Error")
+		}
+		trace.Record(e)
+	}()
+
 	tool, exists := tm.Get(name)
 	if !exists {
 		return "", fmt.Errorf("tool '%s' not found", name)
 	}
+	if config.Get().ToolDisabled(name) {
+		return "", fmt.Errorf("tool '%s' is disabled by config (tools.disabled)", name)
+	}
 
 	// Parse arguments
 	var args map[string]interface{}
@@ -88,7 +160,17 @@ func (tm *ToolManager) Execute(name string, argsJSON string) (string, error) {
 	errChan := make(chan error, 1)
 
 	go func() {
+		// Audited here so a write that outlives the timeout is still recorded
+		var change *audit.Change
+		if tool.Writes {
+			if path, err := getStringArg(args, "path"); err == nil {
+				change = audit.FileChange(name, path)
+			}
+		}
 		result, err := tool.Execute(args)
+		if change != nil {
+			change.Done(err)
+		}
 		if err != nil {
 			errChan <- err
 		} else {
@@ -98,11 +180,20 @@ func (tm *ToolManager) Execute(name string, argsJSON string) (string, error) {
 
 	select {
 	case result := <-resultChan:
+		if tool.Writes {
+			if path, err := getStringArg(args, "path"); err == nil {
+				for _, hook := range tm.writeHooks {
+					hook(path)
+				}
+			}
+		}
 		return result, nil
 	case err := <-errChan:
 		return "", err
 	case <-time.After(tool.Timeout):
 		return "", fmt.Errorf("tool execution timed out after %v", tool.Timeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
 	}
 }
 
@@ -125,6 +216,9 @@ func (tm *ToolManager) validateParameters(tool Tool, args map[string]interface{}
 func (tm *ToolManager) GetToolDefinitions() []ToolDef {
 	var defs []ToolDef
 	for _, tool := range tm.tools {
+		if config.Get().ToolDisabled(tool.Name) {
+			continue
+		}
 		defs = append(defs, ToolDef{
 			Type: "function",
 			Function: FunctionDef{
@@ -171,6 +265,7 @@ func CreateDefaultTools() []Tool {
 			Name:        "write_file",
 			Description: "Write content to a file. Creates file if it doesn't exist, overwrites if it does.",
 			Category:    "filesystem",
+			Writes:      true,
 			Parameters: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
@@ -242,7 +337,7 @@ func CreateDefaultTools() []Tool {
 			Name:        "bash",
 			Description: "Execute a bash command. Use for git operations, running code, checking versions, etc.",
 			Category:    "system",
-			Timeout:     60 * time.Second,
+			Timeout:     config.Get().Limits.BashTimeout,
 			Parameters: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
@@ -260,20 +355,25 @@ func CreateDefaultTools() []Tool {
 				}
 				
 				// Security: Block dangerous commands
-				dangerous := []string{"rm -rf /", "mkfs", ":(){ :|:& };:", "dd if=/dev/zero"}
-				for _, d := range dangerous {
+				policy := config.Get().Tools
+				for _, d := range policy.BlockedCommands {
 					if strings.Contains(command, d) {
+						audit.Decision("bash", command, audit.Denied, "policy", fmt.Sprintf("matches tools.blocked_commands entry %q", d))
 						return "", fmt.Errorf("dangerous command blocked for safety")
 					}
 				}
 				
 				// Additional security check
-				if strings.Contains(command, "sudo") && !strings.Contains(command, "sudo -u") {
+				if config.Get().SudoRefused(command) {
+					audit.Decision("bash", command, audit.Denied, "policy", "sudo is not allowed (tools.allow_sudo)")
 					return "", fmt.Errorf("sudo commands are restricted")
 				}
+				audit.Decision("bash", command, audit.Approved, "policy", "not matched by tools.blocked_commands or tools.allow_sudo")
 				
 				cmd := exec.Command("bash", "-c", command)
+				start := time.Now()
 				output, err := cmd.CombinedOutput()
+				audit.Command("bash", command, time.Since(start), err)
 				if err != nil {
 					return fmt.Sprintf("Error: %v\nOutput: %s", err, string(output)), nil
 				}